@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/config"
 	"github.com/j18e/sbanken-client/pkg/models"
 	"github.com/j18e/sbanken-client/pkg/storage"
 	"github.com/joho/godotenv"
@@ -26,7 +27,17 @@ func init() {
 }
 
 func main() {
-	stor := storage.NewStorage()
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Validate(config.SectionDatabase); err != nil {
+		log.Fatal(err)
+	}
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	var purchases []*models.Purchase
 	now := time.Now()
@@ -78,7 +89,7 @@ func main() {
 		date.Day += 1
 	}
 
-	if err := stor.AddPurchases(purchases); err != nil {
+	if _, err := stor.AddPurchases(purchases); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/export"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// runExport writes purchases from storage to stdout or a file, as in:
+//
+//	sbanken-client export -format csv -from 2019-01-01 -o purchases.csv
+func runExport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "csv", "output format, one of "+strings.Join(export.Formats, ", "))
+	out := fs.String("o", "", "file to write to instead of stdout")
+	filterFlag := filterFlags(fs)
+	fs.Parse(args)
+
+	filter, err := filterFlag()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	ew, err := export.NewWriter(*format, w, export.Options{
+		From:    filter.From,
+		To:      filter.To,
+		Account: filter.Account,
+	})
+	if err != nil {
+		return err
+	}
+
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		return err
+	}
+	if err := stor.EachPurchase(filter, ew.Write); err != nil {
+		return fmt.Errorf("exporting purchases: %w", err)
+	}
+	return ew.Close()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/export"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// importBatchSize is how many purchases are stored at a time.
+const importBatchSize = 500
+
+// runImport stores the purchases of an export, along with their tags and
+// notes, as in:
+//
+//	sbanken-client import purchases.csv
+func runImport(cfg *config.Config, args []string) error {
+	usage := fmt.Sprintf("usage: %s import [flags] [file]", os.Args[0])
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "input format, one of "+strings.Join(export.ReadFormats, ", ")+
+		" (defaults to the file extension)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s\n\nreads stdin if no file is given\n\n", usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	switch fs.NArg() {
+	case 0:
+		if *format == "" {
+			return fmt.Errorf("import: -format is required when reading stdin")
+		}
+	case 1:
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+		if *format == "" {
+			*format = strings.TrimPrefix(filepath.Ext(fs.Arg(0)), ".")
+		}
+	default:
+		return fmt.Errorf("import: only one file can be imported at a time - %s", usage)
+	}
+
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		return err
+	}
+	var stored, added, updated, unchanged, unsplit int
+	var batch []*models.Purchase
+	store := func() error {
+		res, err := stor.AddPurchases(batch)
+		if err != nil {
+			return fmt.Errorf("storing purchases: %w", err)
+		}
+		added += len(res.Inserted)
+		updated += len(res.Updated)
+		unchanged += len(res.Skipped)
+		unsplit += len(res.Unsplit)
+		for _, p := range batch {
+			for _, tag := range p.Tags {
+				if err := stor.AddTag(p.ID, tag); err != nil {
+					return fmt.Errorf("tagging purchase %s: %w", p.ID, err)
+				}
+			}
+			if p.Notes != "" {
+				if err := stor.SetNotes(p.ID, p.Notes); err != nil {
+					return fmt.Errorf("setting the notes of purchase %s: %w", p.ID, err)
+				}
+			}
+		}
+		stored += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	err = export.Read(*format, r, func(p *models.Purchase) error {
+		batch = append(batch, p)
+		if len(batch) < importBatchSize {
+			return nil
+		}
+		return store()
+	})
+	if err == nil && len(batch) > 0 {
+		err = store()
+	}
+	log.Infof("imported %d purchases: %d new, %d updated, %d unchanged", stored, added, updated, unchanged)
+	if unsplit > 0 {
+		log.Warnf("%d purchases changed amount and are no longer split", unsplit)
+	}
+	return err
+}
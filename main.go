@@ -1,74 +1,126 @@
 package main
 
 import (
-	"context"
+	"flag"
+	"fmt"
 	"os"
-	"time"
+	"text/tabwriter"
 
-	"github.com/j18e/sbanken-client/pkg/client"
-	"github.com/j18e/sbanken-client/pkg/notifications"
-	"github.com/j18e/sbanken-client/pkg/server"
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/secrets"
 	"github.com/j18e/sbanken-client/pkg/storage"
 	"github.com/joho/godotenv"
-	"github.com/oklog/run"
 	log "github.com/sirupsen/logrus"
 )
 
-func init() {
-	if _, err := os.Stat(".env"); err != nil {
-		// no .env file present
-		return
-	}
-	if err := godotenv.Load(); err != nil {
-		log.Fatalf("loading .env: %v", err)
+// The files loaded if they exist, unless -env or -config name others.
+const (
+	defaultEnvFile    = ".env"
+	defaultConfigFile = "config.yaml"
+)
+
+// command is something sbanken-client does, as in:
+//
+//	sbanken-client sync
+type command struct {
+	name    string
+	summary string
+	run     func(cfg *config.Config, args []string) error
+	// the configuration sections which must be complete
+	uses []string
+}
+
+var commands = []command{
+	{"serve", "run the web server, syncing and notifications (default)", runServe,
+		[]string{config.SectionSbanken, config.SectionDatabase, config.SectionNotifications}},
+	{"sync", "load the latest purchases from Sbanken once", runSync,
+		[]string{config.SectionSbanken, config.SectionDatabase}},
+	{"backfill", "load the purchases of a period from Sbanken", runBackfill,
+		[]string{config.SectionSbanken, config.SectionDatabase}},
+	{"report", "print or send the spending report", runReport,
+		[]string{config.SectionDatabase, config.SectionNotifications}},
+	{"import", "load purchases from a csv or jsonl export", runImport,
+		[]string{config.SectionDatabase}},
+	{"export", "write purchases out as csv, jsonl, ofx, ledger or beancount", runExport,
+		[]string{config.SectionDatabase}},
+	{"migrate", "bring the database schema up to date", runMigrate,
+		[]string{config.SectionDatabase}},
+	{"purchases", "list, show or delete stored purchases", runPurchases,
+		[]string{config.SectionDatabase}},
+	{"secrets", "list, set or unset the secrets in the encrypted secrets file", runSecrets, nil},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [-config file] [-env file] [command] [flags]\n\ncommands:\n", os.Args[0])
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
 	}
+	tw.Flush()
+	fmt.Fprintf(out, "\nrun %s <command> -h for the flags of a command\n\nglobal flags:\n", os.Args[0])
+	flag.PrintDefaults()
 }
 
 func main() {
-	stor := storage.NewStorage()
-	cli := client.NewClient(stor)
-
-	// make sure everything works a first time
-	if err := cli.Purchases(); err != nil {
+	log.AddHook(secrets.Hook{})
+	flag.Usage = usage
+	configFile := flag.String("config", defaultConfigFile,
+		"yaml configuration file, which environment variables override")
+	envFile := flag.String("env", defaultEnvFile, "file of environment variables to load")
+	flag.Parse()
+	if err := loadEnv(*envFile); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := os.Stat(*configFile); os.IsNotExist(err) && *configFile == defaultConfigFile {
+		*configFile = ""
+	}
+	cfg, err := config.Load(*configFile)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	notifier := notifications.NewNotifier(stor)
-
-	srv := server.NewServer(stor)
-	srv.Routes()
-
-	var g run.Group
-	{
-		// add the data loader
-		ctx, cancel := context.WithCancel(context.Background())
-		g.Add(func() error {
-			return cli.Loop(ctx, 6*time.Hour)
-		}, func(error) {
-			cancel()
-		})
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
-	{
-		// add the http server
-		ctx, cancel := context.WithCancel(context.Background())
-		g.Add(func() error {
-			return srv.Run(ctx)
-		}, func(error) {
-			cancel()
-		})
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cfg.Validate(cmd.uses...); err != nil {
+				log.Fatal(err)
+			}
+			if err := cmd.run(cfg, args); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
 	}
-	{
-		// add the notifier
-		ctx, cancel := context.WithCancel(context.Background())
-		g.Add(func() error {
-			return notifier.Run(ctx)
-		}, func(error) {
-			cancel()
-		})
+	fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// loadEnv sets the environment variables in the given file, which override
+// the configuration file. The default file is optional.
+func loadEnv(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) && path == defaultEnvFile {
+		return nil
+	}
+	if err := godotenv.Load(path); err != nil {
+		return fmt.Errorf("loading %s: %w", path, err)
 	}
+	return nil
+}
 
-	// react to ctrl+c
-	g.Add(run.SignalHandler(context.Background(), os.Interrupt, os.Kill))
+// runMigrate applies the schema and any migrations, which the other commands
+// also do when connecting to the database.
+func runMigrate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Parse(args)
 
-	log.Fatalf("the server was terminated with %v", g.Run())
+	if _, err := storage.NewStorage(cfg.Database); err != nil {
+		return err
+	}
+	log.Info("the database schema is up to date")
+	return nil
 }
@@ -0,0 +1,143 @@
+// Package apiclient is a client for version 1 of the sbanken-client API,
+// which is described by api/openapi.yaml.
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// Client makes requests on behalf of the user owning an API token.
+type Client struct {
+	// BaseURL is where sbanken-client is served, such as
+	// https://spending.example.com.
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Error is an error answered by the API.
+type Error struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// IsNotFound reports whether err is the API answering that what was asked
+// for doesn't exist.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Status == http.StatusNotFound
+}
+
+// do sends a request with the given body, if any, decoding the response into
+// out unless it's nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u := c.BaseURL + "/api/v1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var rd io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	bs, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		var e struct {
+			Error *Error `json:"error"`
+		}
+		if err := json.Unmarshal(bs, &e); err != nil || e.Error == nil {
+			return &Error{Status: res.StatusCode, Message: http.StatusText(res.StatusCode)}
+		}
+		return e.Error
+	}
+	if out == nil || len(bs) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(bs, out); err != nil {
+		return fmt.Errorf("decoding response to %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// Filter narrows down purchases. Zero values don't filter anything.
+type Filter struct {
+	From     models.Date
+	To       models.Date
+	Category string
+	Vendor   string
+	Account  string
+	Min      *int
+	Max      *int
+	Tag      string
+}
+
+func (f Filter) values() url.Values {
+	v := make(url.Values)
+	if !f.From.IsZero() {
+		v.Set("from", f.From.Stamp())
+	}
+	if !f.To.IsZero() {
+		v.Set("to", f.To.Stamp())
+	}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("category", f.Category)
+	set("vendor", f.Vendor)
+	set("account", f.Account)
+	set("tag", f.Tag)
+	if f.Min != nil {
+		v.Set("min", strconv.Itoa(*f.Min))
+	}
+	if f.Max != nil {
+		v.Set("max", strconv.Itoa(*f.Max))
+	}
+	return v
+}
@@ -0,0 +1,67 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// Balances is the state of the household's shared expenses.
+type Balances struct {
+	Balances    []*models.Balance    `json:"balances"`
+	Transfers   []*models.Transfer   `json:"transfers"`
+	Unassigned  []*models.Purchase   `json:"unassigned"`
+	Settlements []*models.Settlement `json:"settlements"`
+}
+
+// Balances returns what the members of the household owe each other, and
+// the transfers which would settle up.
+func (c *Client) Balances(ctx context.Context) (*Balances, error) {
+	var res Balances
+	if err := c.do(ctx, http.MethodGet, "/balances", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *Client) Settlements(ctx context.Context) ([]*models.Settlement, error) {
+	var res []*models.Settlement
+	if err := c.do(ctx, http.MethodGet, "/settlements", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddSettlement records a payment between members of the household, made
+// today unless its date is set. It belongs to the given household if one is
+// set. Its ID and owner are set from the response.
+func (c *Client) AddSettlement(ctx context.Context, st *models.Settlement) error {
+	body := map[string]interface{}{"from": st.From, "to": st.To, "nok": st.NOK}
+	if !st.Date.IsZero() {
+		body["date"] = st.Date.Stamp()
+	}
+	if st.Household != nil {
+		body["household"] = *st.Household
+	}
+	return c.do(ctx, http.MethodPost, "/settlements", nil, body, st)
+}
+
+func (c *Client) DeleteSettlement(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/settlements/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+// AccountPayers lists who pays for the purchases of each account.
+func (c *Client) AccountPayers(ctx context.Context) ([]*models.AccountPayer, error) {
+	var res []*models.AccountPayer
+	if err := c.do(ctx, http.MethodGet, "/account-payers", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) SetAccountPayer(ctx context.Context, account, person string) error {
+	body := models.AccountPayer{Account: account, Person: person}
+	return c.do(ctx, http.MethodPut, "/account-payers", nil, body, nil)
+}
@@ -0,0 +1,210 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// Query selects a page of purchases.
+type Query struct {
+	Filter
+
+	// Text is contained in the vendor, location, category or notes.
+	Text string
+
+	// Sort is the field to sort by, prefixed with a - for descending order.
+	// Defaults to -date.
+	Sort string
+
+	// Limit is the maximum number of purchases returned. Defaults to 100.
+	Limit int
+
+	// Cursor is the NextCursor of the previous page, if any.
+	Cursor string
+}
+
+// Purchases returns a page of the purchases matching the query.
+func (c *Client) Purchases(ctx context.Context, q Query) (*models.PurchasePage, error) {
+	v := q.values()
+	if q.Text != "" {
+		v.Set("q", q.Text)
+	}
+	if q.Sort != "" {
+		v.Set("sort", q.Sort)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	}
+	var page models.PurchasePage
+	if err := c.do(ctx, http.MethodGet, "/purchases", v, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllPurchases returns every purchase matching the query, reading one page
+// after the other.
+func (c *Client) AllPurchases(ctx context.Context, q Query) ([]*models.Purchase, error) {
+	var res []*models.Purchase
+	for {
+		page, err := c.Purchases(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, page.Purchases...)
+		if page.NextCursor == "" {
+			return res, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func (c *Client) Purchase(ctx context.Context, id string) (*models.Purchase, error) {
+	var p models.Purchase
+	if err := c.do(ctx, http.MethodGet, "/purchases/"+url.PathEscape(id), nil, nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (c *Client) DeletePurchase(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/purchases/"+url.PathEscape(id), nil, nil, nil)
+}
+
+func (c *Client) AddTag(ctx context.Context, id, tag string) error {
+	return c.do(ctx, http.MethodPut, "/purchases/"+url.PathEscape(id)+"/tags/"+url.PathEscape(tag), nil, nil, nil)
+}
+
+func (c *Client) RemoveTag(ctx context.Context, id, tag string) error {
+	return c.do(ctx, http.MethodDelete, "/purchases/"+url.PathEscape(id)+"/tags/"+url.PathEscape(tag), nil, nil, nil)
+}
+
+func (c *Client) SetNotes(ctx context.Context, id, notes string) error {
+	body := map[string]string{"notes": notes}
+	return c.do(ctx, http.MethodPut, "/purchases/"+url.PathEscape(id)+"/notes", nil, body, nil)
+}
+
+// SetSplits splits a purchase into parts which add up to its amount.
+func (c *Client) SetSplits(ctx context.Context, id string, splits []*models.Split) error {
+	return c.do(ctx, http.MethodPut, "/purchases/"+url.PathEscape(id)+"/splits", nil, splits, nil)
+}
+
+func (c *Client) RemoveSplits(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/purchases/"+url.PathEscape(id)+"/splits", nil, nil, nil)
+}
+
+// SetShares shares a purchase within the household.
+func (c *Client) SetShares(ctx context.Context, id string, shares []*models.Share) error {
+	return c.do(ctx, http.MethodPut, "/purchases/"+url.PathEscape(id)+"/shares", nil, shares, nil)
+}
+
+func (c *Client) RemoveShares(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/purchases/"+url.PathEscape(id)+"/shares", nil, nil, nil)
+}
+
+// SetPayer sets who paid for a purchase, with an empty person falling back
+// to the payer of its account.
+func (c *Client) SetPayer(ctx context.Context, id, person string) error {
+	body := map[string]string{"person": person}
+	return c.do(ctx, http.MethodPut, "/purchases/"+url.PathEscape(id)+"/payer", nil, body, nil)
+}
+
+// Search finds the purchases with words starting with every word of text,
+// best matches first. A limit of 0 uses the server's default.
+func (c *Client) Search(ctx context.Context, text string, f Filter, limit int) ([]*models.SearchResult, error) {
+	v := f.values()
+	v.Set("q", text)
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	var res []*models.SearchResult
+	if err := c.do(ctx, http.MethodGet, "/search", v, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Totals sums up the purchases matching the filter, grouped by the given
+// fields, if any.
+func (c *Client) Totals(ctx context.Context, f Filter, by ...string) ([]*models.Total, error) {
+	v := f.values()
+	if len(by) > 0 {
+		v.Set("by", strings.Join(by, ","))
+	}
+	var res []*models.Total
+	if err := c.do(ctx, http.MethodGet, "/totals", v, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) Forecast(ctx context.Context, year, month int) (*models.Forecast, error) {
+	var fc models.Forecast
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/forecast/%d/%d", year, month), nil, nil, &fc); err != nil {
+		return nil, err
+	}
+	return &fc, nil
+}
+
+func (c *Client) Subscriptions(ctx context.Context) ([]*models.Subscription, error) {
+	var res []*models.Subscription
+	if err := c.do(ctx, http.MethodGet, "/subscriptions", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// VendorAliases lists the rules renaming the vendors reported by the bank.
+func (c *Client) VendorAliases(ctx context.Context) ([]*models.VendorAlias, error) {
+	var res []*models.VendorAlias
+	if err := c.do(ctx, http.MethodGet, "/vendor-aliases", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetVendorAlias renames the vendor raw to vendor, returning how many
+// purchases were renamed.
+func (c *Client) SetVendorAlias(ctx context.Context, raw, vendor string) (int, error) {
+	var res struct {
+		Renamed int `json:"renamed"`
+	}
+	alias := models.VendorAlias{Raw: raw, Vendor: vendor}
+	if err := c.do(ctx, http.MethodPut, "/vendor-aliases", nil, alias, &res); err != nil {
+		return 0, err
+	}
+	return res.Renamed, nil
+}
+
+// DeleteVendorAlias removes an alias, returning how many purchases were
+// renamed.
+func (c *Client) DeleteVendorAlias(ctx context.Context, raw string) (int, error) {
+	var res struct {
+		Renamed int `json:"renamed"`
+	}
+	if err := c.do(ctx, http.MethodDelete, "/vendor-aliases/"+url.PathEscape(raw), nil, nil, &res); err != nil {
+		return 0, err
+	}
+	return res.Renamed, nil
+}
+
+// ApplyVendorAliases renames the vendors of stored purchases, returning how
+// many were renamed.
+func (c *Client) ApplyVendorAliases(ctx context.Context) (int, error) {
+	var res struct {
+		Renamed int `json:"renamed"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/vendor-aliases/apply", nil, nil, &res); err != nil {
+		return 0, err
+	}
+	return res.Renamed, nil
+}
@@ -0,0 +1,42 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// SyncRuns returns the latest syncs with Sbanken, newest first.
+func (c *Client) SyncRuns(ctx context.Context) ([]*models.SyncRun, error) {
+	var res []*models.SyncRun
+	if err := c.do(ctx, http.MethodGet, "/sync", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) SyncRun(ctx context.Context, id int) (*models.SyncRun, error) {
+	var run models.SyncRun
+	if err := c.do(ctx, http.MethodGet, "/sync/"+strconv.Itoa(id), nil, nil, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Sync loads purchases from Sbanken, joining the sync which is already
+// running if any. If wait is true the sync is returned once it's done,
+// otherwise while it's running.
+func (c *Client) Sync(ctx context.Context, wait bool) (*models.SyncRun, error) {
+	var q url.Values
+	if wait {
+		q = url.Values{"wait": {"true"}}
+	}
+	var run models.SyncRun
+	if err := c.do(ctx, http.MethodPost, "/sync", q, nil, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
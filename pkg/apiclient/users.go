@@ -0,0 +1,143 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// Me returns the user owning the token.
+func (c *Client) Me(ctx context.Context) (*models.User, error) {
+	var u models.User
+	if err := c.do(ctx, http.MethodGet, "/me", nil, nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UpdateMe sets the budget and pushover user key of the user owning the
+// token. A nil budget removes theirs, while a nil pushover user key keeps it.
+func (c *Client) UpdateMe(ctx context.Context, budget *int, pushoverUser *string) (*models.User, error) {
+	body := map[string]interface{}{"budget": budget}
+	if pushoverUser != nil {
+		body["pushover_user"] = *pushoverUser
+	}
+	var u models.User
+	if err := c.do(ctx, http.MethodPut, "/me", nil, body, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (c *Client) Users(ctx context.Context) ([]*models.User, error) {
+	var res []*models.User
+	if err := c.do(ctx, http.MethodGet, "/users", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddUser creates a user with the given role, which defaults to viewer.
+func (c *Client) AddUser(ctx context.Context, username, password, role string) (*models.User, error) {
+	body := map[string]string{"username": username, "password": password}
+	if role != "" {
+		body["role"] = role
+	}
+	var u models.User
+	if err := c.do(ctx, http.MethodPost, "/users", nil, body, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UpdateUser sets the role, budget and pushover user key of a user.
+func (c *Client) UpdateUser(ctx context.Context, u *models.User) error {
+	body := map[string]interface{}{"role": u.Role, "budget": u.Budget, "pushover_user": u.PushoverUser}
+	return c.do(ctx, http.MethodPut, "/users/"+url.PathEscape(u.Username), nil, body, u)
+}
+
+func (c *Client) DeleteUser(ctx context.Context, username string) error {
+	return c.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(username), nil, nil, nil)
+}
+
+func (c *Client) Households(ctx context.Context) ([]*models.Household, error) {
+	var res []*models.Household
+	if err := c.do(ctx, http.MethodGet, "/households", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) AddHousehold(ctx context.Context, name string, budget *int) (*models.Household, error) {
+	body := map[string]interface{}{"name": name, "budget": budget}
+	var h models.Household
+	if err := c.do(ctx, http.MethodPost, "/households", nil, body, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (c *Client) DeleteHousehold(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/households/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+func (c *Client) SetHouseholdBudget(ctx context.Context, id int, budget *int) error {
+	body := map[string]interface{}{"budget": budget}
+	return c.do(ctx, http.MethodPut, "/households/"+strconv.Itoa(id)+"/budget", nil, body, nil)
+}
+
+func (c *Client) AddHouseholdMember(ctx context.Context, id int, username string) error {
+	return c.do(ctx, http.MethodPut, "/households/"+strconv.Itoa(id)+"/members/"+url.PathEscape(username), nil, nil, nil)
+}
+
+func (c *Client) RemoveHouseholdMember(ctx context.Context, id int, username string) error {
+	return c.do(ctx, http.MethodDelete, "/households/"+strconv.Itoa(id)+"/members/"+url.PathEscape(username), nil, nil, nil)
+}
+
+// Accounts lists the accounts the user owning the token can see.
+func (c *Client) Accounts(ctx context.Context) ([]*models.Account, error) {
+	var res []*models.Account
+	if err := c.do(ctx, http.MethodGet, "/accounts", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetAccountOwner gives an account to the user or household set on it, or
+// to nobody if neither is.
+func (c *Client) SetAccountOwner(ctx context.Context, a *models.Account) error {
+	body := map[string]interface{}{}
+	if a.Owner != "" {
+		body["owner"] = a.Owner
+	}
+	if a.Household != nil {
+		body["household"] = *a.Household
+	}
+	return c.do(ctx, http.MethodPut, "/accounts/"+url.PathEscape(a.Name)+"/owner", nil, body, a)
+}
+
+// Tokens lists the API tokens of the user owning the token, without the
+// tokens themselves.
+func (c *Client) Tokens(ctx context.Context) ([]*models.APIToken, error) {
+	var res []*models.APIToken
+	if err := c.do(ctx, http.MethodGet, "/tokens", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CreateToken creates an API token, which is only ever returned here.
+func (c *Client) CreateToken(ctx context.Context, name string) (*models.APIToken, error) {
+	var tok models.APIToken
+	if err := c.do(ctx, http.MethodPost, "/tokens", nil, map[string]string{"name": name}, &tok); err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
+
+func (c *Client) DeleteToken(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/tokens/"+strconv.Itoa(id), nil, nil, nil)
+}
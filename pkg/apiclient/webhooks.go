@@ -0,0 +1,76 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// Webhooks lists the webhooks, without their secrets.
+func (c *Client) Webhooks(ctx context.Context) ([]*models.Webhook, error) {
+	var res []*models.Webhook
+	if err := c.do(ctx, http.MethodGet, "/webhooks", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Webhook returns a webhook, without its secret.
+func (c *Client) Webhook(ctx context.Context, id int) (*models.Webhook, error) {
+	var w models.Webhook
+	if err := c.do(ctx, http.MethodGet, "/webhooks/"+strconv.Itoa(id), nil, nil, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// AddWebhook creates a webhook, setting its ID and its secret, which is
+// generated unless one is set.
+func (c *Client) AddWebhook(ctx context.Context, w *models.Webhook) error {
+	return c.do(ctx, http.MethodPost, "/webhooks", nil, webhookBody(w), w)
+}
+
+// UpdateWebhook replaces the URL, events and active state of a webhook, along
+// with its secret if one is set.
+func (c *Client) UpdateWebhook(ctx context.Context, w *models.Webhook) error {
+	return c.do(ctx, http.MethodPut, "/webhooks/"+strconv.Itoa(w.ID), nil, webhookBody(w), w)
+}
+
+func webhookBody(w *models.Webhook) map[string]interface{} {
+	body := map[string]interface{}{"url": w.URL, "events": w.Events, "active": w.Active}
+	if w.Secret != "" {
+		body["secret"] = w.Secret
+	}
+	if w.Events == nil {
+		body["events"] = []string{}
+	}
+	return body
+}
+
+func (c *Client) DeleteWebhook(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/webhooks/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+// WebhookDeliveries returns the latest attempts at delivering events to a
+// webhook, newest first.
+func (c *Client) WebhookDeliveries(ctx context.Context, id int) ([]*models.WebhookDelivery, error) {
+	var res []*models.WebhookDelivery
+	if err := c.do(ctx, http.MethodGet, "/webhooks/"+strconv.Itoa(id)+"/deliveries", nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PingWebhook sends a ping event to a webhook in the background, returning
+// the ID of the event to look for among its deliveries.
+func (c *Client) PingWebhook(ctx context.Context, id int) (string, error) {
+	var res struct {
+		EventID string `json:"event_id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/webhooks/"+strconv.Itoa(id)+"/ping", nil, nil, &res); err != nil {
+		return "", err
+	}
+	return res.EventID, nil
+}
@@ -0,0 +1,214 @@
+// Package auth logs users in to the web UI and API, either with a password,
+// through an OpenID Connect provider or with an API token.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned when a username, password or token is
+// wrong. It deliberately doesn't say which.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// dummyHash is compared against when there's no password to check, so that
+// how long a failed login takes doesn't tell which usernames exist.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// Store keeps users, sessions and API tokens. It's implemented by
+// *storage.Storage.
+type Store interface {
+	AddUser(username, passwordHash, role string) error
+	PasswordHash(username string) (string, error)
+	SetPasswordHash(username, passwordHash string) error
+	AddSession(tokenHash string, sess *models.Session) error
+	GetSession(tokenHash string) (*models.Session, error)
+	DeleteSession(tokenHash string) error
+	AddAPIToken(username, tokenHash string, tok *models.APIToken) error
+	APITokenUser(tokenHash string) (string, error)
+	GetUser(username string) (*models.User, error)
+	OIDCUser(issuer, subject string) (string, error)
+	AddOIDCIdentity(issuer, subject, username string) (bool, error)
+	LinkOIDCIdentity(issuer, subject, username string) (bool, error)
+}
+
+// Auth issues and checks sessions and API tokens.
+type Auth struct {
+	storage       Store
+	sessionTTL    time.Duration
+	SecureCookies bool
+	oidc          *oidcProvider
+}
+
+func NewAuth(conf config.Auth, stor Store) (*Auth, error) {
+	oidc, err := newOIDCProvider(conf.OIDC)
+	if err != nil {
+		return nil, err
+	}
+	a := &Auth{
+		storage:       stor,
+		sessionTTL:    conf.SessionTTL,
+		SecureCookies: conf.SecureCookies,
+		oidc:          oidc,
+	}
+
+	// make sure there's someone who can log in
+	if conf.AdminUser != "" {
+		if conf.AdminPassword == "" {
+			return nil, errors.New("an admin password is required when an admin user is set")
+		}
+		if err := a.bootstrapUser(conf.AdminUser, conf.AdminPassword); err != nil {
+			return nil, fmt.Errorf("creating admin user: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// bootstrapUser creates an admin with the given password, or resets the
+// password of an existing user if it has changed.
+func (a *Auth) bootstrapUser(username, password string) error {
+	hash, err := a.storage.PasswordHash(username)
+	if err == storage.ErrNotFound {
+		return a.AddUser(username, password, models.RoleAdmin)
+	} else if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+		return nil
+	}
+	return a.SetPassword(username, password)
+}
+
+// AddUser creates a user who logs in with a password.
+func (a *Auth) AddUser(username, password, role string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return a.storage.AddUser(username, string(hash), role)
+}
+
+// SetPassword changes the password of a user.
+func (a *Auth) SetPassword(username, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return a.storage.SetPasswordHash(username, string(hash))
+}
+
+// Login checks a user's password and starts a session for them, returning
+// the session token.
+func (a *Auth) Login(username, password string) (string, *models.Session, error) {
+	hash, err := a.storage.PasswordHash(username)
+	if err != nil && err != storage.ErrNotFound {
+		return "", nil, err
+	}
+	if hash == "" {
+		// unknown users and those who log in through single sign-on take as
+		// long to turn away as a wrong password
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return "", nil, ErrInvalidCredentials
+	}
+	return a.StartSession(username)
+}
+
+// StartSession starts a session for a user who has already been
+// authenticated, returning the session token.
+func (a *Auth) StartSession(username string) (string, *models.Session, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	sess := &models.Session{
+		Username:  username,
+		CSRFToken: csrf,
+		Expires:   time.Now().Add(a.sessionTTL),
+	}
+	if err := a.storage.AddSession(hashToken(token), sess); err != nil {
+		return "", nil, fmt.Errorf("saving session: %w", err)
+	}
+	return token, sess, nil
+}
+
+// Session returns the session belonging to a session token, as long as it
+// hasn't expired.
+func (a *Auth) Session(token string) (*models.Session, error) {
+	sess, err := a.storage.GetSession(hashToken(token))
+	if err == storage.ErrNotFound {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(sess.Expires) {
+		return nil, ErrInvalidCredentials
+	}
+	return sess, nil
+}
+
+// Logout ends a session.
+func (a *Auth) Logout(token string) error {
+	return a.storage.DeleteSession(hashToken(token))
+}
+
+// CreateToken creates an API token for a user. The returned token is the
+// only place the token itself can be read.
+func (a *Auth) CreateToken(username, name string) (*models.APIToken, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	tok := &models.APIToken{Name: name, Token: token}
+	if err := a.storage.AddAPIToken(username, hashToken(token), tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// TokenUser returns the user owning an API token.
+func (a *Auth) TokenUser(token string) (string, error) {
+	username, err := a.storage.APITokenUser(hashToken(token))
+	if err == storage.ErrNotFound {
+		return "", ErrInvalidCredentials
+	}
+	return username, err
+}
+
+// LoginCSRFToken returns a token protecting the login form, which is posted
+// before there's a session to hold a CSRF token.
+func (a *Auth) LoginCSRFToken() (string, error) {
+	return randomToken()
+}
+
+// randomToken returns 256 random bits encoded for use in cookies and headers.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the form in which tokens are stored, so that a leaked
+// database doesn't contain usable credentials.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// memStore keeps users, sessions and tokens in memory. Unlike the database it
+// returns sessions whether or not they've expired.
+type memStore struct {
+	hashes     map[string]string
+	roles      map[string]string
+	sessions   map[string]*models.Session
+	tokens     map[string]string
+	identities map[[2]string]string
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		hashes:     make(map[string]string),
+		roles:      make(map[string]string),
+		sessions:   make(map[string]*models.Session),
+		tokens:     make(map[string]string),
+		identities: make(map[[2]string]string),
+	}
+}
+
+func (m *memStore) AddUser(username, passwordHash, role string) error {
+	m.hashes[username] = passwordHash
+	m.roles[username] = role
+	return nil
+}
+
+func (m *memStore) PasswordHash(username string) (string, error) {
+	hash, ok := m.hashes[username]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return hash, nil
+}
+
+func (m *memStore) SetPasswordHash(username, passwordHash string) error {
+	if _, ok := m.hashes[username]; !ok {
+		return storage.ErrNotFound
+	}
+	m.hashes[username] = passwordHash
+	return nil
+}
+
+func (m *memStore) AddSession(tokenHash string, sess *models.Session) error {
+	m.sessions[tokenHash] = sess
+	return nil
+}
+
+func (m *memStore) GetSession(tokenHash string) (*models.Session, error) {
+	sess, ok := m.sessions[tokenHash]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return sess, nil
+}
+
+func (m *memStore) DeleteSession(tokenHash string) error {
+	delete(m.sessions, tokenHash)
+	return nil
+}
+
+func (m *memStore) AddAPIToken(username, tokenHash string, tok *models.APIToken) error {
+	m.tokens[tokenHash] = username
+	return nil
+}
+
+func (m *memStore) APITokenUser(tokenHash string) (string, error) {
+	username, ok := m.tokens[tokenHash]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return username, nil
+}
+
+func (m *memStore) GetUser(username string) (*models.User, error) {
+	role, ok := m.roles[username]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &models.User{Username: username, Role: role}, nil
+}
+
+func (m *memStore) OIDCUser(issuer, subject string) (string, error) {
+	username, ok := m.identities[[2]string{issuer, subject}]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return username, nil
+}
+
+func (m *memStore) AddOIDCIdentity(issuer, subject, username string) (bool, error) {
+	if _, ok := m.hashes[username]; !ok {
+		return false, storage.ErrNotFound
+	}
+	for id, u := range m.identities {
+		if id == [2]string{issuer, subject} || id[0] == issuer && u == username {
+			return false, nil
+		}
+	}
+	m.identities[[2]string{issuer, subject}] = username
+	return true, nil
+}
+
+func (m *memStore) LinkOIDCIdentity(issuer, subject, username string) (bool, error) {
+	if u, ok := m.identities[[2]string{issuer, subject}]; ok {
+		return u == username, nil
+	}
+	for id, u := range m.identities {
+		if id[0] == issuer && u == username {
+			delete(m.identities, id)
+		}
+	}
+	return m.AddOIDCIdentity(issuer, subject, username)
+}
+
+func newTestAuth(t *testing.T, ttl time.Duration) (*Auth, *memStore) {
+	t.Helper()
+	store := newMemStore()
+	a, err := NewAuth(config.Auth{AdminUser: "admin", AdminPassword: "hunter22", SessionTTL: ttl}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, store
+}
+
+func TestBootstrapUser(t *testing.T) {
+	a, store := newTestAuth(t, time.Hour)
+	if store.roles["admin"] != models.RoleAdmin {
+		t.Errorf("admin has role %q, want %q", store.roles["admin"], models.RoleAdmin)
+	}
+	if store.hashes["admin"] == "hunter22" {
+		t.Error("the password is stored in plain text")
+	}
+
+	// a changed password is reset, the old one no longer works
+	if err := a.bootstrapUser("admin", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Login("admin", "hunter22"); err != ErrInvalidCredentials {
+		t.Errorf("logging in with the old password: got %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, _, err := a.Login("admin", "correct horse"); err != nil {
+		t.Errorf("logging in with the new password: %v", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	a, store := newTestAuth(t, time.Hour)
+	// users of single sign-on have no password
+	store.hashes["sso"] = ""
+
+	for _, tc := range []struct {
+		name, username, password string
+		err                      error
+	}{
+		{"right password", "admin", "hunter22", nil},
+		{"wrong password", "admin", "hunter2", ErrInvalidCredentials},
+		{"unknown user", "nobody", "hunter22", ErrInvalidCredentials},
+		{"no password", "sso", "", ErrInvalidCredentials},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			token, sess, err := a.Login(tc.username, tc.password)
+			if err != tc.err {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			if token == "" || sess.CSRFToken == "" || token == sess.CSRFToken {
+				t.Errorf("got token %q and csrf token %q, want two different tokens", token, sess.CSRFToken)
+			}
+			if _, ok := store.sessions[token]; ok {
+				t.Error("the session token is stored as is rather than hashed")
+			}
+			got, err := a.Session(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Username != tc.username {
+				t.Errorf("session belongs to %q, want %q", got.Username, tc.username)
+			}
+		})
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	a, _ := newTestAuth(t, -time.Minute)
+	token, _, err := a.Login("admin", "hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Session(token); err != ErrInvalidCredentials {
+		t.Errorf("expired session: got %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	a, _ := newTestAuth(t, time.Hour)
+	token, _, err := a.Login("admin", "hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Logout(token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Session(token); err != ErrInvalidCredentials {
+		t.Errorf("session after logging out: got %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := a.Session(""); err != ErrInvalidCredentials {
+		t.Errorf("empty session token: got %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	a, store := newTestAuth(t, time.Hour)
+	tok, err := a.CreateToken("admin", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.tokens[tok.Token]; ok {
+		t.Error("the api token is stored as is rather than hashed")
+	}
+	if username, err := a.TokenUser(tok.Token); err != nil || username != "admin" {
+		t.Errorf("got user %q and error %v, want admin", username, err)
+	}
+	if _, err := a.TokenUser(tok.Token + "x"); err != ErrInvalidCredentials {
+		t.Errorf("wrong token: got %v, want %v", err, ErrInvalidCredentials)
+	}
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+	"golang.org/x/oauth2"
+)
+
+// ErrNotLinked is returned when someone logs in through single sign-on as a
+// user who has a password or is an admin, but hasn't linked their identity at
+// the provider to their account. Going by the name the provider gives would
+// let anyone who can pick that name there take the account over.
+var ErrNotLinked = errors.New("this account has to be linked to single sign-on after logging in with its password")
+
+// oidcProvider logs users in with the authorization code flow of an OpenID
+// Connect provider.
+type oidcProvider struct {
+	conf        oauth2.Config
+	issuer      string
+	userInfoURL string
+	keys        *keySet
+	autoCreate  bool
+	cli         *http.Client
+}
+
+// newOIDCProvider returns nil unless an OpenID Connect issuer is configured.
+func newOIDCProvider(conf config.OIDC) (*oidcProvider, error) {
+	if conf.Issuer == "" {
+		return nil, nil
+	}
+	if conf.ClientID == "" || conf.RedirectURL == "" {
+		return nil, errors.New("an openid connect client ID and redirect URL are required when an issuer is set")
+	}
+
+	cli := &http.Client{Timeout: 10 * time.Second}
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	discoveryURL := strings.TrimSuffix(conf.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(cli, discoveryURL, &discovery); err != nil {
+		return nil, fmt.Errorf("discovering openid connect provider: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(conf.Issuer, "/") {
+		return nil, fmt.Errorf("openid connect provider at %s calls itself %q", conf.Issuer, discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, errors.New("openid connect provider publishes no signing keys")
+	}
+
+	return &oidcProvider{
+		conf: oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Scopes:       []string{"openid", "profile", "email"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+		},
+		issuer:      discovery.Issuer,
+		userInfoURL: discovery.UserInfoEndpoint,
+		keys:        &keySet{url: discovery.JWKSURI, cli: cli},
+		autoCreate:  conf.AutoCreate,
+		cli:         cli,
+	}, nil
+}
+
+// OIDCEnabled reports whether users can log in through single sign-on.
+func (a *Auth) OIDCEnabled() bool {
+	return a.oidc != nil
+}
+
+// OIDCState returns a random state to pass through the login redirect, and a
+// random nonce which the provider includes in the ID token it issues. Both
+// have to be kept until the provider redirects back.
+func (a *Auth) OIDCState() (state, nonce string, err error) {
+	if state, err = randomToken(); err != nil {
+		return "", "", err
+	}
+	if nonce, err = randomToken(); err != nil {
+		return "", "", err
+	}
+	return state, nonce, nil
+}
+
+// OIDCAuthURL returns the provider's login page.
+func (a *Auth) OIDCAuthURL(state, nonce string) string {
+	return a.oidc.conf.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce))
+}
+
+// OIDCLogin exchanges the code the provider redirected back with for the
+// user's identity and starts a session for the user it belongs to.
+//
+// Identities which haven't been seen before are linked to the user of the
+// same name if that user has neither a password nor the admin role, which is
+// how users created by single sign-on before identities were recorded carry
+// on. Other users have to link their identity with OIDCLink first. Unknown
+// users are only let in if OIDC_AUTO_CREATE is set, and start out as viewers.
+func (a *Auth) OIDCLogin(ctx context.Context, code, nonce string) (string, *models.Session, error) {
+	claims, err := a.oidc.identity(ctx, code, nonce)
+	if err != nil {
+		return "", nil, err
+	}
+	username, err := a.storage.OIDCUser(a.oidc.issuer, claims.Subject)
+	if err == nil {
+		return a.StartSession(username)
+	} else if err != storage.ErrNotFound {
+		return "", nil, err
+	}
+
+	username = claims.username()
+	hash, err := a.storage.PasswordHash(username)
+	switch {
+	case err == storage.ErrNotFound:
+		if !a.oidc.autoCreate {
+			return "", nil, ErrInvalidCredentials
+		}
+		if err := a.storage.AddUser(username, "", models.RoleViewer); err != nil {
+			return "", nil, fmt.Errorf("creating user: %w", err)
+		}
+	case err != nil:
+		return "", nil, err
+	case hash != "":
+		return "", nil, ErrNotLinked
+	default:
+		u, err := a.storage.GetUser(username)
+		if err != nil {
+			return "", nil, err
+		}
+		if u.Role == models.RoleAdmin {
+			return "", nil, ErrNotLinked
+		}
+	}
+
+	// the user may have been linked to another identity already
+	if ok, err := a.storage.AddOIDCIdentity(a.oidc.issuer, claims.Subject, username); err != nil {
+		return "", nil, fmt.Errorf("linking identity: %w", err)
+	} else if !ok {
+		return "", nil, ErrNotLinked
+	}
+	return a.StartSession(username)
+}
+
+// OIDCLink exchanges the code the provider redirected back with for an
+// identity, and links it to a user who is already logged in so that they
+// can log in through single sign-on from then on. It replaces the identity
+// the user had linked before, and fails with ErrInvalidCredentials if the
+// identity belongs to another user.
+func (a *Auth) OIDCLink(ctx context.Context, code, nonce, username string) error {
+	claims, err := a.oidc.identity(ctx, code, nonce)
+	if err != nil {
+		return err
+	}
+	ok, err := a.storage.LinkOIDCIdentity(a.oidc.issuer, claims.Subject, username)
+	if err != nil {
+		return fmt.Errorf("linking identity: %w", err)
+	}
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// identity exchanges a code for tokens and returns the claims of the
+// verified ID token. The name of the user is looked up at the userinfo
+// endpoint if the token doesn't include one.
+func (p *oidcProvider) identity(ctx context.Context, code, nonce string) (*idClaims, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.cli)
+	tok, err := p.conf.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+	raw, _ := tok.Extra("id_token").(string)
+	if raw == "" {
+		return nil, errors.New("provider returned no id token")
+	}
+	claims, err := p.verifyIDToken(raw, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if claims.PreferredUsername != "" || claims.Email != "" || p.userInfoURL == "" {
+		return claims, nil
+	}
+
+	var info struct {
+		Subject           string `json:"sub"`
+		PreferredUsername string `json:"preferred_username"`
+		Email             string `json:"email"`
+	}
+	if err := getJSON(p.conf.Client(ctx, tok), p.userInfoURL, &info); err != nil {
+		return nil, fmt.Errorf("getting user info: %w", err)
+	}
+	if info.Subject != claims.Subject {
+		return nil, errors.New("user info is about another user than the id token")
+	}
+	claims.PreferredUsername, claims.Email = info.PreferredUsername, info.Email
+	return claims, nil
+}
+
+// username is the name a user logging in for the first time gets.
+func (c *idClaims) username() string {
+	if c.PreferredUsername != "" {
+		return c.PreferredUsername
+	}
+	if c.Email != "" {
+		return c.Email
+	}
+	return c.Subject
+}
+
+func getJSON(cli *http.Client, url string, dest interface{}) error {
+	res, err := cli.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status %s from %s", res.Status, url)
+	}
+	return json.NewDecoder(res.Body).Decode(dest)
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// testProvider is an OpenID Connect provider which issues the ID token
+// queued up for each code.
+type testProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	tokens map[string]string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProvider{key: key, tokens: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]map[string]string{"keys": {{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idToken, ok := p.tokens[r.FormValue("code")]
+		if !ok {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// claims returns the claims of a valid ID token for the subject.
+func (p *testProvider) claims(subject, username, nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                p.URL,
+		"sub":                subject,
+		"aud":                "sbanken",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"preferred_username": username,
+	}
+}
+
+// code queues up an ID token with the given claims, signed with the key.
+func (p *testProvider) code(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := randomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.tokens[code] = signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+	return code
+}
+
+func newOIDCAuth(t *testing.T, p *testProvider, autoCreate bool) (*Auth, *memStore) {
+	t.Helper()
+	store := newMemStore()
+	a, err := NewAuth(config.Auth{AdminUser: "admin", AdminPassword: "hunter22", SessionTTL: time.Hour,
+		OIDC: config.OIDC{Issuer: p.URL, ClientID: "sbanken", RedirectURL: "http://localhost/login/oidc/callback",
+			AutoCreate: autoCreate}}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// users who logged in through single sign-on before identities were
+	// recorded have neither a password nor an identity
+	store.AddUser("carol", "", models.RoleViewer)
+	store.AddUser("root", "", models.RoleAdmin)
+	return a, store
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := newTestProvider(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name              string
+		autoCreate        bool
+		subject, username string
+		// change makes the token differ from a valid one for the subject
+		change func(claims map[string]interface{})
+		key    *rsa.PrivateKey
+		want   string
+		err    error
+	}{
+		{name: "new user", autoCreate: true, subject: "s1", username: "dave", want: "dave"},
+		{name: "new user not created", subject: "s1", username: "dave", err: ErrInvalidCredentials},
+		{name: "user from before identities", subject: "s1", username: "carol", want: "carol"},
+		{name: "user with a password", autoCreate: true, subject: "s1", username: "admin", err: ErrNotLinked},
+		{name: "admin without a password", subject: "s1", username: "root", err: ErrNotLinked},
+		{name: "name from the email", autoCreate: true, subject: "s1", change: func(c map[string]interface{}) {
+			c["email"] = "dave@example.com"
+		}, want: "dave@example.com"},
+		{name: "name from the subject", autoCreate: true, subject: "s1", want: "s1"},
+		{name: "wrong nonce", subject: "s1", username: "carol", change: func(c map[string]interface{}) {
+			c["nonce"] = "replayed"
+		}},
+		{name: "wrong issuer", subject: "s1", username: "carol", change: func(c map[string]interface{}) {
+			c["iss"] = "https://evil.example"
+		}},
+		{name: "wrong audience", subject: "s1", username: "carol", change: func(c map[string]interface{}) {
+			c["aud"] = "another-client"
+		}},
+		{name: "several audiences", subject: "s1", username: "carol", change: func(c map[string]interface{}) {
+			c["aud"] = []string{"another-client", "sbanken"}
+			c["azp"] = "sbanken"
+		}, want: "carol"},
+		{name: "authorized for another client", subject: "s1", username: "carol",
+			change: func(c map[string]interface{}) {
+				c["aud"] = []string{"another-client", "sbanken"}
+				c["azp"] = "another-client"
+			}},
+		{name: "expired", subject: "s1", username: "carol", change: func(c map[string]interface{}) {
+			c["exp"] = time.Now().Add(-time.Hour).Unix()
+		}},
+		{name: "signed with another key", subject: "s1", username: "carol", key: otherKey},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, store := newOIDCAuth(t, p, tc.autoCreate)
+			claims := p.claims(tc.subject, tc.username, "nonce")
+			if tc.change != nil {
+				tc.change(claims)
+			}
+			key := p.key
+			if tc.key != nil {
+				key = tc.key
+			}
+
+			_, sess, err := a.OIDCLogin(context.Background(), p.code(t, key, claims), "nonce")
+			if tc.want == "" {
+				if err == nil {
+					t.Fatalf("logged in as %s", sess.Username)
+				}
+				if tc.err != nil && err != tc.err {
+					t.Errorf("got error %v, want %v", err, tc.err)
+				}
+				if len(store.identities) > 0 {
+					t.Errorf("linked identities %v", store.identities)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sess.Username != tc.want {
+				t.Errorf("logged in as %s, want %s", sess.Username, tc.want)
+			}
+			if got := store.identities[[2]string{p.URL, tc.subject}]; got != tc.want {
+				t.Errorf("identity is linked to %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOIDCIdentities(t *testing.T) {
+	p := newTestProvider(t)
+	a, _ := newOIDCAuth(t, p, true)
+	ctx := context.Background()
+	login := func(subject, username string) (string, error) {
+		t.Helper()
+		_, sess, err := a.OIDCLogin(ctx, p.code(t, p.key, p.claims(subject, username, "nonce")), "nonce")
+		if err != nil {
+			return "", err
+		}
+		return sess.Username, nil
+	}
+
+	if _, err := login("carol-at-provider", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	// someone else who takes the name at the provider isn't let in
+	if got, err := login("mallory", "carol"); err != ErrNotLinked {
+		t.Errorf("another identity by the same name: logged in as %q with error %v, want %v", got, err,
+			ErrNotLinked)
+	}
+	// while carol carries on after changing her name there
+	if got, err := login("carol-at-provider", "caroline"); err != nil || got != "carol" {
+		t.Errorf("renamed identity: logged in as %q with error %v, want carol", got, err)
+	}
+
+	// the admin links their identity after logging in with their password
+	if err := a.OIDCLink(ctx, p.code(t, p.key, p.claims("admin-at-provider", "someone", "nonce")), "nonce",
+		"admin"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := login("admin-at-provider", "someone"); err != nil || got != "admin" {
+		t.Errorf("linked identity: logged in as %q with error %v, want admin", got, err)
+	}
+	if err := a.OIDCLink(ctx, p.code(t, p.key, p.claims("carol-at-provider", "carol", "nonce")), "nonce",
+		"admin"); err != ErrInvalidCredentials {
+		t.Errorf("linking another user's identity: got %v, want %v", err, ErrInvalidCredentials)
+	}
+	if err := a.OIDCLink(ctx, p.code(t, p.key, p.claims("admin-2", "", "other")), "nonce",
+		"admin"); err == nil {
+		t.Error("linked an identity with the wrong nonce")
+	}
+}
@@ -18,10 +18,11 @@ type account struct {
 
 func (c *Client) accounts() ([]*account, error) {
 	var accounts []*account
-	bod, err := c.callAPI("/exec.bank/api/v1/Accounts")
+	bod, err := c.callAPI("accounts", "/exec.bank/api/v1/Accounts")
 	if err != nil {
 		return accounts, err
 	}
+	defer bod.Close()
 
 	var accountsRes struct {
 		Items []*account `json:"items"`
@@ -6,11 +6,18 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/metrics"
 	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/secrets"
 	"github.com/j18e/sbanken-client/pkg/storage"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/j18e/sbanken-client/pkg/vendors"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2/clientcredentials"
 )
@@ -20,36 +27,31 @@ type Client struct {
 	customerID string
 	accountID  string
 	storage    *storage.Storage
+	events     *events.Bus
+
+	// the sync in progress, guarded by mu
+	mu      sync.Mutex
+	running *runningSync
 }
 
-func NewClient(stor *storage.Storage) *Client {
+func NewClient(conf config.Sbanken, stor *storage.Storage, bus *events.Bus) *Client {
 	const tokenURL = "https://auth.sbanken.no/identityserver/connect/token"
 
-	// load mandatory environment variables
-	var authParms struct {
-		AccountID    string `required:"true" envconfig:"ACCOUNT_ID"`
-		CustomerID   string `required:"true" envconfig:"CUSTOMER_ID"`
-		ClientID     string `required:"true" envconfig:"CLIENT_ID"`
-		ClientSecret string `required:"true" envconfig:"CLIENT_SECRET"`
-	}
-	if err := envconfig.Process("", &authParms); err != nil {
-		log.Fatal(err)
-	}
-
 	// get http client with oauth config
-	conf := clientcredentials.Config{
-		ClientID:     authParms.ClientID,
-		ClientSecret: authParms.ClientSecret,
+	oauth := clientcredentials.Config{
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
 		TokenURL:     tokenURL,
 	}
-	cli := conf.Client(context.TODO())
+	cli := oauth.Client(context.TODO())
 	cli.Timeout = 10 * time.Second
 
 	return &Client{
 		cli:        cli,
-		customerID: authParms.CustomerID,
-		accountID:  authParms.AccountID,
+		customerID: conf.CustomerID,
+		accountID:  conf.AccountID,
 		storage:    stor,
+		events:     bus,
 	}
 }
 
@@ -60,8 +62,8 @@ func (c *Client) Loop(ctx context.Context, dur time.Duration) error {
 	for {
 		select {
 		case <-ticker.C:
-			if err := c.Purchases(); err != nil {
-				log.Errorf("getting purhcases: %v", err)
+			if run := c.Sync(models.SyncScheduled); run.Error != "" {
+				log.Errorf("getting purhcases: %s", run.Error)
 			}
 		case <-ctx.Done():
 			return ctx.Err()
@@ -69,59 +71,131 @@ func (c *Client) Loop(ctx context.Context, dur time.Duration) error {
 	}
 }
 
-func (c *Client) Purchases() error {
+// purchases loads the purchases of every account made in the period, or the
+// latest ones if it's nil, into storage, recording the outcome of each
+// account in the run.
+func (c *Client) purchases(run *models.SyncRun, p *period) error {
 	// get accounts
 	accounts, err := c.accounts()
 	if err != nil {
-		return fmt.Errorf("getting accounts: %v", err)
+		return fmt.Errorf("getting accounts: %w", err)
 	}
 
+	// get the vendor aliases used to normalize merchant names
+	aliases, err := c.storage.VendorAliases()
+	if err != nil {
+		return fmt.Errorf("getting vendor aliases: %v", err)
+	}
+	normalizer := vendors.NewAliases(aliases)
+
+	// the error of an account which failed, wrapped when they all fail so
+	// that rejected credentials are still recognized
+	var accountErr error
+	failed := func(err error) {
+		if accountErr == nil || isAuthError(err) {
+			accountErr = err
+		}
+	}
 	for _, acct := range accounts {
+		result := &models.AccountSync{Account: acct.Name, New: []string{}}
+		run.Accounts = append(run.Accounts, result)
+
 		// get card details of every transaction from account
-		cdx, err := c.transactions(acct.ID)
+		cdx, err := c.transactions(acct.ID, p)
 		if err != nil {
 			log.Errorf("getting transactions from account %s: %v", acct.Name, err)
+			result.Error = fmt.Sprintf("getting transactions: %v", err)
+			failed(fmt.Errorf("getting transactions from account %s: %w", acct.Name, err))
 			continue
 		}
-
-		// do nothing if no card details are found
-		if len(cdx) < 1 {
-			continue
-		}
+		result.Fetched = len(cdx)
 
 		// convert card details to purchases
 		purchases := func() []*models.Purchase {
 			var res []*models.Purchase
 			for _, cd := range cdx {
-				res = append(res, cd.purchase(acct.Name))
+				res = append(res, cd.purchase(acct.Name, normalizer))
 			}
 			return res
 		}()
 
 		// commit purchases to storage
-		if err := c.storage.AddPurchases(purchases); err != nil {
+		added, err := c.storage.AddPurchases(purchases)
+		if err != nil {
 			log.Errorf("storing purchases from account %s: %v", acct.Name, err)
+			result.Error = fmt.Sprintf("storing purchases: %v", err)
+			failed(fmt.Errorf("storing purchases from account %s: %w", acct.Name, err))
 			continue
 		}
-		log.Infof("loaded %d purchases from %s", len(purchases), acct.Name)
+		result.New = added.Inserted
+		result.Added = len(added.Inserted)
+		result.Updated = len(added.Updated)
+		result.Duplicates = len(added.Skipped)
+		metrics.PurchasesIngested.Add(float64(result.Added))
+		log.Infof("loaded %d purchases from %s: %d new, %d updated, %d unchanged",
+			len(purchases), acct.Name, result.Added, result.Updated, result.Duplicates)
+		if len(added.Unsplit) > 0 {
+			log.Warnf("the amount of %d split purchases from %s changed, so their splits were removed: %s",
+				len(added.Unsplit), acct.Name, strings.Join(added.Unsplit, ", "))
+		}
+		c.publishPurchases(events.PurchaseCreated, added.Inserted)
+		c.publishPurchases(events.PurchaseUpdated, added.Updated)
+	}
+
+	for _, result := range run.Accounts {
+		if result.Error == "" {
+			return nil
+		}
+	}
+	if len(run.Accounts) > 0 {
+		return fmt.Errorf("loading purchases failed for every account: %w", accountErr)
 	}
 	return nil
 }
 
-func (c *Client) callAPI(path string) (io.Reader, error) {
+// publishPurchases publishes an event of the given type for each of the
+// purchases, as they are in storage.
+func (c *Client) publishPurchases(typ string, ids []string) {
+	for _, id := range ids {
+		p, err := c.storage.GetPurchase(id)
+		if err != nil {
+			log.Errorf("getting purchase %s for a %s event: %v", id, typ, err)
+			continue
+		}
+		e := events.New(typ)
+		e.Purchase = p
+		c.events.Publish(e)
+	}
+}
+
+// callAPI calls the Sbanken API, labelling the metrics of the call with the
+// given endpoint. The caller must close the returned body.
+func (c *Client) callAPI(endpoint, path string) (io.ReadCloser, error) {
 	const apiServer = "https://api.sbanken.no"
 
 	req, _ := http.NewRequest("GET", apiServer+path, nil)
 	req.Header.Set("customerId", c.customerID)
 
+	start := time.Now()
 	res, err := c.cli.Do(req)
+	metrics.APILatency.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
 	if err != nil {
-		return nil, fmt.Errorf("calling Sbanken API: %w", err)
+		metrics.APIRequests.WithLabelValues(endpoint, "0").Inc()
+		return nil, fmt.Errorf("calling Sbanken API: %w", secrets.RedactError(err))
+	}
+	metrics.APIRequests.WithLabelValues(endpoint, strconv.Itoa(res.StatusCode)).Inc()
+	if res.StatusCode > 399 {
+		defer res.Body.Close()
 	}
 
+	// the bodies of errors may echo the credentials back, so they're redacted
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		bs, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("%w: status %d: %s", errUnauthorized, res.StatusCode, secrets.Redact(string(bs)))
+	}
 	if res.StatusCode > 399 {
 		bs, _ := ioutil.ReadAll(res.Body)
-		return nil, fmt.Errorf("status %d: %s", res.StatusCode, string(bs))
+		return nil, fmt.Errorf("status %d: %s", res.StatusCode, secrets.Redact(string(bs)))
 	}
 
 	return res.Body, nil
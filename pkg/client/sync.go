@@ -0,0 +1,133 @@
+package client
+
+import (
+	"errors"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/metrics"
+	"github.com/j18e/sbanken-client/pkg/models"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// historySize is how many syncs are listed.
+const historySize = 100
+
+// errUnauthorized is returned by the Sbanken API when the client's
+// credentials aren't accepted.
+var errUnauthorized = errors.New("unauthorized")
+
+// isAuthError reports whether an error means the client's credentials have
+// expired or been revoked.
+func isAuthError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) || errors.Is(err, errUnauthorized)
+}
+
+// runningSync is a sync in progress, which later triggers join.
+type runningSync struct {
+	run    models.SyncRun
+	period *period
+	done   chan struct{}
+}
+
+// StartSync starts loading purchases from Sbanken in the background, unless
+// a sync is already running, in which case that sync is joined rather than
+// starting another. It returns the ID of the sync and a channel which is
+// closed once it's done.
+func (c *Client) StartSync(trigger string) (int, <-chan struct{}, error) {
+	return c.startSync(trigger, nil)
+}
+
+// startSync starts a sync of the given period, or of the latest purchases
+// if it's nil. Only the latter may be joined.
+func (c *Client) startSync(trigger string, p *period) (int, <-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running != nil {
+		if p != nil || c.running.period != nil {
+			return 0, nil, errors.New("another sync is already running")
+		}
+		return c.running.run.ID, c.running.done, nil
+	}
+
+	rs := &runningSync{
+		run:    models.SyncRun{Trigger: trigger, Started: time.Now(), Accounts: []*models.AccountSync{}},
+		period: p,
+		done:   make(chan struct{}),
+	}
+	if err := c.storage.StartSyncRun(&rs.run); err != nil {
+		return 0, nil, err
+	}
+	c.running = rs
+	go c.sync(rs)
+	return rs.run.ID, rs.done, nil
+}
+
+// Sync loads purchases from Sbanken, or waits for the sync which is already
+// running, and returns how it went.
+func (c *Client) Sync(trigger string) *models.SyncRun {
+	return c.runSync(trigger, nil)
+}
+
+// Backfill loads the purchases made from one date to another, which may be
+// further back than syncs reach, and returns how it went.
+func (c *Client) Backfill(from, to models.Date) *models.SyncRun {
+	return c.runSync(models.SyncBackfill, &period{from: from, to: to})
+}
+
+func (c *Client) runSync(trigger string, p *period) *models.SyncRun {
+	id, done, err := c.startSync(trigger, p)
+	if err != nil {
+		return &models.SyncRun{Trigger: trigger, Error: "starting sync: " + err.Error()}
+	}
+	<-done
+	run, err := c.storage.SyncRun(id)
+	if err != nil {
+		return &models.SyncRun{ID: id, Trigger: trigger, Error: "getting sync: " + err.Error()}
+	}
+	return run
+}
+
+func (c *Client) sync(rs *runningSync) {
+	// the run is only read by others once it's in storage
+	run := rs.run
+	err := c.purchases(&run, rs.period)
+	finished := time.Now()
+	run.Finished = &finished
+
+	metrics.SyncDuration.Observe(finished.Sub(run.Started).Seconds())
+	if err != nil {
+		run.Error = err.Error()
+		run.AuthFailed = isAuthError(err)
+		metrics.SyncFailures.Inc()
+	} else {
+		metrics.SyncSucceeded(finished)
+	}
+	if err := c.storage.FinishSyncRun(&run); err != nil {
+		log.Errorf("storing the outcome of sync %d: %v", run.ID, err)
+	}
+	e := events.New(events.SyncFinished)
+	if run.Error != "" {
+		e.Type = events.SyncFailed
+	}
+	e.Sync = &run
+	c.events.Publish(e)
+
+	c.mu.Lock()
+	c.running = nil
+	c.mu.Unlock()
+	close(rs.done)
+}
+
+// SyncRuns returns the latest syncs, newest first. The sync in progress, if
+// any, has no accounts yet.
+func (c *Client) SyncRuns() ([]*models.SyncRun, error) {
+	return c.storage.SyncRuns(historySize)
+}
+
+// SyncRun returns the sync with the given ID.
+func (c *Client) SyncRun(id int) (*models.SyncRun, error) {
+	return c.storage.SyncRun(id)
+}
@@ -3,9 +3,12 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/vendors"
 )
 
 type transaction struct {
@@ -38,7 +41,7 @@ type cardDetails struct {
 	PurchaseDate     time.Time `json:"purchaseDate"`
 }
 
-func (cd *cardDetails) purchase(acct string) *models.Purchase {
+func (cd *cardDetails) purchase(acct string, aliases vendors.Aliases) *models.Purchase {
 	nok := cd.CurrencyAmount
 	if cd.CurrencyRate != 0 {
 		nok *= cd.CurrencyRate
@@ -52,29 +55,89 @@ func (cd *cardDetails) purchase(acct string) *models.Purchase {
 			MonthNum: int(cd.PurchaseDate.Month()),
 			Day:      cd.PurchaseDate.Day(),
 		},
-		Account:  acct,
-		Category: cd.CategoryDesc,
-		Location: cd.City,
-		Vendor:   cd.Merchant,
+		Account:   acct,
+		Category:  cd.CategoryDesc,
+		Location:  cd.City,
+		Vendor:    aliases.Vendor(cd.Merchant, cd.City),
+		RawVendor: cd.Merchant,
 	}
 }
 
-func (c *Client) transactions(acctID string) ([]*cardDetails, error) {
-	bod, err := c.callAPI("/exec.bank/api/v1/Transactions/" + acctID)
+// maxTransactions is the most transactions Sbanken returns at once.
+const maxTransactions = 1000
+
+// period is the days from one date to another, inclusive.
+type period struct {
+	from, to models.Date
+}
+
+// months splits the period into months, or parts of months at either end,
+// to keep each request for transactions small.
+func (p period) months() []period {
+	var res []period
+	to := p.to.Time()
+	for start := p.from.Time(); !start.After(to); {
+		end := time.Date(start.Year(), start.Month()+1, 0, 0, 0, 0, 0, time.UTC)
+		if end.After(to) {
+			end = to
+		}
+		res = append(res, period{from: models.DateFromTime(start), to: models.DateFromTime(end)})
+		start = end.AddDate(0, 0, 1)
+	}
+	return res
+}
+
+// transactions returns the card transactions of an account made in the
+// period, or the latest ones if it's nil.
+func (c *Client) transactions(acctID string, p *period) ([]*cardDetails, error) {
+	path := "/exec.bank/api/v1/Transactions/" + acctID
+	if p == nil {
+		res, _, _, err := c.transactionPage(path)
+		return res, err
+	}
+
+	var res []*cardDetails
+	for _, month := range p.months() {
+		for index := 0; ; {
+			q := url.Values{
+				"startDate": {month.from.Stamp()},
+				"endDate":   {month.to.Stamp()},
+				"index":     {strconv.Itoa(index)},
+				"length":    {strconv.Itoa(maxTransactions)},
+			}
+			cdx, items, available, err := c.transactionPage(path + "?" + q.Encode())
+			if err != nil {
+				return nil, fmt.Errorf("%s to %s: %w", month.from.Stamp(), month.to.Stamp(), err)
+			}
+			res = append(res, cdx...)
+			index += items
+			if items == 0 || index >= available {
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// transactionPage returns the card transactions of a response, along with
+// the number of transactions in it and how many are available in all.
+func (c *Client) transactionPage(path string) ([]*cardDetails, int, int, error) {
+	bod, err := c.callAPI("transactions", path)
 	if err != nil {
-		return nil, err
+		return nil, 0, 0, err
 	}
+	defer bod.Close()
 
 	var data struct {
 		Length *int           `json:"availableItems"`
 		Items  []*transaction `json:"items"`
 	}
 	if err := json.NewDecoder(bod).Decode(&data); err != nil {
-		return nil, fmt.Errorf("unmarshaling json: %w", err)
+		return nil, 0, 0, fmt.Errorf("unmarshaling json: %w", err)
 	}
 
 	if data.Length == nil {
-		return nil, fmt.Errorf(`missing field "availableItems" in response data`)
+		return nil, 0, 0, fmt.Errorf(`missing field "availableItems" in response data`)
 	}
 
 	var res []*cardDetails
@@ -83,5 +146,5 @@ func (c *Client) transactions(acctID string) ([]*cardDetails, error) {
 			res = append(res, trans.CardDetails)
 		}
 	}
-	return res, nil
+	return res, len(data.Items), *data.Length, nil
 }
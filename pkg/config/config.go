@@ -0,0 +1,387 @@
+// Package config loads the configuration of every part of sbanken-client from
+// a yaml file, overridden by environment variables, and checks it before
+// anything starts.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/secrets"
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v2"
+)
+
+// The sections a command may use, which Validate then requires to be
+// complete.
+const (
+	SectionSbanken       = "sbanken"
+	SectionDatabase      = "database"
+	SectionNotifications = "notifications"
+)
+
+// Config is the whole configuration. Every setting can also be given by the
+// environment variable in its envconfig tag, which takes precedence over the
+// file. Secrets can also be kept in the secrets file, or read from the file
+// named by their environment variable with _FILE appended.
+type Config struct {
+	// SecretsFile is an encrypted file of secrets by environment variable,
+	// unlocked with SECRETS_PASSPHRASE.
+	SecretsFile string `yaml:"secrets_file" envconfig:"SECRETS_FILE"`
+
+	Sbanken       Sbanken       `yaml:"sbanken"`
+	Sync          Sync          `yaml:"sync"`
+	Database      Database      `yaml:"database"`
+	Server        Server        `yaml:"server"`
+	Auth          Auth          `yaml:"auth"`
+	Notifications Notifications `yaml:"notifications"`
+	Webhooks      Webhooks      `yaml:"webhooks"`
+
+	// unlocks SecretsFile
+	passphrase string
+}
+
+// Sbanken is how purchases are loaded from the Sbanken API.
+type Sbanken struct {
+	AccountID    string `yaml:"account_id" envconfig:"ACCOUNT_ID"`
+	CustomerID   string `yaml:"customer_id" envconfig:"CUSTOMER_ID"`
+	ClientID     string `yaml:"client_id" envconfig:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" envconfig:"CLIENT_SECRET"`
+}
+
+// Sync is how often purchases are loaded.
+type Sync struct {
+	Interval time.Duration `yaml:"interval" envconfig:"SYNC_INTERVAL"`
+}
+
+// Database is the postgres database purchases are stored in.
+type Database struct {
+	User     string `yaml:"user" envconfig:"DB_USER"`
+	Password string `yaml:"password" envconfig:"DB_PASSWORD"`
+	Host     string `yaml:"host" envconfig:"DB_HOST"`
+	Name     string `yaml:"name" envconfig:"DB_NAME"`
+}
+
+// Server is the web server.
+type Server struct {
+	Debug bool `yaml:"debug" envconfig:"DEBUG"`
+	// Budget is the monthly budget of users who haven't set their own.
+	Budget int `yaml:"monthly_budget" envconfig:"MONTHLY_BUDGET"`
+	Income int `yaml:"monthly_income" envconfig:"MONTHLY_INCOME"`
+	// SyncMaxAge is how long ago the last successful sync may have been
+	// before the health check fails, which must be longer than Sync.Interval.
+	SyncMaxAge time.Duration `yaml:"sync_max_age" envconfig:"SYNC_MAX_AGE"`
+	// MetricsToken is the bearer token /metrics must be scraped with. The
+	// metrics are public if it's empty.
+	MetricsToken string `yaml:"metrics_token" envconfig:"METRICS_TOKEN"`
+}
+
+// Auth is how users log in.
+type Auth struct {
+	// AdminUser is created, or has its password reset, at startup.
+	AdminUser     string        `yaml:"admin_user" envconfig:"ADMIN_USER"`
+	AdminPassword string        `yaml:"admin_password" envconfig:"ADMIN_PASSWORD"`
+	SessionTTL    time.Duration `yaml:"session_ttl" envconfig:"SESSION_TTL"`
+	SecureCookies bool          `yaml:"secure_cookies" envconfig:"SECURE_COOKIES"`
+	OIDC          OIDC          `yaml:"oidc" ignored:"true"`
+}
+
+// OIDC is an OpenID Connect provider users may log in through, which is only
+// used if Issuer is set.
+type OIDC struct {
+	Issuer       string `yaml:"issuer" envconfig:"OIDC_ISSUER"`
+	ClientID     string `yaml:"client_id" envconfig:"OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" envconfig:"OIDC_CLIENT_SECRET"`
+	RedirectURL  string `yaml:"redirect_url" envconfig:"OIDC_REDIRECT_URL"`
+	// AutoCreate creates users logging in for the first time.
+	AutoCreate bool `yaml:"auto_create" envconfig:"OIDC_AUTO_CREATE"`
+}
+
+// Notifications are the daily spending reports and sync alerts sent through
+// pushover.
+type Notifications struct {
+	// ServerURL is linked to from notifications.
+	ServerURL     string `yaml:"server_url" envconfig:"FINANCES_URL"`
+	PushoverUser  string `yaml:"pushover_user" envconfig:"PUSHOVER_USER"`
+	PushoverToken string `yaml:"pushover_token" envconfig:"PUSHOVER_TOKEN"`
+	// NotifyHour is the hour of the day reports are sent at.
+	NotifyHour       *int     `yaml:"notify_hour" envconfig:"NOTIFY_HOUR"`
+	ReportCategories []string `yaml:"report_categories" envconfig:"REPORT_CATEGORIES"`
+	// SyncFailures is how many syncs in a row must fail before it's alerted.
+	SyncFailures int `yaml:"sync_alert_failures" envconfig:"SYNC_ALERT_FAILURES"`
+}
+
+// Webhooks is how outgoing webhooks are delivered.
+type Webhooks struct {
+	Attempts int           `yaml:"attempts" envconfig:"WEBHOOK_ATTEMPTS"`
+	Backoff  time.Duration `yaml:"backoff" envconfig:"WEBHOOK_BACKOFF"`
+	Timeout  time.Duration `yaml:"timeout" envconfig:"WEBHOOK_TIMEOUT"`
+	// Retention is how long deliveries, along with their payloads, are kept.
+	Retention time.Duration `yaml:"retention" envconfig:"WEBHOOK_RETENTION"`
+}
+
+// Default returns the configuration used for anything left unset.
+func Default() *Config {
+	return &Config{
+		Sync: Sync{
+			Interval: 6 * time.Hour,
+		},
+		Database: Database{
+			Host: "localhost",
+			Name: "sbanken-client",
+		},
+		Server: Server{
+			// allows one failed sync at the default interval
+			SyncMaxAge: 13 * time.Hour,
+		},
+		Auth: Auth{
+			SessionTTL: 720 * time.Hour,
+		},
+		Notifications: Notifications{
+			SyncFailures: 3,
+		},
+		Webhooks: Webhooks{
+			Attempts:  5,
+			Backoff:   30 * time.Second,
+			Timeout:   10 * time.Second,
+			Retention: 720 * time.Hour,
+		},
+	}
+}
+
+// Errors is every problem found with a configuration.
+type Errors []string
+
+func (e Errors) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+// Load reads the given yaml file, if path isn't empty, on top of the
+// defaults, then the secrets file, then applies the environment variables. It
+// doesn't validate the result, but registers the secrets for redaction.
+func Load(path string) (*Config, error) {
+	c := Default()
+	var errs Errors
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading the configuration: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+
+	var err error
+	if c.passphrase, err = fromEnv("SECRETS_PASSPHRASE"); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if v, ok := os.LookupEnv("SECRETS_FILE"); ok {
+		c.SecretsFile = v
+	}
+	if c.SecretsFile != "" {
+		store, err := c.OpenSecrets()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", c.SecretsFile, err))
+		} else {
+			for _, name := range store.Names() {
+				v, _ := store.Get(name)
+				// hide them even when the environment overrides them
+				secrets.Register(v)
+				if field, ok := c.secrets()[name]; ok {
+					*field = v
+				}
+			}
+		}
+	}
+
+	sections := []interface{}{&c.Sbanken, &c.Sync, &c.Database, &c.Server, &c.Auth, &c.Auth.OIDC,
+		&c.Notifications, &c.Webhooks}
+	for _, s := range sections {
+		if err := envconfig.Process("", s); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for name, field := range c.secrets() {
+		v, err := fromEnv(name)
+		if err != nil {
+			errs = append(errs, err.Error())
+		} else if v != "" {
+			*field = v
+		}
+	}
+
+	secrets.Register(c.passphrase)
+	for _, field := range c.secrets() {
+		secrets.Register(*field)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// secrets returns the settings which are secret, by environment variable.
+func (c *Config) secrets() map[string]*string {
+	return map[string]*string{
+		"CLIENT_SECRET":      &c.Sbanken.ClientSecret,
+		"DB_PASSWORD":        &c.Database.Password,
+		"ADMIN_PASSWORD":     &c.Auth.AdminPassword,
+		"OIDC_CLIENT_SECRET": &c.Auth.OIDC.ClientSecret,
+		"PUSHOVER_TOKEN":     &c.Notifications.PushoverToken,
+		"METRICS_TOKEN":      &c.Server.MetricsToken,
+	}
+}
+
+// SecretNames returns the environment variables of the settings which can be
+// kept in the secrets file.
+func SecretNames() []string {
+	var res []string
+	for name := range (&Config{}).secrets() {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// OpenSecrets decrypts the secrets file.
+func (c *Config) OpenSecrets() (*secrets.Store, error) {
+	if c.SecretsFile == "" {
+		return nil, errors.New("no secrets file configured - set secrets_file or SECRETS_FILE")
+	}
+	return secrets.Open(c.SecretsFile, c.passphrase)
+}
+
+// fromEnv returns the value of an environment variable, or the contents of
+// the file named by the variable with _FILE appended, without any trailing
+// newline.
+func fromEnv(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	path, fromFile := os.LookupEnv(name + "_FILE")
+	if !fromFile {
+		return v, nil
+	}
+	if ok {
+		return "", fmt.Errorf("%s and %s_FILE are both set - only one of them can be", name, name)
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(bs), "\r\n"), nil
+}
+
+// Validate checks the whole configuration, and that the given sections are
+// complete, returning every problem at once.
+func (c *Config) Validate(sections ...string) error {
+	var errs Errors
+	problem := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+	uses := make(map[string]bool)
+	for _, s := range sections {
+		uses[s] = true
+	}
+
+	if uses[SectionSbanken] {
+		for _, f := range []struct{ name, env, val string }{
+			{"account_id", "ACCOUNT_ID", c.Sbanken.AccountID},
+			{"customer_id", "CUSTOMER_ID", c.Sbanken.CustomerID},
+			{"client_id", "CLIENT_ID", c.Sbanken.ClientID},
+			{"client_secret", "CLIENT_SECRET", c.Sbanken.ClientSecret},
+		} {
+			if f.val == "" {
+				problem("sbanken.%s (%s) is required", f.name, f.env)
+			}
+		}
+	}
+
+	if c.Sync.Interval <= 0 {
+		problem("sync.interval (SYNC_INTERVAL) must be positive, got %v", c.Sync.Interval)
+	}
+
+	if uses[SectionDatabase] {
+		if c.Database.User == "" {
+			problem("database.user (DB_USER) is required")
+		}
+		if c.Database.Password == "" {
+			problem("database.password (DB_PASSWORD) is required")
+		}
+		if c.Database.Host == "" {
+			problem("database.host (DB_HOST) can't be empty")
+		}
+		if c.Database.Name == "" {
+			problem("database.name (DB_NAME) can't be empty")
+		}
+	}
+
+	if c.Server.Budget < 0 {
+		problem("server.monthly_budget (MONTHLY_BUDGET) can't be negative, got %d", c.Server.Budget)
+	}
+	if c.Server.Income < 0 {
+		problem("server.monthly_income (MONTHLY_INCOME) can't be negative, got %d", c.Server.Income)
+	}
+	if c.Server.SyncMaxAge <= 0 {
+		problem("server.sync_max_age (SYNC_MAX_AGE) must be positive, got %v", c.Server.SyncMaxAge)
+	} else if c.Sync.Interval > 0 && c.Server.SyncMaxAge <= c.Sync.Interval {
+		problem("server.sync_max_age (SYNC_MAX_AGE) must be longer than sync.interval (SYNC_INTERVAL), got %v "+
+			"and %v", c.Server.SyncMaxAge, c.Sync.Interval)
+	}
+
+	if c.Auth.AdminUser != "" && c.Auth.AdminPassword == "" {
+		problem("auth.admin_password (ADMIN_PASSWORD) is required when auth.admin_user (ADMIN_USER) is set")
+	}
+	if c.Auth.SessionTTL <= 0 {
+		problem("auth.session_ttl (SESSION_TTL) must be positive, got %v", c.Auth.SessionTTL)
+	}
+	if oidc := c.Auth.OIDC; oidc.Issuer != "" {
+		if oidc.ClientID == "" {
+			problem("auth.oidc.client_id (OIDC_CLIENT_ID) is required when auth.oidc.issuer (OIDC_ISSUER) is set")
+		}
+		if oidc.RedirectURL == "" {
+			problem("auth.oidc.redirect_url (OIDC_REDIRECT_URL) is required when auth.oidc.issuer (OIDC_ISSUER) is set")
+		}
+	}
+
+	n := c.Notifications
+	if uses[SectionNotifications] {
+		if n.ServerURL == "" {
+			problem("notifications.server_url (FINANCES_URL) is required")
+		}
+		if n.PushoverToken == "" {
+			problem("notifications.pushover_token (PUSHOVER_TOKEN) is required")
+		}
+		if n.NotifyHour == nil {
+			problem("notifications.notify_hour (NOTIFY_HOUR) is required")
+		}
+	}
+	if n.NotifyHour != nil && (*n.NotifyHour < 0 || *n.NotifyHour > 23) {
+		problem("notifications.notify_hour (NOTIFY_HOUR) must be between 0 and 23, got %d", *n.NotifyHour)
+	}
+	if n.SyncFailures < 1 {
+		problem("notifications.sync_alert_failures (SYNC_ALERT_FAILURES) must be at least 1, got %d", n.SyncFailures)
+	}
+
+	if c.Webhooks.Attempts < 1 {
+		problem("webhooks.attempts (WEBHOOK_ATTEMPTS) must be at least 1, got %d", c.Webhooks.Attempts)
+	}
+	if c.Webhooks.Backoff < 0 {
+		problem("webhooks.backoff (WEBHOOK_BACKOFF) can't be negative, got %v", c.Webhooks.Backoff)
+	}
+	if c.Webhooks.Timeout <= 0 {
+		problem("webhooks.timeout (WEBHOOK_TIMEOUT) must be positive, got %v", c.Webhooks.Timeout)
+	}
+	if c.Webhooks.Retention <= 0 {
+		problem("webhooks.retention (WEBHOOK_RETENTION) must be positive, got %v", c.Webhooks.Retention)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
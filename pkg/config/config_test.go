@@ -0,0 +1,116 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setenv sets or, given nil, unsets an environment variable until the end of
+// the test.
+func setenv(t *testing.T, name string, value *string) {
+	t.Helper()
+	old, ok := os.LookupEnv(name)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+	if value == nil {
+		os.Unsetenv(name)
+	} else {
+		os.Setenv(name, *value)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	str := func(s string) *string { return &s }
+
+	for _, tc := range []struct {
+		name  string
+		value *string
+		file  *string // the contents of the file in TEST_SECRET_FILE
+		want  string
+		err   bool
+	}{
+		{name: "unset"},
+		{name: "empty", value: str(""), want: ""},
+		{name: "value", value: str("hunter22"), want: "hunter22"},
+		{name: "value kept as is", value: str("hunter22\n"), want: "hunter22\n"},
+		{name: "file", file: str("hunter22"), want: "hunter22"},
+		{name: "file with a trailing newline", file: str("hunter22\n"), want: "hunter22"},
+		{name: "file with windows newlines", file: str("hunter22\r\n\r\n"), want: "hunter22"},
+		{name: "file with inner newlines", file: str("hunter\n22\n"), want: "hunter\n22"},
+		{name: "file with trailing spaces", file: str("hunter22 \n"), want: "hunter22 "},
+		{name: "empty file", file: str(""), want: ""},
+		{name: "both set", value: str("hunter22"), file: str("hunter22"), err: true},
+		{name: "both set, value empty", value: str(""), file: str("hunter22"), err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setenv(t, "TEST_SECRET", tc.value)
+			var path *string
+			if tc.file != nil {
+				p := filepath.Join(dir, tc.name)
+				if err := ioutil.WriteFile(p, []byte(*tc.file), 0600); err != nil {
+					t.Fatal(err)
+				}
+				path = &p
+			}
+			setenv(t, "TEST_SECRET_FILE", path)
+
+			got, err := fromEnv("TEST_SECRET")
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v, want one: %v", err, tc.err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		setenv(t, "TEST_SECRET", nil)
+		setenv(t, "TEST_SECRET_FILE", str(filepath.Join(dir, "missing")))
+		if _, err := fromEnv("TEST_SECRET"); err == nil {
+			t.Error("got no error reading a missing file")
+		}
+	})
+}
+
+func TestValidateSync(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		interval, maxAge time.Duration
+		err              bool
+	}{
+		{name: "defaults", interval: 6 * time.Hour, maxAge: 13 * time.Hour},
+		{name: "max age of one interval", interval: 6 * time.Hour, maxAge: 6 * time.Hour, err: true},
+		{name: "max age shorter than the interval", interval: 6 * time.Hour, maxAge: time.Hour, err: true},
+		{name: "short interval", interval: 15 * time.Minute, maxAge: time.Hour},
+		{name: "no interval", maxAge: 13 * time.Hour, err: true},
+		{name: "negative interval", interval: -time.Hour, maxAge: 13 * time.Hour, err: true},
+		{name: "no max age", interval: 6 * time.Hour, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Default()
+			c.Sync.Interval = tc.interval
+			c.Server.SyncMaxAge = tc.maxAge
+			errs, _ := c.Validate().(Errors)
+			if (len(errs) > 0) != tc.err {
+				t.Errorf("got errors %q, want some: %v", errs, tc.err)
+			}
+			if len(errs) > 1 {
+				t.Errorf("got several errors for one problem: %q", errs)
+			}
+		})
+	}
+}
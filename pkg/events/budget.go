@@ -0,0 +1,93 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// BudgetWatcher publishes a BudgetExceeded event the first time each month
+// that spending goes over a budget, checking whenever purchases change.
+type BudgetWatcher struct {
+	storage       *storage.Storage
+	bus           *Bus
+	defaultBudget int
+}
+
+func NewBudgetWatcher(stor *storage.Storage, bus *Bus, defaultBudget int) *BudgetWatcher {
+	return &BudgetWatcher{storage: stor, bus: bus, defaultBudget: defaultBudget}
+}
+
+func (w *BudgetWatcher) Run(ctx context.Context) error {
+	events, unsubscribe := w.bus.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-events:
+			if e.Type != SyncFinished && e.Type != PurchaseUpdated && e.Type != PurchaseDeleted {
+				continue
+			}
+			if err := w.check(models.DateToday()); err != nil {
+				log.Errorf("checking budgets: %v", err)
+			}
+		}
+	}
+}
+
+// check compares the spending of the given month to the default budget and to
+// the budget of every user who has one.
+func (w *BudgetWatcher) check(month models.Date) error {
+	if w.defaultBudget > 0 {
+		if err := w.checkBudget("", nil, w.defaultBudget, month); err != nil {
+			return err
+		}
+	}
+
+	users, err := w.storage.Users()
+	if err != nil {
+		return fmt.Errorf("getting users: %w", err)
+	}
+	for _, u := range users {
+		budget, err := w.storage.Budget(u.Username)
+		if err != nil {
+			return fmt.Errorf("getting budget of %s: %w", u.Username, err)
+		}
+		if budget < 1 {
+			continue
+		}
+		access := &storage.Access{Username: u.Username, Admin: u.Role == models.RoleAdmin}
+		if err := w.checkBudget(u.Username, access, budget, month); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *BudgetWatcher) checkBudget(username string, access *storage.Access, budget int, month models.Date) error {
+	filter := storage.MonthFilter(month)
+	filter.Access = access
+	totals, err := w.storage.Totals(filter)
+	if err != nil {
+		return fmt.Errorf("getting spending: %w", err)
+	}
+	if len(totals) < 1 || totals[0].NOK <= budget {
+		return nil
+	}
+
+	first, err := w.storage.MarkBudgetExceeded(username, month)
+	if err != nil || !first {
+		return err
+	}
+	w.bus.Publish(Event{Type: BudgetExceeded, Budget: &Budget{
+		Username: username,
+		Month:    fmt.Sprintf("%d-%02d", month.Year, int(month.Month)),
+		Budget:   budget,
+		Spent:    totals[0].NOK,
+	}})
+	return nil
+}
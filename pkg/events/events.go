@@ -0,0 +1,131 @@
+// Package events passes what happens to purchases, syncs and budgets on to
+// whoever is interested, such as outgoing webhooks.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// The types of events.
+const (
+	PurchaseCreated = "purchase.created"
+	PurchaseUpdated = "purchase.updated"
+	PurchaseDeleted = "purchase.deleted"
+	SyncFinished    = "sync.finished"
+	SyncFailed      = "sync.failed"
+	BudgetExceeded  = "budget.exceeded"
+	// Ping is only sent to test webhooks.
+	Ping = "ping"
+	// AccessChanged is published when who can see which accounts changes.
+	// It's only used within the server, and never sent to webhooks.
+	AccessChanged = "access.changed"
+)
+
+// Types lists the types of events which can be subscribed to.
+var Types = []string{PurchaseCreated, PurchaseUpdated, PurchaseDeleted, SyncFinished, SyncFailed, BudgetExceeded}
+
+// ValidType reports whether events of the given type are published.
+func ValidType(typ string) bool {
+	for _, t := range Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is something which happened. Only the field matching the type of the
+// event is set.
+type Event struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Time     time.Time        `json:"time"`
+	Purchase *models.Purchase `json:"purchase,omitempty"`
+	Sync     *models.SyncRun  `json:"sync,omitempty"`
+	Budget   *Budget          `json:"budget,omitempty"`
+}
+
+// Budget is a monthly budget which has been spent.
+type Budget struct {
+	// who the budget belongs to, empty for MONTHLY_BUDGET
+	Username string `json:"username,omitempty"`
+	Month    string `json:"month"`
+	Budget   int    `json:"budget"`
+	Spent    int    `json:"spent"`
+}
+
+// New returns an event of the given type which happened just now.
+func New(typ string) Event {
+	return Event{ID: newID(), Type: typ, Time: time.Now()}
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// events are dropped.
+const subscriberBuffer = 1000
+
+// Bus hands every event published to each subscriber. A nil *Bus drops
+// everything it's given.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]bool)}
+}
+
+// Publish sends an event to every subscriber, setting its ID and time. It
+// never blocks, so subscribers which fall behind miss events.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			log.Warnf("dropping %s event %s for a subscriber which has fallen behind", e.Type, e.ID)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on,
+// along with a function to stop receiving them.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = true
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+		})
+	}
+}
+
+func newID() string {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bs)
+}
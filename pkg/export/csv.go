@@ -0,0 +1,141 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+var csvHeader = []string{"id", "date", "nok", "account", "category", "location", "vendor", "raw_vendor", "tags", "notes"}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return nil, err
+	}
+	return &csvWriter{cw}, nil
+}
+
+func (c *csvWriter) Write(p *models.Purchase) error {
+	return c.w.Write([]string{
+		p.ID,
+		p.Date.Stamp(),
+		strconv.Itoa(p.NOK),
+		p.Account,
+		p.Category,
+		p.Location,
+		p.Vendor,
+		p.RawVendor,
+		strings.Join(p.Tags, ";"),
+		p.Notes,
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	return &jsonlWriter{json.NewEncoder(w)}
+}
+
+func (j *jsonlWriter) Write(p *models.Purchase) error {
+	return j.enc.Encode(p)
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+// readCSV reads purchases written by csvWriter. Columns are found by their
+// header, of which only id, date and nok are required.
+func readCSV(r io.Reader, fn func(*models.Purchase) error) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"id", "date", "nok"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+
+		p := &models.Purchase{
+			ID:        field("id"),
+			Account:   field("account"),
+			Category:  field("category"),
+			Location:  field("location"),
+			Vendor:    field("vendor"),
+			RawVendor: field("raw_vendor"),
+			Notes:     field("notes"),
+		}
+		if p.ID == "" {
+			return fmt.Errorf("line %d: missing id", line)
+		}
+		if p.Date, err = models.ParseDate(field("date")); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if p.NOK, err = strconv.Atoi(field("nok")); err != nil {
+			return fmt.Errorf("line %d: parsing nok: %w", line, err)
+		}
+		if tags := field("tags"); tags != "" {
+			p.Tags = strings.Split(tags, ";")
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+}
+
+// readJSONL reads purchases written by jsonlWriter.
+func readJSONL(r io.Reader, fn func(*models.Purchase) error) error {
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var p models.Purchase
+		if err := dec.Decode(&p); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("purchase %d: %w", n, err)
+		}
+		if p.ID == "" {
+			return fmt.Errorf("purchase %d: missing id", n)
+		}
+		p.Date.MonthNum = int(p.Date.Month)
+		if err := fn(&p); err != nil {
+			return err
+		}
+	}
+}
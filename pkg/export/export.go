@@ -0,0 +1,102 @@
+// Package export writes purchases out in formats understood by spreadsheets
+// and accounting tools. Writers are fed one purchase at a time so that
+// exports of any size can be streamed. Exports in the formats keeping every
+// field can be read back in.
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// Writer writes purchases in a given format. Close must be called once all
+// purchases have been written in order to flush buffers and write trailers.
+type Writer interface {
+	Write(*models.Purchase) error
+	Close() error
+}
+
+// Formats lists the supported export formats.
+var Formats = []string{"csv", "jsonl", "ofx", "ledger", "beancount"}
+
+// Options describe the export being written. Some formats include them in
+// their headers.
+type Options struct {
+	From    models.Date
+	To      models.Date
+	Account string
+}
+
+// NewWriter returns a Writer for the given format which writes to w.
+func NewWriter(format string, w io.Writer, opts Options) (Writer, error) {
+	switch format {
+	case "csv":
+		return newCSVWriter(w)
+	case "jsonl":
+		return newJSONLWriter(w), nil
+	case "ofx":
+		return newOFXWriter(w, opts)
+	case "ledger":
+		return newLedgerWriter(w), nil
+	case "beancount":
+		return newBeancountWriter(w), nil
+	}
+	return nil, unsupported(format, Formats)
+}
+
+// CheckFormat returns an error if format isn't one of Formats, for callers
+// which must know before creating the Writer, such as when setting response
+// headers ahead of the output.
+func CheckFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return unsupported(format, Formats)
+}
+
+func unsupported(format string, formats []string) error {
+	return fmt.Errorf("unsupported format %q - must be one of %v", format, formats)
+}
+
+// ReadFormats lists the formats which can be read back in.
+var ReadFormats = []string{"csv", "jsonl"}
+
+// Read reads the purchases written in the given format from r, handing them
+// to fn one at a time.
+func Read(format string, r io.Reader, fn func(*models.Purchase) error) error {
+	switch format {
+	case "csv":
+		return readCSV(r, fn)
+	case "jsonl":
+		return readJSONL(r, fn)
+	}
+	return unsupported(format, ReadFormats)
+}
+
+// ContentType returns the MIME type of the given format.
+func ContentType(format string) string {
+	switch format {
+	case "csv":
+		return "text/csv; charset=utf-8"
+	case "jsonl":
+		return "application/x-ndjson"
+	case "ofx":
+		return "application/x-ofx"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Extension returns the file extension commonly used for the given format.
+func Extension(format string) string {
+	switch format {
+	case "ledger":
+		return "journal"
+	case "beancount":
+		return "beancount"
+	}
+	return format
+}
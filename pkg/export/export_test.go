@@ -0,0 +1,167 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+var update = flag.Bool("update", false, "write the output of the exports to the golden files")
+
+func date(t *testing.T, s string) models.Date {
+	t.Helper()
+	d, err := models.ParseDate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// purchases returns purchases with the characters each format has to
+// escape or quote.
+func purchases(t *testing.T) []*models.Purchase {
+	t.Helper()
+	return []*models.Purchase{
+		{
+			ID: "1", Date: date(t, "2024-01-05"), NOK: 129, Account: "brukskonto", Category: "streaming",
+			Location: "Oslo", Vendor: "Netflix", RawVendor: "NETFLIX.COM *1234",
+		},
+		{
+			ID: "2", Date: date(t, "2024-02-29"), NOK: 450, Account: "Felles: mat", Category: "dagligvarer",
+			Vendor: `Rema "1000" & Co, Grünerløkka`, RawVendor: "REMA 1000 GRUNERLOKKA",
+			Tags: []string{"mat", "felles"}, Notes: "with a comma, a \"quote\"\nand a newline; and <tags>",
+		},
+		{
+			ID: "3", Date: date(t, "2024-12-31"), NOK: 1000, Category: "hjem",
+			Location: "Bærum; Norge", Vendor: "Et veldig langt butikknavn som går over grensen",
+			Splits: []*models.Split{{Category: "hjem", NOK: 700}, {Category: "Klær og sko", NOK: 300}},
+		},
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	opts := Options{From: date(t, "2024-01-01"), To: date(t, "2024-12-31"), Account: "<brukskonto>"}
+	// the ledger balance of an OFX statement is as of the day of the export
+	today := ofxDate(models.DateToday())
+
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			if err := CheckFormat(format); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			w, err := NewWriter(format, &buf, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range purchases(t) {
+				if err := w.Write(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Replace(buf.String(), "<DTASOF>"+today+"</DTASOF>", "<DTASOF>TODAY</DTASOF>", 1)
+
+			path := filepath.Join("testdata", "export."+Extension(format))
+			if *update {
+				if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("got export:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+
+	if _, err := NewWriter("xlsx", ioutil.Discard, opts); err == nil {
+		t.Error("got a writer for an unsupported format")
+	}
+	if err := CheckFormat("xlsx"); err == nil {
+		t.Error("checking an unsupported format returned no error")
+	}
+}
+
+func TestOFXDefaults(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter("ofx", &buf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<ACCTID>ALL</ACCTID>", "<DTSTART>19700101</DTSTART>",
+		"<DTEND>" + ofxDate(models.DateToday()) + "</DTEND>"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("statement without options doesn't contain %s", want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	for _, format := range ReadFormats {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(format, &buf, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := purchases(t)
+			for _, p := range want {
+				if err := w.Write(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []*models.Purchase
+			if err := Read(format, &buf, func(p *models.Purchase) error {
+				got = append(got, p)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if format == "csv" {
+				// splits and shares aren't kept in csv
+				want[2].Splits = nil
+			}
+			if len(got) != len(want) {
+				t.Fatalf("read %d purchases, want %d", len(got), len(want))
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i], want[i]) {
+					t.Errorf("read purchase %+v, want %+v", *got[i], *want[i])
+				}
+			}
+		})
+	}
+
+	for _, tc := range []struct{ name, in string }{
+		{"missing column", "id,date\n1,2024-01-05\n"},
+		{"missing id", "id,date,nok\n,2024-01-05,129\n"},
+		{"invalid date", "id,date,nok\n1,05.01.2024,129\n"},
+		{"invalid amount", "id,date,nok\n1,2024-01-05,12.50\n"},
+	} {
+		t.Run("csv "+tc.name, func(t *testing.T) {
+			err := Read("csv", strings.NewReader(tc.in), func(*models.Purchase) error { return nil })
+			if err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// ledgerWriter writes a plain text journal readable by ledger and hledger.
+// Each purchase moves money from an assets account named after the bank
+// account to an expenses account named after the category.
+type ledgerWriter struct {
+	w *bufio.Writer
+}
+
+func newLedgerWriter(w io.Writer) *ledgerWriter {
+	return &ledgerWriter{bufio.NewWriter(w)}
+}
+
+func (l *ledgerWriter) Write(p *models.Purchase) error {
+	fmt.Fprintf(l.w, "%s %s  ; id:%s, location:%s\n", p.Date.Stamp(), ledgerText(p.Vendor), p.ID, ledgerText(p.Location))
+	for _, part := range p.Parts() {
+		fmt.Fprintf(l.w, "    expenses:%s    %d NOK\n", ledgerAccount(part.Category), part.NOK)
+	}
+	_, err := fmt.Fprintf(l.w, "    assets:%s\n\n", ledgerAccount(p.Account))
+	return err
+}
+
+func (l *ledgerWriter) Close() error {
+	return l.w.Flush()
+}
+
+// ledgerText removes characters which would end a payee or comment early.
+func ledgerText(s string) string {
+	return strings.NewReplacer("\n", " ", ";", ",").Replace(s)
+}
+
+// ledgerAccount turns s into a single account name component. Colons would
+// introduce subaccounts and two spaces would end the account name.
+func ledgerAccount(s string) string {
+	s = strings.Join(strings.Fields(ledgerText(s)), " ")
+	s = strings.Replace(s, ":", "-", -1)
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// beancountWriter writes a beancount ledger. Beancount requires accounts to
+// be opened before use, which is left to the file including the export.
+type beancountWriter struct {
+	w *bufio.Writer
+}
+
+func newBeancountWriter(w io.Writer) *beancountWriter {
+	return &beancountWriter{bufio.NewWriter(w)}
+}
+
+func (b *beancountWriter) Write(p *models.Purchase) error {
+	fmt.Fprintf(b.w, "%s * %s %s\n  id: %s\n  location: %s\n", p.Date.Stamp(), beancountString(p.Vendor),
+		beancountString(p.Category), beancountString(p.ID), beancountString(p.Location))
+	for _, part := range p.Parts() {
+		fmt.Fprintf(b.w, "  Expenses:%s  %d NOK\n", beancountAccount(part.Category), part.NOK)
+	}
+	_, err := fmt.Fprintf(b.w, "  Assets:%s\n\n", beancountAccount(p.Account))
+	return err
+}
+
+func (b *beancountWriter) Close() error {
+	return b.w.Flush()
+}
+
+func beancountString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace(s) + `"`
+}
+
+// beancountAccount turns s into a valid account name component, which must
+// start with a capital letter and contain only letters, digits and dashes.
+func beancountAccount(s string) string {
+	s = strings.NewReplacer("æ", "ae", "Æ", "Ae", "ø", "o", "Ø", "O", "å", "a", "Å", "A").Replace(s)
+	var sb strings.Builder
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+	}) {
+		sb.WriteString(strings.ToUpper(word[:1]) + strings.ToLower(word[1:]))
+	}
+	res := sb.String()
+	if res == "" || !unicode.IsUpper(rune(res[0])) {
+		res = "X" + res
+	}
+	return res
+}
@@ -0,0 +1,94 @@
+package export
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// ofxWriter writes an OFX 2.2 bank statement. Purchases are written as
+// debits against a single statement.
+type ofxWriter struct {
+	w *bufio.Writer
+}
+
+func newOFXWriter(w io.Writer, opts Options) (*ofxWriter, error) {
+	const header = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<TRNUID>0</TRNUID>
+<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS>
+<CURDEF>NOK</CURDEF>
+<BANKACCTFROM><BANKID>SBANKEN</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>%s</DTSTART>
+<DTEND>%s</DTEND>
+`
+	acct := opts.Account
+	if acct == "" {
+		acct = "ALL"
+	}
+	from := opts.From
+	if from.IsZero() {
+		from = models.Date{Year: 1970, Month: 1, MonthNum: 1, Day: 1}
+	}
+	to := opts.To
+	if to.IsZero() {
+		to = models.DateToday()
+	}
+
+	ow := &ofxWriter{bufio.NewWriter(w)}
+	_, err := fmt.Fprintf(ow.w, header, ofxEscape(acct), ofxDate(from), ofxDate(to))
+	return ow, err
+}
+
+func (o *ofxWriter) Write(p *models.Purchase) error {
+	const tpl = `<STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%d.00</TRNAMT>` +
+		`<FITID>%s</FITID><NAME>%s</NAME><MEMO>%s</MEMO></STMTTRN>
+`
+	memo := strings.TrimSpace(p.Category + " " + p.Location)
+	_, err := fmt.Fprintf(o.w, tpl, ofxDate(p.Date), -p.NOK, ofxEscape(p.ID), ofxEscape(ofxTruncate(p.Vendor, 32)), ofxEscape(memo))
+	return err
+}
+
+func (o *ofxWriter) Close() error {
+	// the ledger balance is mandatory in a statement, but purchases alone
+	// don't tell us what it is
+	const trailer = `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>0.00</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>
+`
+	if _, err := fmt.Fprintf(o.w, trailer, ofxDate(models.DateToday())); err != nil {
+		return err
+	}
+	return o.w.Flush()
+}
+
+func ofxDate(d models.Date) string {
+	return fmt.Sprintf("%04d%02d%02d", d.Year, d.Month, d.Day)
+}
+
+func ofxEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+// ofxTruncate shortens s to the maximum length of an OFX field.
+func ofxTruncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) > max {
+		return string(r[:max])
+	}
+	return s
+}
@@ -0,0 +1,123 @@
+// Package forecast projects how much will have been spent by the end of a
+// month.
+package forecast
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// historyDays is how many days of spending before the forecast month the
+// daily spending rates are based on.
+const historyDays = 90
+
+// Month forecasts the spending of the given month. Spending so far is taken
+// from storage, the rest of the month is projected from the daily spending
+// over the last few months plus the recurring payments which are still due.
+// income is the known monthly income used to project the balance. Only
+// purchases visible through the access are taken into account, and subs must
+// be the recurring payments found through it, as finding them is costly
+// enough for callers to keep them around.
+func Month(stor *storage.Storage, month models.Date, income int, access *storage.Access,
+	subs []*models.Subscription) (*models.Forecast, error) {
+	month.Day = 1
+	today := models.DateToday()
+
+	monthFilter := storage.MonthFilter(month)
+	monthFilter.Access = access
+	purchases, err := stor.Purchases(monthFilter)
+	if err != nil {
+		return nil, fmt.Errorf("getting purchases: %w", err)
+	}
+
+	// recurring payments are projected on their own, so they are left out
+	// of the daily rates
+	recurringVendors := make(map[string]bool)
+	for _, sub := range subs {
+		recurringVendors[sub.Vendor] = true
+	}
+	start := month.Time().AddDate(0, 0, -historyDays)
+	if t := today.Time(); t.Before(month.Time()) {
+		start = t.AddDate(0, 0, -historyDays)
+	}
+	history, err := stor.Purchases(storage.Filter{
+		From:   models.DateFromTime(start),
+		To:     models.DateFromTime(start.AddDate(0, 0, historyDays-1)),
+		Access: access,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting past purchases: %w", err)
+	}
+	rates := make(map[string]float64)
+	for _, p := range history {
+		if recurringVendors[p.Vendor] {
+			continue
+		}
+		for _, part := range p.Parts() {
+			rates[part.Category] += float64(part.NOK) / historyDays
+		}
+	}
+
+	return Project(month, today, purchases, rates, subs, income), nil
+}
+
+// Project forecasts the spending of a month given the purchases made in it
+// so far, the daily spending rate of each category and the recurring
+// payments.
+func Project(month, today models.Date, purchases []*models.Purchase, rates map[string]float64,
+	subs []*models.Subscription, income int) *models.Forecast {
+	end := storage.MonthFilter(month).To
+	fc := &models.Forecast{Month: month, IncomeNOK: income}
+
+	// days left after today, or all of them for future months
+	switch {
+	case end.Time().Before(today.Time()):
+		fc.DaysLeft = 0
+	case month.Time().After(today.Time()):
+		fc.DaysLeft = end.Day
+	default:
+		fc.DaysLeft = end.Day - today.Day
+	}
+
+	categories := make(map[string]*models.CategoryForecast)
+	category := func(name string) *models.CategoryForecast {
+		if _, ok := categories[name]; !ok {
+			categories[name] = &models.CategoryForecast{Category: name}
+		}
+		return categories[name]
+	}
+	for _, p := range purchases {
+		for _, part := range p.Parts() {
+			category(part.Category).SpentNOK += part.NOK
+			fc.SpentNOK += part.NOK
+		}
+	}
+	for name, rate := range rates {
+		category(name).ProjectedNOK += int(rate * float64(fc.DaysLeft))
+	}
+
+	// recurring payments due between tomorrow and the end of the month
+	for _, sub := range subs {
+		next := sub.Next.Time()
+		if sub.Missed || !next.After(today.Time()) || next.Before(month.Time()) || next.After(end.Time()) {
+			continue
+		}
+		category(sub.Category).ProjectedNOK += sub.NOK
+		fc.RecurringNOK += sub.NOK
+	}
+
+	fc.Categories = []*models.CategoryForecast{}
+	for _, cf := range categories {
+		cf.ProjectedNOK += cf.SpentNOK
+		fc.ProjectedNOK += cf.ProjectedNOK
+		fc.Categories = append(fc.Categories, cf)
+	}
+	sort.Slice(fc.Categories, func(i, j int) bool {
+		return fc.Categories[i].ProjectedNOK > fc.Categories[j].ProjectedNOK
+	})
+	fc.BalanceNOK = income - fc.ProjectedNOK
+	return fc
+}
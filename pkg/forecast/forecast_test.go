@@ -0,0 +1,126 @@
+package forecast
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+func date(t *testing.T, s string) models.Date {
+	t.Helper()
+	d, err := models.ParseDate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestProject(t *testing.T) {
+	april := date(t, "2024-04-01")
+	spent := []*models.Purchase{
+		{Date: date(t, "2024-04-01"), Category: "food", NOK: 50},
+		{Date: date(t, "2024-04-01"), Category: "food", NOK: 300, Splits: []*models.Split{
+			{Category: "food", NOK: 100}, {Category: "home", NOK: 200}}},
+	}
+	rates := map[string]float64{"food": 10, "fun": 2.5}
+	sub := func(next string, missed bool) *models.Subscription {
+		return &models.Subscription{Vendor: "netflix", Category: "bills", NOK: 129, Next: date(t, next), Missed: missed}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		month     models.Date
+		today     string
+		purchases []*models.Purchase
+		subs      []*models.Subscription
+		want      *models.Forecast
+	}{
+		{
+			name:      "start of the month",
+			month:     april,
+			today:     "2024-04-01",
+			purchases: spent,
+			subs:      []*models.Subscription{sub("2024-04-15", false)},
+			want: &models.Forecast{Month: april, DaysLeft: 29, SpentNOK: 350, ProjectedNOK: 841, RecurringNOK: 129,
+				IncomeNOK: 1000, BalanceNOK: 159, Categories: []*models.CategoryForecast{
+					{Category: "food", SpentNOK: 150, ProjectedNOK: 440},
+					{Category: "home", SpentNOK: 200, ProjectedNOK: 200},
+					{Category: "bills", ProjectedNOK: 129},
+					{Category: "fun", ProjectedNOK: 72},
+				}},
+		},
+		{
+			name:      "end of the month",
+			month:     april,
+			today:     "2024-04-30",
+			purchases: spent,
+			subs:      []*models.Subscription{sub("2024-04-30", false)},
+			want: &models.Forecast{Month: april, DaysLeft: 0, SpentNOK: 350, ProjectedNOK: 350,
+				IncomeNOK: 1000, BalanceNOK: 650, Categories: []*models.CategoryForecast{
+					{Category: "home", SpentNOK: 200, ProjectedNOK: 200},
+					{Category: "food", SpentNOK: 150, ProjectedNOK: 150},
+					{Category: "fun"},
+				}},
+		},
+		{
+			name:      "past month",
+			month:     april,
+			today:     "2024-05-10",
+			purchases: spent,
+			subs:      []*models.Subscription{sub("2024-05-15", false)},
+			want: &models.Forecast{Month: april, DaysLeft: 0, SpentNOK: 350, ProjectedNOK: 350,
+				IncomeNOK: 1000, BalanceNOK: 650, Categories: []*models.CategoryForecast{
+					{Category: "home", SpentNOK: 200, ProjectedNOK: 200},
+					{Category: "food", SpentNOK: 150, ProjectedNOK: 150},
+					{Category: "fun"},
+				}},
+		},
+		{
+			name:  "future month",
+			month: april,
+			today: "2024-03-20",
+			subs:  []*models.Subscription{sub("2024-04-01", false)},
+			want: &models.Forecast{Month: april, DaysLeft: 30, ProjectedNOK: 504, RecurringNOK: 129,
+				IncomeNOK: 1000, BalanceNOK: 496, Categories: []*models.CategoryForecast{
+					{Category: "food", ProjectedNOK: 300},
+					{Category: "bills", ProjectedNOK: 129},
+					{Category: "fun", ProjectedNOK: 75},
+				}},
+		},
+		{
+			name:  "recurring payments not due this month",
+			month: april,
+			today: "2024-04-10",
+			subs: []*models.Subscription{sub("2024-04-10", false), sub("2024-04-20", true),
+				sub("2024-05-01", false), sub("2024-03-31", false)},
+			want: &models.Forecast{Month: april, DaysLeft: 20, ProjectedNOK: 250,
+				IncomeNOK: 1000, BalanceNOK: 750, Categories: []*models.CategoryForecast{
+					{Category: "food", ProjectedNOK: 200},
+					{Category: "fun", ProjectedNOK: 50},
+				}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Project(tc.month, date(t, tc.today), tc.purchases, rates, tc.subs, 1000)
+			if !reflect.DeepEqual(got.Categories, tc.want.Categories) {
+				t.Errorf("got categories %s, want %s", categoryString(got.Categories),
+					categoryString(tc.want.Categories))
+			}
+			got.Categories, tc.want.Categories = nil, nil
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got forecast %+v, want %+v", *got, *tc.want)
+			}
+		})
+	}
+}
+
+func categoryString(categories []*models.CategoryForecast) string {
+	var res []string
+	for _, cf := range categories {
+		res = append(res, fmt.Sprintf("%s:%d/%d", cf.Category, cf.SpentNOK, cf.ProjectedNOK))
+	}
+	return "[" + strings.Join(res, " ") + "]"
+}
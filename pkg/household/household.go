@@ -0,0 +1,87 @@
+// Package household works out who owes whom for purchases shared between
+// the members of a household.
+package household
+
+import (
+	"sort"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// Balances computes how much each person is owed by, or owes, the rest of
+// the household given the shared purchases and the settlements made so far.
+// It also returns the shared purchases which have no payer and were left out.
+func Balances(purchases []*models.Purchase, settlements []*models.Settlement) ([]*models.Balance, []*models.Purchase) {
+	balances := make(map[string]int)
+	var unassigned []*models.Purchase
+	for _, p := range purchases {
+		if len(p.Shares) < 1 {
+			continue
+		}
+		if p.Payer == "" {
+			unassigned = append(unassigned, p)
+			continue
+		}
+
+		weights := 0
+		for _, sh := range p.Shares {
+			weights += sh.Weight
+		}
+		// the payer is owed exactly what the others owe, whatever rounding
+		// happened
+		balances[p.Payer] += 0
+		for _, sh := range p.Shares {
+			if sh.Person == p.Payer {
+				continue
+			}
+			owed := p.NOK * sh.Weight / weights
+			balances[sh.Person] -= owed
+			balances[p.Payer] += owed
+		}
+	}
+	for _, st := range settlements {
+		balances[st.From] += st.NOK
+		balances[st.To] -= st.NOK
+	}
+
+	res := []*models.Balance{}
+	for person, nok := range balances {
+		res = append(res, &models.Balance{Person: person, NOK: nok})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Person < res[j].Person })
+	return res, unassigned
+}
+
+// SettleUp suggests the transfers which bring every balance to zero, paying
+// the largest debts to the largest creditors first to keep the number of
+// transfers down. Equal balances are settled in the order they're given.
+func SettleUp(balances []*models.Balance) []*models.Transfer {
+	var owed, owing []*models.Balance
+	for _, b := range balances {
+		if b.NOK > 0 {
+			owed = append(owed, &models.Balance{Person: b.Person, NOK: b.NOK})
+		} else if b.NOK < 0 {
+			owing = append(owing, &models.Balance{Person: b.Person, NOK: -b.NOK})
+		}
+	}
+	sort.SliceStable(owed, func(i, j int) bool { return owed[i].NOK > owed[j].NOK })
+	sort.SliceStable(owing, func(i, j int) bool { return owing[i].NOK > owing[j].NOK })
+
+	res := []*models.Transfer{}
+	for i, j := 0, 0; i < len(owing) && j < len(owed); {
+		nok := owing[i].NOK
+		if owed[j].NOK < nok {
+			nok = owed[j].NOK
+		}
+		res = append(res, &models.Transfer{From: owing[i].Person, To: owed[j].Person, NOK: nok})
+		owing[i].NOK -= nok
+		owed[j].NOK -= nok
+		if owing[i].NOK == 0 {
+			i++
+		}
+		if owed[j].NOK == 0 {
+			j++
+		}
+	}
+	return res
+}
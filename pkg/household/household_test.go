@@ -0,0 +1,199 @@
+package household
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// shared returns a purchase paid by the payer and shared by the given
+// people and weights, as in shared("1", 100, "alice", "alice", 1, "bob", 2).
+func shared(id string, nok int, payer string, shares ...interface{}) *models.Purchase {
+	p := &models.Purchase{ID: id, NOK: nok, Payer: payer}
+	for i := 0; i < len(shares); i += 2 {
+		p.Shares = append(p.Shares, &models.Share{Person: shares[i].(string), Weight: shares[i+1].(int)})
+	}
+	return p
+}
+
+func TestBalances(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		purchases   []*models.Purchase
+		settlements []*models.Settlement
+		want        []*models.Balance
+		unassigned  []string
+	}{
+		{
+			name:      "nothing shared",
+			purchases: []*models.Purchase{{ID: "1", NOK: 100, Payer: "alice"}},
+			want:      []*models.Balance{},
+		},
+		{
+			name:      "even split",
+			purchases: []*models.Purchase{shared("1", 100, "alice", "alice", 1, "bob", 1)},
+			want:      []*models.Balance{{Person: "alice", NOK: 50}, {Person: "bob", NOK: -50}},
+		},
+		{
+			name:      "uneven weights",
+			purchases: []*models.Purchase{shared("1", 100, "alice", "alice", 1, "bob", 2)},
+			want:      []*models.Balance{{Person: "alice", NOK: 66}, {Person: "bob", NOK: -66}},
+		},
+		{
+			name:      "rounded down for each person",
+			purchases: []*models.Purchase{shared("1", 100, "alice", "alice", 1, "bob", 1, "carol", 1)},
+			want: []*models.Balance{{Person: "alice", NOK: 66}, {Person: "bob", NOK: -33},
+				{Person: "carol", NOK: -33}},
+		},
+		{
+			name:      "payer missing from the shares",
+			purchases: []*models.Purchase{shared("1", 100, "dave", "alice", 1, "bob", 2)},
+			want: []*models.Balance{{Person: "alice", NOK: -33}, {Person: "bob", NOK: -66},
+				{Person: "dave", NOK: 99}},
+		},
+		{
+			name:      "payer with the only share",
+			purchases: []*models.Purchase{shared("1", 100, "alice", "alice", 1)},
+			want:      []*models.Balance{{Person: "alice", NOK: 0}},
+		},
+		{
+			name: "no payer",
+			purchases: []*models.Purchase{shared("1", 100, "alice", "alice", 1, "bob", 1),
+				shared("2", 80, "", "alice", 1, "bob", 1)},
+			want:       []*models.Balance{{Person: "alice", NOK: 50}, {Person: "bob", NOK: -50}},
+			unassigned: []string{"2"},
+		},
+		{
+			name: "paying for each other",
+			purchases: []*models.Purchase{shared("1", 100, "alice", "alice", 1, "bob", 1),
+				shared("2", 300, "bob", "alice", 1, "bob", 2)},
+			want: []*models.Balance{{Person: "alice", NOK: -50}, {Person: "bob", NOK: 50}},
+		},
+		{
+			name:        "settled",
+			purchases:   []*models.Purchase{shared("1", 100, "alice", "alice", 1, "bob", 1)},
+			settlements: []*models.Settlement{{From: "bob", To: "alice", NOK: 50}},
+			want:        []*models.Balance{{Person: "alice", NOK: 0}, {Person: "bob", NOK: 0}},
+		},
+		{
+			name:        "settlement overshooting the balance",
+			purchases:   []*models.Purchase{shared("1", 100, "alice", "alice", 1, "bob", 1)},
+			settlements: []*models.Settlement{{From: "bob", To: "alice", NOK: 80}},
+			want:        []*models.Balance{{Person: "alice", NOK: -30}, {Person: "bob", NOK: 30}},
+		},
+		{
+			name:        "settlement without purchases",
+			settlements: []*models.Settlement{{From: "bob", To: "carol", NOK: 20}},
+			want:        []*models.Balance{{Person: "bob", NOK: 20}, {Person: "carol", NOK: -20}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, unassigned := Balances(tc.purchases, tc.settlements)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got balances %s, want %s", balanceString(got), balanceString(tc.want))
+			}
+			var sum int
+			for _, b := range got {
+				sum += b.NOK
+			}
+			if sum != 0 {
+				t.Errorf("balances sum to %d, want 0", sum)
+			}
+			var ids []string
+			for _, p := range unassigned {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.unassigned) {
+				t.Errorf("got unassigned purchases %q, want %q", ids, tc.unassigned)
+			}
+		})
+	}
+}
+
+func balanceString(balances []*models.Balance) string {
+	var res []string
+	for _, b := range balances {
+		res = append(res, fmt.Sprintf("%s:%d", b.Person, b.NOK))
+	}
+	return "[" + strings.Join(res, " ") + "]"
+}
+
+func transferString(transfers []*models.Transfer) string {
+	var res []string
+	for _, tr := range transfers {
+		res = append(res, fmt.Sprintf("%s->%s:%d", tr.From, tr.To, tr.NOK))
+	}
+	return "[" + strings.Join(res, " ") + "]"
+}
+
+func TestSettleUp(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		balances []*models.Balance
+		want     []*models.Transfer
+	}{
+		{
+			name:     "settled",
+			balances: []*models.Balance{{Person: "alice", NOK: 0}, {Person: "bob", NOK: 0}},
+			want:     []*models.Transfer{},
+		},
+		{
+			name:     "one debt",
+			balances: []*models.Balance{{Person: "alice", NOK: 50}, {Person: "bob", NOK: -50}},
+			want:     []*models.Transfer{{From: "bob", To: "alice", NOK: 50}},
+		},
+		{
+			name: "one creditor",
+			balances: []*models.Balance{{Person: "alice", NOK: 100}, {Person: "bob", NOK: -40},
+				{Person: "carol", NOK: -60}},
+			want: []*models.Transfer{{From: "carol", To: "alice", NOK: 60}, {From: "bob", To: "alice", NOK: 40}},
+		},
+		{
+			name: "one debtor",
+			balances: []*models.Balance{{Person: "alice", NOK: -100}, {Person: "bob", NOK: 30},
+				{Person: "carol", NOK: 70}},
+			want: []*models.Transfer{{From: "alice", To: "carol", NOK: 70}, {From: "alice", To: "bob", NOK: 30}},
+		},
+		{
+			name: "largest first",
+			balances: []*models.Balance{{Person: "alice", NOK: 80}, {Person: "bob", NOK: 20},
+				{Person: "carol", NOK: -70}, {Person: "dave", NOK: -30}},
+			want: []*models.Transfer{{From: "carol", To: "alice", NOK: 70}, {From: "dave", To: "alice", NOK: 10},
+				{From: "dave", To: "bob", NOK: 20}},
+		},
+		{
+			name: "ties in the order of the balances",
+			balances: []*models.Balance{{Person: "alice", NOK: 50}, {Person: "bob", NOK: 50},
+				{Person: "carol", NOK: -100}},
+			want: []*models.Transfer{{From: "carol", To: "alice", NOK: 50}, {From: "carol", To: "bob", NOK: 50}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := SettleUp(tc.balances)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got transfers %s, want %s", transferString(got), transferString(tc.want))
+			}
+
+			// the transfers bring every balance to zero
+			left := make(map[string]int)
+			for _, b := range tc.balances {
+				left[b.Person] = b.NOK
+			}
+			for _, tr := range got {
+				if tr.NOK <= 0 {
+					t.Errorf("transfer of %d NOK from %s to %s", tr.NOK, tr.From, tr.To)
+				}
+				left[tr.From] += tr.NOK
+				left[tr.To] -= tr.NOK
+			}
+			for person, nok := range left {
+				if nok != 0 {
+					t.Errorf("%s is left with %d NOK", person, nok)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Package metrics holds the Prometheus metrics exposed on /metrics, along
+// with the sync state the health checks are based on.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// APIRequests counts calls to the Sbanken API by endpoint and status
+	// code, which is 0 when no response was received.
+	APIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sbanken_api_requests_total",
+		Help: "Calls to the Sbanken API by endpoint and status code.",
+	}, []string{"endpoint", "status"})
+
+	// APILatency observes how long calls to the Sbanken API take.
+	APILatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sbanken_api_request_duration_seconds",
+		Help:    "Latency of calls to the Sbanken API.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	// PurchasesIngested counts the purchases stored for the first time. It
+	// isn't labelled by account since account names identify their owners.
+	PurchasesIngested = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sbanken_purchases_ingested_total",
+		Help: "Purchases newly stored.",
+	})
+
+	// SyncDuration observes how long loading purchases from Sbanken takes.
+	SyncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sbanken_sync_duration_seconds",
+		Help:    "Duration of loading purchases from Sbanken.",
+		Buckets: []float64{1, 2.5, 5, 10, 30, 60, 120, 300},
+	})
+
+	// SyncFailures counts syncs which failed.
+	SyncFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sbanken_sync_failures_total",
+		Help: "Failed attempts at loading purchases from Sbanken.",
+	})
+
+	syncLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sbanken_sync_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful sync with Sbanken.",
+	})
+
+	// DBLatency observes how long database operations take, by operation.
+	DBLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sbanken_db_query_duration_seconds",
+		Help:    "Latency of database queries by operation.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	// Notifications counts the notifications sent, by whether sending them
+	// succeeded.
+	Notifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sbanken_notifications_total",
+		Help: "Notifications sent by result.",
+	}, []string{"result"})
+
+	// WebhookDeliveries counts the attempts at posting events to webhooks, by
+	// whether they succeeded, and the events dropped for webhooks which fell
+	// too far behind.
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sbanken_webhook_deliveries_total",
+		Help: "Attempts at delivering events to webhooks, and events dropped, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(APIRequests, APILatency, PurchasesIngested, SyncDuration, SyncFailures,
+		syncLastSuccess, DBLatency, Notifications, WebhookDeliveries)
+}
+
+var (
+	mu          sync.RWMutex
+	lastSuccess time.Time
+)
+
+// SyncSucceeded records when a sync last succeeded.
+func SyncSucceeded(t time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	lastSuccess = t
+	syncLastSuccess.Set(float64(t.Unix()))
+}
+
+// LastSyncSuccess returns when a sync last succeeded since startup, which is
+// the zero time if none has.
+func LastSyncSuccess() time.Time {
+	mu.RLock()
+	defer mu.RUnlock()
+	return lastSuccess
+}
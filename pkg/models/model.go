@@ -13,6 +13,44 @@ type Purchase struct {
 	Category string `json:"category"`
 	Location string `json:"location"`
 	Vendor   string `json:"vendor"`
+
+	// RawVendor is the merchant name as reported by the bank, which Vendor
+	// is a cleaned up version of.
+	RawVendor string `json:"raw_vendor"`
+
+	Tags  []string `json:"tags"`
+	Notes string   `json:"notes"`
+
+	// Splits divide the purchase into parts with their own categories. The
+	// parts sum up to NOK and are counted instead of the purchase in totals.
+	Splits []*Split `json:"splits"`
+
+	// Shares divide the cost of a purchase between the members of the
+	// household. Payer is the person who paid for it, either as assigned to
+	// the purchase or as the payer of the account it was made from.
+	Shares []*Share `json:"shares"`
+	Payer  string   `json:"payer"`
+}
+
+// Parts returns the parts the purchase is split into, or the whole purchase
+// as a single part if it isn't split.
+func (p *Purchase) Parts() []*Split {
+	if len(p.Splits) > 0 {
+		return p.Splits
+	}
+	return []*Split{{Category: p.Category, NOK: p.NOK}}
+}
+
+// Split is one part of a purchase.
+type Split struct {
+	Category string `json:"category" binding:"required"`
+	NOK      int    `json:"nok" binding:"required"`
+}
+
+// VendorAlias maps a raw merchant name to a canonical vendor name.
+type VendorAlias struct {
+	Raw    string `json:"raw" binding:"required"`
+	Vendor string `json:"vendor" binding:"required"`
 }
 
 type Date struct {
@@ -23,13 +61,36 @@ type Date struct {
 }
 
 func DateToday() Date {
-	now := time.Now()
+	return DateFromTime(time.Now())
+}
+
+// DateFromTime returns the Date of the given time.
+func DateFromTime(t time.Time) Date {
 	return Date{
-		Year:     now.Year(),
-		Month:    now.Month(),
-		MonthNum: int(now.Month()),
-		Day:      now.Day(),
+		Year:     t.Year(),
+		Month:    t.Month(),
+		MonthNum: int(t.Month()),
+		Day:      t.Day(),
+	}
+}
+
+// ParseDate parses a date in the yyyy-mm-dd format returned by Stamp.
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return Date{}, fmt.Errorf("parsing date %q: must be formatted as yyyy-mm-dd", s)
 	}
+	return DateFromTime(t), nil
+}
+
+// IsZero reports whether the date is unset.
+func (d Date) IsZero() bool {
+	return d.Year == 0 && d.Month == 0 && d.Day == 0
+}
+
+// Time returns the date as midnight UTC.
+func (d Date) Time() time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.UTC)
 }
 
 func (d Date) String() string {
@@ -56,3 +117,273 @@ func (d Date) SubMonth() Date {
 	}
 	return Date{Year: d.Year, Month: d.Month - 1, MonthNum: int(d.Month - 1), Day: d.Day}
 }
+
+// Total is the sum and number of purchases in a group. Group maps each of
+// the fields the purchases were grouped by to its value.
+type Total struct {
+	Group map[string]string `json:"group"`
+	NOK   int               `json:"nok"`
+	Count int               `json:"count"`
+}
+
+// Stats summarize a set of purchases.
+type Stats struct {
+	NOK     int  `json:"nok"`
+	Count   int  `json:"count"`
+	Average int  `json:"average"`
+	First   Date `json:"first"`
+	Last    Date `json:"last"`
+
+	// PerMonth is the average number of purchases per month between the
+	// first and last purchase.
+	PerMonth float64 `json:"per_month"`
+}
+
+// PurchasePage is one page of the purchases matching a query, along with the
+// total number of purchases matching it.
+type PurchasePage struct {
+	Purchases  []*Purchase `json:"purchases"`
+	Total      int         `json:"total"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// SearchResult is a purchase found by a search.
+type SearchResult struct {
+	Purchase *Purchase `json:"purchase"`
+	Rank     float64   `json:"rank"`
+
+	// Highlights holds the fields the search matched, HTML escaped and with
+	// the matching words wrapped in <mark>. Tags are joined by commas.
+	Highlights map[string]string `json:"highlights"`
+}
+
+// Share is the part of a purchase one person is responsible for, relative to
+// the weights of the other shares of the purchase.
+type Share struct {
+	Person string `json:"person" binding:"required"`
+	Weight int    `json:"weight" binding:"required,min=1"`
+}
+
+// AccountPayer is the person paying for purchases made from an account.
+type AccountPayer struct {
+	Account string `json:"account" binding:"required"`
+	Person  string `json:"person" binding:"required"`
+}
+
+// Settlement is money paid from one person to another to settle up. It
+// belongs to either the household or the user who recorded it.
+type Settlement struct {
+	ID        int    `json:"id"`
+	Date      Date   `json:"date"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	NOK       int    `json:"nok"`
+	Owner     string `json:"owner,omitempty"`
+	Household *int   `json:"household,omitempty"`
+}
+
+// Balance is how much a person is owed by the rest of the household. It is
+// negative if the person owes money.
+type Balance struct {
+	Person string `json:"person"`
+	NOK    int    `json:"nok"`
+}
+
+// Transfer is a payment which settles balances.
+type Transfer struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	NOK  int    `json:"nok"`
+}
+
+// Subscription is a recurring payment to a vendor.
+type Subscription struct {
+	Vendor   string `json:"vendor"`
+	Category string `json:"category"` // category of the latest charge
+	Interval string `json:"interval"` // weekly, monthly or yearly
+	NOK      int    `json:"nok"`      // amount of the latest charge
+	Charges  int    `json:"charges"`
+	First    Date   `json:"first"`
+	Last     Date   `json:"last"`
+	Next     Date   `json:"next"`
+
+	// AnnualNOK is what the subscription costs per year at the latest price.
+	AnnualNOK int `json:"annual_nok"`
+
+	// PriceIncrease is how much more the latest charge was than the one
+	// before it, if it was more.
+	PriceIncrease int `json:"price_increase"`
+
+	// Missed is true when the next charge is overdue, which usually means
+	// the subscription has ended.
+	Missed bool `json:"missed"`
+}
+
+// Forecast projects the spending of a month from what has been spent so far.
+type Forecast struct {
+	Month    Date `json:"month"`
+	DaysLeft int  `json:"days_left"`
+
+	SpentNOK     int `json:"spent_nok"`
+	ProjectedNOK int `json:"projected_nok"`
+
+	// RecurringNOK is the part of the projection which is due to recurring
+	// payments expected later in the month.
+	RecurringNOK int `json:"recurring_nok"`
+
+	// IncomeNOK is the configured monthly income, and BalanceNOK what is
+	// projected to be left of it at the end of the month.
+	IncomeNOK  int `json:"income_nok"`
+	BalanceNOK int `json:"balance_nok"`
+
+	Categories []*CategoryForecast `json:"categories"`
+}
+
+// CategoryForecast projects the spending in one category.
+type CategoryForecast struct {
+	Category     string `json:"category"`
+	SpentNOK     int    `json:"spent_nok"`
+	ProjectedNOK int    `json:"projected_nok"`
+}
+
+// Session is a user logged in to the web UI.
+type Session struct {
+	Username  string
+	CSRFToken string
+	Expires   time.Time
+}
+
+// APIToken gives scripts access to the API on behalf of a user. The token
+// itself is only known when it is created.
+type APIToken struct {
+	ID       int        `json:"id"`
+	Name     string     `json:"name"`
+	Token    string     `json:"token,omitempty"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used"`
+}
+
+// Roles of users, each allowed everything the previous one is.
+const (
+	RoleViewer = "viewer" // sees the purchases of their accounts
+	RoleEditor = "editor" // also edits them
+	RoleAdmin  = "admin"  // also manages users, households and accounts
+)
+
+// Roles lists the roles from least to most privileged.
+var Roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// User is someone who can log in. Their budget overrides that of their
+// households, and reports are pushed to their pushover user key.
+type User struct {
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	Budget       *int   `json:"budget"`
+	PushoverUser string `json:"pushover_user"`
+}
+
+// Can reports whether the user's role grants at least the given role.
+func (u *User) Can(role string) bool {
+	return roleRank(u.Role) >= roleRank(role)
+}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// Household is a group of users sharing accounts.
+type Household struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Budget  *int     `json:"budget"`
+	Members []string `json:"members"`
+}
+
+// Account is a bank account, owned by either a user or a household. Accounts
+// nobody owns are only seen by admins.
+type Account struct {
+	Name      string `json:"account"`
+	Owner     string `json:"owner,omitempty"`
+	Household *int   `json:"household,omitempty"`
+}
+
+// What started a sync with Sbanken.
+const (
+	SyncStartup   = "startup"
+	SyncScheduled = "scheduled"
+	SyncManual    = "manual"
+	// SyncBackfill loads the purchases of a period further back than other
+	// syncs reach.
+	SyncBackfill = "backfill"
+)
+
+// SyncRun is one attempt at loading purchases from Sbanken.
+type SyncRun struct {
+	ID       int            `json:"id"`
+	Trigger  string         `json:"trigger"`
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished"` // nil while running
+	Accounts []*AccountSync `json:"accounts"`
+	Error    string         `json:"error,omitempty"`
+	// the bank rejected the client's credentials
+	AuthFailed bool `json:"auth_failed"`
+}
+
+// AccountSync is the outcome of loading the purchases of one account.
+type AccountSync struct {
+	Account    string `json:"account"`
+	Fetched    int    `json:"fetched"`
+	Added      int    `json:"added"`
+	Updated    int    `json:"updated"`
+	Duplicates int    `json:"duplicates"`
+	Error      string `json:"error,omitempty"`
+	// the IDs of the purchases which were new
+	New []string `json:"new"`
+}
+
+// Webhook is a URL which events are posted to.
+type Webhook struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// used to sign the payloads, and only shown when the webhook is created
+	Secret string `json:"secret,omitempty"`
+	// the types of events to send, or every type if empty
+	Events  []string  `json:"events"`
+	Active  bool      `json:"active"`
+	Created time.Time `json:"created"`
+}
+
+// Wants reports whether events of the given type are sent to the webhook.
+func (w *Webhook) Wants(event string) bool {
+	if !w.Active {
+		return false
+	}
+	if len(w.Events) < 1 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one attempt at posting an event to a webhook.
+type WebhookDelivery struct {
+	ID       int       `json:"id"`
+	Webhook  int       `json:"webhook"`
+	EventID  string    `json:"event_id"`
+	Event    string    `json:"event"`
+	Payload  string    `json:"payload"`
+	Attempt  int       `json:"attempt"`
+	Status   int       `json:"status"` // 0 when no response was received
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+	Duration int       `json:"duration_ms"`
+}
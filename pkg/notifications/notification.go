@@ -4,34 +4,38 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"text/template"
 	"time"
 
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/forecast"
+	"github.com/j18e/sbanken-client/pkg/metrics"
 	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/recurring"
 	"github.com/j18e/sbanken-client/pkg/storage"
-	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
 )
 
 type Notifier interface {
 	Run(context.Context) error
+
+	// Report returns the spending report of a user, or the one covering
+	// every purchase for an empty username.
+	Report(username string) (string, error)
+
+	// SendReports sends the spending report to everyone who gets them.
+	SendReports() error
 }
 
-func NewNotifier(stor *storage.Storage) Notifier {
-	var conf struct {
-		ServerURL        string   `required:"true" envconfig:"FINANCES_URL"`
-		PushoverUser     string   `required:"true" envconfig:"PUSHOVER_USER"`
-		PushoverToken    string   `required:"true" envconfig:"PUSHOVER_TOKEN"`
-		NotifyHour       int      `required:"true" envconfig:"NOTIFY_HOUR"`
-		ReportCategories []string `required:"false" envconfig:"REPORT_CATEGORIES"`
-	}
-	if err := envconfig.Process("", &conf); err != nil {
-		log.Fatal(err)
+func NewNotifier(conf config.Notifications, stor *storage.Storage) (Notifier, error) {
+	if conf.NotifyHour == nil {
+		return nil, errors.New("no notify hour configured")
 	}
-	if conf.NotifyHour < 0 || conf.NotifyHour > 23 {
-		log.Fatalf("notify hour %d inavlid - must be between 0 and 23")
+	if hour := *conf.NotifyHour; hour < 0 || hour > 23 {
+		return nil, fmt.Errorf("notify hour %d invalid - must be between 0 and 23", hour)
 	}
 	return &notifier{
 		serverURL:     conf.ServerURL,
@@ -40,8 +44,11 @@ func NewNotifier(stor *storage.Storage) Notifier {
 		storage:       stor,
 		client:        http.Client{Timeout: time.Second * 5},
 		categories:    conf.ReportCategories,
-		notifyHour:    conf.NotifyHour,
-	}
+		notifyHour:    *conf.NotifyHour,
+		syncFailures:  conf.SyncFailures,
+
+		lastSyncChecked: -1,
+	}, nil
 }
 
 type notifier struct {
@@ -52,60 +59,217 @@ type notifier struct {
 	storage       *storage.Storage
 	client        http.Client
 	notifyHour    int
+	syncFailures  int
+
+	// the latest sync syncAlerts has looked at
+	lastSyncChecked int
 }
 
+// syncCheckInterval is how often the outcome of syncs is checked.
+const syncCheckInterval = 5 * time.Minute
+
 func (n *notifier) Run(ctx context.Context) error {
+	report := time.NewTimer(time.Until(n.nextReport()))
+	defer report.Stop()
+	syncCheck := time.NewTicker(syncCheckInterval)
+	defer syncCheck.Stop()
+	if err := n.syncAlerts(); err != nil {
+		log.Errorf("checking syncs: %v", err)
+	}
 	for {
-		now := time.Now()
-		notifyTime := time.Date(now.Year(), now.Month(), now.Day(), n.notifyHour, 0, 0, 0, time.Local)
-		if now.After(notifyTime) {
-			notifyTime = notifyTime.Add(time.Hour * 24)
-		}
-		log.Infof("waiting to send a spending report at %v", notifyTime)
-		ticker := time.After(time.Until(notifyTime))
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-ticker:
-			if err := n.report(); err != nil {
-				log.Errorf("generating/sending report: %v", err)
+		case <-syncCheck.C:
+			if err := n.syncAlerts(); err != nil {
+				log.Errorf("checking syncs: %v", err)
+			}
+		case <-report.C:
+			if err := n.SendReports(); err != nil {
+				log.Error(err)
 			}
 			time.Sleep(time.Minute)
+			report.Reset(time.Until(n.nextReport()))
+		}
+	}
+}
+
+// nextReport returns when the next spending report is due.
+func (n *notifier) nextReport() time.Time {
+	now := time.Now()
+	notifyTime := time.Date(now.Year(), now.Month(), now.Day(), n.notifyHour, 0, 0, 0, time.Local)
+	if now.After(notifyTime) {
+		notifyTime = notifyTime.Add(time.Hour * 24)
+	}
+	log.Infof("waiting to send a spending report at %v", notifyTime)
+	return notifyTime
+}
+
+// recipient is someone reports are sent to, about the purchases they can
+// see.
+type recipient struct {
+	username     string // empty for the instance wide PUSHOVER_USER
+	pushoverUser string
+	access       *storage.Access
+}
+
+func (r recipient) String() string {
+	if r.username == "" {
+		return "PUSHOVER_USER"
+	}
+	return r.username
+}
+
+// recipients returns every user with a pushover user key, along with
+// PUSHOVER_USER if it's set, who is sent reports on every purchase.
+func (n *notifier) recipients() ([]recipient, error) {
+	var res []recipient
+	if n.pushoverUser != "" {
+		res = append(res, recipient{pushoverUser: n.pushoverUser})
+	}
+	users, err := n.storage.Users()
+	if err != nil {
+		return res, err
+	}
+	for _, u := range users {
+		if u.PushoverUser == "" {
+			continue
+		}
+		res = append(res, recipient{
+			username:     u.Username,
+			pushoverUser: u.PushoverUser,
+			access:       &storage.Access{Username: u.Username, Admin: u.Can(models.RoleAdmin)},
+		})
+	}
+	return res, nil
+}
+
+// SendReports sends every recipient their spending report, along with any
+// new subscriptions, returning an error if any of them failed.
+func (n *notifier) SendReports() error {
+	recipients, err := n.recipients()
+	if err != nil {
+		return fmt.Errorf("getting report recipients: %w", err)
+	}
+	var failed int
+	for _, r := range recipients {
+		// the report and the check for new subscriptions share the
+		// recurring payments, which take a while to find
+		subs, err := recurring.Find(n.storage, r.access)
+		if err != nil {
+			log.Errorf("finding recurring payments of %s: %v", r, err)
+			failed++
+			continue
 		}
+		if err := n.report(r, subs); err != nil {
+			log.Errorf("generating/sending report to %s: %v", r, err)
+			failed++
+		}
+		if err := n.newSubscriptions(r, subs); err != nil {
+			log.Errorf("checking for new subscriptions of %s: %v", r, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d reports could not be sent", failed, len(recipients))
 	}
+	return nil
 }
 
-func (n *notifier) report() error {
+func (n *notifier) Report(username string) (string, error) {
+	var access *storage.Access
+	if username != "" {
+		u, err := n.storage.GetUser(username)
+		if err != nil {
+			return "", fmt.Errorf("getting user %s: %w", username, err)
+		}
+		access = &storage.Access{Username: u.Username, Admin: u.Can(models.RoleAdmin)}
+	}
+	subs, err := recurring.Find(n.storage, access)
+	if err != nil {
+		return "", fmt.Errorf("finding recurring payments: %w", err)
+	}
+	return n.reportMessage(access, subs)
+}
+
+// reportMessage templates the spending report of the purchases the access
+// allows, given the recurring payments found through it.
+func (n *notifier) reportMessage(access *storage.Access, subs []*models.Subscription) (string, error) {
 	date := models.DateToday()
-	purchases, err := n.storage.GetPurchases(date)
+	filter := storage.MonthFilter(date)
+	filter.Access = access
+	total, err := n.storage.Total(filter)
+	if err != nil {
+		return "", fmt.Errorf("getting total from storage: %w", err)
+	}
+	totals, err := n.storage.Totals(filter, "category")
 	if err != nil {
-		return fmt.Errorf("getting purchases from storage: %w", err)
+		return "", fmt.Errorf("getting category totals from storage: %w", err)
 	}
-	total := 0
-	for _, p := range purchases {
-		total += p.NOK
+
+	fc, err := forecast.Month(n.storage, date, 0, access, subs)
+	if err != nil {
+		return "", fmt.Errorf("forecasting spending: %w", err)
 	}
 
-	msg, err := templateReport(date.Month, total, categoryTotals(n.categories, purchases))
+	msg, err := templateReport(date.Month, total, fc.ProjectedNOK, categoryTotals(n.categories, totals))
 	if err != nil {
-		return fmt.Errorf("templating report: %w", err)
+		return "", fmt.Errorf("templating report: %w", err)
 	}
+	return msg, nil
+}
 
-	if err := n.send(msg); err != nil {
+func (n *notifier) report(r recipient, subs []*models.Subscription) error {
+	msg, err := n.reportMessage(r.access, subs)
+	if err != nil {
+		return err
+	}
+	if err := n.send(r.pushoverUser, msg); err != nil {
 		return fmt.Errorf("sending message: %w", err)
 	}
 	return nil
 }
 
-func templateReport(month time.Month, total int, categories map[string]int) (string, error) {
+// newSubscriptions notifies about those of the recurring payments found which
+// haven't been seen before. The first time subscriptions are found they are
+// only recorded, so as not to notify about every existing subscription at
+// once.
+func (n *notifier) newSubscriptions(r recipient, subs []*models.Subscription) error {
+	known, err := n.storage.KnownSubscriptions(r.username)
+	if err != nil {
+		return fmt.Errorf("getting known subscriptions: %w", err)
+	}
+	firstRun := len(known) == 0
+
+	for _, sub := range subs {
+		if known[sub.Vendor] || sub.Missed {
+			continue
+		}
+		if !firstRun {
+			msg := fmt.Sprintf("New subscription: %s charges %d NOK %s (%d NOK per year). Next charge on %s.",
+				sub.Vendor, sub.NOK, sub.Interval, sub.AnnualNOK, sub.Next)
+			if err := n.send(r.pushoverUser, msg); err != nil {
+				return fmt.Errorf("sending message: %w", err)
+			}
+		}
+		if err := n.storage.AddKnownSubscription(r.username, sub.Vendor); err != nil {
+			return fmt.Errorf("storing known subscription: %w", err)
+		}
+	}
+	return nil
+}
+
+func templateReport(month time.Month, total, projected int, categories map[string]int) (string, error) {
 	data := struct {
 		Month      time.Month
 		Total      int
+		Projected  int
 		Categories map[string]int
 	}{
-		month, total, categories,
+		month, total, projected, categories,
 	}
 	tpl, err := template.New("").Parse(`Spending so far in {{.Month}}: {{.Total}} NOK
+at this pace you'll spend {{.Projected}} NOK in {{.Month}}
 spending in categories:
 {{- range $k, $v := .Categories }}
 {{$k}}: {{$v}} NOK
@@ -120,21 +284,34 @@ spending in categories:
 	return buf.String(), nil
 }
 
-func categoryTotals(categories []string, purchases []*models.Purchase) map[string]int {
+// categoryTotals picks the totals of the given categories out of totals
+// grouped by category, including categories without any spending.
+func categoryTotals(categories []string, totals []*models.Total) map[string]int {
 	results := make(map[string]int)
 	for _, cat := range categories {
 		results[cat] = 0
 	}
-	for _, p := range purchases {
-		if _, ok := results[p.Category]; !ok {
+	for _, t := range totals {
+		cat := t.Group["category"]
+		if _, ok := results[cat]; !ok {
 			continue
 		}
-		results[p.Category] += p.NOK
+		results[cat] = t.NOK
 	}
 	return results
 }
 
-func (n *notifier) send(msg string) error {
+// send pushes a message to a pushover user, counting it in the metrics.
+func (n *notifier) send(pushoverUser, msg string) error {
+	if err := n.push(pushoverUser, msg); err != nil {
+		metrics.Notifications.WithLabelValues("failed").Inc()
+		return err
+	}
+	metrics.Notifications.WithLabelValues("sent").Inc()
+	return nil
+}
+
+func (n *notifier) push(pushoverUser, msg string) error {
 	type pushoverMessage struct {
 		User    string `json:"user"`
 		Token   string `json:"token"`
@@ -153,7 +330,7 @@ func (n *notifier) send(msg string) error {
 
 	buf := new(bytes.Buffer)
 	json.NewEncoder(buf).Encode(&pushoverMessage{
-		User:    n.pushoverUser,
+		User:    pushoverUser,
 		Token:   n.pushoverToken,
 		Message: msg,
 	})
@@ -0,0 +1,132 @@
+package notifications
+
+import (
+	"fmt"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// syncAlerts notifies admins about syncs with Sbanken which keep failing.
+// Only syncs which finished since the last check are considered, and the
+// first check only takes note of where to start.
+func (n *notifier) syncAlerts() error {
+	if n.lastSyncChecked < 0 {
+		runs, err := n.storage.SyncRuns(1)
+		if err != nil {
+			return fmt.Errorf("getting syncs: %w", err)
+		}
+		n.lastSyncChecked = 0
+		if len(runs) > 0 {
+			// a sync which is still running is checked once it's done
+			n.lastSyncChecked = runs[0].ID
+			if runs[0].Finished == nil {
+				n.lastSyncChecked--
+			}
+		}
+		return nil
+	}
+
+	// the syncs before the new ones tell how long a streak already was
+	runs, err := n.storage.SyncRunsSince(n.lastSyncChecked, n.syncFailures)
+	if err != nil {
+		return fmt.Errorf("getting syncs: %w", err)
+	}
+	fresh := 0
+	for _, run := range runs {
+		if run.ID > n.lastSyncChecked {
+			fresh++
+		}
+	}
+	if fresh < 1 {
+		return nil
+	}
+	n.lastSyncChecked = runs[0].ID
+
+	recipients, err := n.recipients()
+	if err != nil {
+		return fmt.Errorf("getting recipients: %w", err)
+	}
+	for _, msg := range syncProblems(runs, fresh, n.syncFailures) {
+		for _, r := range recipients {
+			if r.access != nil && !r.access.Admin {
+				continue
+			}
+			if err := n.send(r.pushoverUser, msg); err != nil {
+				return fmt.Errorf("sending message: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// syncProblems returns what to alert about given finished syncs, newest
+// first, of which the given number are new: credentials which were rejected,
+// and syncs or accounts which reached the given number of failures in a row
+// during one of the new syncs. Alerting only when a streak reaches the limit
+// avoids repeating the alert every sync, and looking at every new sync rather
+// than the latest keeps several syncs finishing between checks from skipping
+// past the limit.
+func syncProblems(runs []*models.SyncRun, fresh, failures int) []string {
+	var res []string
+	for i := fresh - 1; i >= 0; i-- {
+		if runs[i].AuthFailed && (i+1 == len(runs) || !runs[i+1].AuthFailed) {
+			res = append(res, fmt.Sprintf("Sbanken rejected the client credentials, which need to be renewed: %s",
+				runs[i].Error))
+			break
+		}
+	}
+
+	// rejected credentials have been alerted about already
+	for i := fresh - 1; i >= 0; i-- {
+		if !runs[i].AuthFailed && syncStreak(runs[i:]) == failures {
+			res = append(res, fmt.Sprintf("Loading purchases from Sbanken has failed %d times in a row: %s",
+				failures, runs[i].Error))
+			break
+		}
+	}
+
+	// syncs which failed altogether say nothing about single accounts
+	alerted := make(map[string]bool)
+	for i := fresh - 1; i >= 0; i-- {
+		for _, acct := range runs[i].Accounts {
+			if acct.Error == "" || alerted[acct.Account] || accountStreak(runs[i:], acct.Account) != failures {
+				continue
+			}
+			alerted[acct.Account] = true
+			res = append(res, fmt.Sprintf("Loading purchases from account %s has failed %d times in a row: %s",
+				acct.Account, failures, acct.Error))
+		}
+	}
+	return res
+}
+
+// syncStreak counts the syncs which failed in a row, starting with the first.
+func syncStreak(runs []*models.SyncRun) int {
+	streak := 0
+	for _, run := range runs {
+		if run.Error == "" {
+			break
+		}
+		streak++
+	}
+	return streak
+}
+
+// accountStreak counts the syncs in which loading the purchases of an account
+// failed in a row, starting with the first. Syncs which didn't get to the
+// account don't break the streak.
+func accountStreak(runs []*models.SyncRun, account string) int {
+	streak := 0
+	for _, run := range runs {
+		for _, a := range run.Accounts {
+			if a.Account != account {
+				continue
+			}
+			if a.Error == "" {
+				return streak
+			}
+			streak++
+		}
+	}
+	return streak
+}
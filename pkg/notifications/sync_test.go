@@ -0,0 +1,107 @@
+package notifications
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// syncs returns finished syncs, newest first, out of their outcomes from
+// oldest to newest: "ok", "fail", "auth", or the name of an account which
+// failed while the rest of the sync worked.
+func syncs(outcomes ...string) []*models.SyncRun {
+	res := make([]*models.SyncRun, len(outcomes))
+	for i, o := range outcomes {
+		run := &models.SyncRun{ID: i + 1, Accounts: []*models.AccountSync{{Account: "main"}, {Account: "savings"}}}
+		switch o {
+		case "ok":
+		case "fail":
+			run.Error, run.Accounts = "timeout", nil
+		case "auth":
+			run.Error, run.Accounts, run.AuthFailed = "unauthorized", nil, true
+		default:
+			for _, a := range run.Accounts {
+				if a.Account == o {
+					a.Error = "bad gateway"
+				}
+			}
+		}
+		res[len(outcomes)-1-i] = run
+	}
+	return res
+}
+
+func TestSyncProblems(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		runs  []*models.SyncRun
+		fresh int
+		want  []string
+	}{
+		{
+			name:  "all fine",
+			runs:  syncs("ok", "ok", "ok", "ok"),
+			fresh: 1,
+		},
+		{
+			name:  "limit reached by the latest",
+			runs:  syncs("ok", "fail", "fail", "fail"),
+			fresh: 1,
+			want:  []string{"Loading purchases from Sbanken has failed 3 times in a row: timeout"},
+		},
+		{
+			name:  "limit passed between checks",
+			runs:  syncs("ok", "fail", "fail", "fail", "fail", "fail"),
+			fresh: 4,
+			want:  []string{"Loading purchases from Sbanken has failed 3 times in a row: timeout"},
+		},
+		{
+			name:  "limit reached before the last check",
+			runs:  syncs("fail", "fail", "fail", "fail"),
+			fresh: 1,
+		},
+		{
+			name:  "limit reached and recovered between checks",
+			runs:  syncs("ok", "fail", "fail", "fail", "ok"),
+			fresh: 4,
+			want:  []string{"Loading purchases from Sbanken has failed 3 times in a row: timeout"},
+		},
+		{
+			name:  "credentials rejected",
+			runs:  syncs("ok", "fail", "auth"),
+			fresh: 1,
+			want:  []string{"Sbanken rejected the client credentials, which need to be renewed: unauthorized"},
+		},
+		{
+			name:  "credentials rejected between checks",
+			runs:  syncs("ok", "auth", "auth", "auth"),
+			fresh: 3,
+			want:  []string{"Sbanken rejected the client credentials, which need to be renewed: unauthorized"},
+		},
+		{
+			name:  "credentials still rejected",
+			runs:  syncs("auth", "auth", "auth", "auth"),
+			fresh: 1,
+		},
+		{
+			name:  "account failing across failed syncs",
+			runs:  syncs("ok", "savings", "fail", "savings", "savings"),
+			fresh: 2,
+			want:  []string{"Loading purchases from account savings has failed 3 times in a row: bad gateway"},
+		},
+		{
+			name:  "account failing with another recovered",
+			runs:  syncs("main", "savings", "savings", "savings"),
+			fresh: 3,
+			want:  []string{"Loading purchases from account savings has failed 3 times in a row: bad gateway"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := syncProblems(tc.runs, tc.fresh, 3)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Package recurring finds subscriptions and other recurring payments among
+// stored purchases.
+package recurring
+
+import (
+	"sort"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// interval describes a kind of recurring payment.
+type interval struct {
+	name       string
+	minDays    int // shortest gap between charges
+	maxDays    int // longest gap between charges
+	minCharges int
+	perYear    int
+	grace      int // days a charge may be late before it is missed
+	next       func(time.Time) time.Time
+}
+
+var intervals = []interval{
+	{"weekly", 6, 8, 4, 52, 3, func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }},
+	{"monthly", 26, 35, 3, 12, 7, func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"yearly", 350, 380, 2, 1, 21, func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+const (
+	// share of charges which must be close to the usual amount and of gaps
+	// which must be close to the interval
+	minRegularShare = 0.75
+	// how far from the usual amount a charge may be
+	amountTolerance = 0.2
+)
+
+// Detect finds the vendors in purchases which charge similar amounts at
+// regular intervals. today is used to find charges which are overdue.
+func Detect(purchases []*models.Purchase, today models.Date) []*models.Subscription {
+	byVendor := make(map[string][]*models.Purchase)
+	for _, p := range purchases {
+		byVendor[p.Vendor] = append(byVendor[p.Vendor], p)
+	}
+
+	res := []*models.Subscription{}
+	for vendor, px := range byVendor {
+		sort.Slice(px, func(i, j int) bool { return px[i].Date.Time().Before(px[j].Date.Time()) })
+		for _, iv := range intervals {
+			if sub := detect(vendor, px, iv, today); sub != nil {
+				res = append(res, sub)
+				break
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].AnnualNOK > res[j].AnnualNOK })
+	return res
+}
+
+// detect returns the subscription to vendor if px, sorted by date, are
+// regular charges at the given interval.
+func detect(vendor string, px []*models.Purchase, iv interval, today models.Date) *models.Subscription {
+	if len(px) < iv.minCharges {
+		return nil
+	}
+
+	// the gaps between charges must match the interval
+	regular := 0
+	for i := 1; i < len(px); i++ {
+		days := int(px[i].Date.Time().Sub(px[i-1].Date.Time()).Hours() / 24)
+		if days >= iv.minDays && days <= iv.maxDays {
+			regular++
+		}
+	}
+	if float64(regular) < minRegularShare*float64(len(px)-1) {
+		return nil
+	}
+
+	// and so must the amounts, allowing for the odd price change
+	amounts := make([]int, len(px))
+	for i, p := range px {
+		amounts[i] = p.NOK
+	}
+	sort.Ints(amounts)
+	median := amounts[len(amounts)/2]
+	similar := 0
+	for _, p := range px {
+		diff := float64(p.NOK - median)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= amountTolerance*float64(median) {
+			similar++
+		}
+	}
+	if float64(similar) < minRegularShare*float64(len(px)) {
+		return nil
+	}
+
+	last := px[len(px)-1]
+	next := iv.next(last.Date.Time())
+	sub := &models.Subscription{
+		Vendor:    vendor,
+		Category:  last.Category,
+		Interval:  iv.name,
+		NOK:       last.NOK,
+		Charges:   len(px),
+		First:     px[0].Date,
+		Last:      last.Date,
+		Next:      models.DateFromTime(next),
+		AnnualNOK: last.NOK * iv.perYear,
+		Missed:    today.Time().After(next.AddDate(0, 0, iv.grace)),
+	}
+	if prev := px[len(px)-2]; last.NOK > prev.NOK {
+		sub.PriceIncrease = last.NOK - prev.NOK
+	}
+	return sub
+}
+
+// lookbackYears is how far back purchases are searched for recurring payments,
+// which must be long enough to see a yearly payment more than once.
+const lookbackYears = 3
+
+// Find detects the recurring payments among the purchases in storage visible
+// through the access.
+func Find(stor *storage.Storage, access *storage.Access) ([]*models.Subscription, error) {
+	today := models.DateToday()
+	purchases, err := stor.Purchases(storage.Filter{
+		From:   models.DateFromTime(today.Time().AddDate(-lookbackYears, 0, 0)),
+		Access: access,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Detect(purchases, today), nil
+}
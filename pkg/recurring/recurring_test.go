@@ -0,0 +1,172 @@
+package recurring
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+func date(t *testing.T, s string) models.Date {
+	t.Helper()
+	d, err := models.ParseDate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// charges returns purchases from the vendor on the given dates, each of the
+// amount at the same position or of the last amount given.
+func charges(t *testing.T, vendor string, amounts []int, dates ...string) []*models.Purchase {
+	t.Helper()
+	var res []*models.Purchase
+	for i, s := range dates {
+		nok := amounts[len(amounts)-1]
+		if i < len(amounts) {
+			nok = amounts[i]
+		}
+		res = append(res, &models.Purchase{Vendor: vendor, Category: "bills", NOK: nok, Date: date(t, s)})
+	}
+	return res
+}
+
+func TestDetect(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		purchases []*models.Purchase
+		today     string
+		want      []*models.Subscription
+	}{
+		{
+			name: "monthly",
+			purchases: charges(t, "netflix", []int{129},
+				"2024-01-15", "2024-02-15", "2024-03-15", "2024-04-15"),
+			today: "2024-05-01",
+			want: []*models.Subscription{{Vendor: "netflix", Category: "bills", Interval: "monthly", NOK: 129,
+				Charges: 4, First: date(t, "2024-01-15"), Last: date(t, "2024-04-15"), Next: date(t, "2024-05-15"),
+				AnnualNOK: 1548}},
+		},
+		{
+			name: "monthly at the end of the month",
+			purchases: charges(t, "netflix", []int{129},
+				"2024-01-31", "2024-02-29", "2024-03-31", "2024-04-30"),
+			today: "2024-05-01",
+			want: []*models.Subscription{{Vendor: "netflix", Category: "bills", Interval: "monthly", NOK: 129,
+				Charges: 4, First: date(t, "2024-01-31"), Last: date(t, "2024-04-30"), Next: date(t, "2024-05-30"),
+				AnnualNOK: 1548}},
+		},
+		{
+			name: "weekly",
+			purchases: charges(t, "gym", []int{50},
+				"2024-03-04", "2024-03-11", "2024-03-18", "2024-03-25", "2024-04-01"),
+			today: "2024-04-03",
+			want: []*models.Subscription{{Vendor: "gym", Category: "bills", Interval: "weekly", NOK: 50,
+				Charges: 5, First: date(t, "2024-03-04"), Last: date(t, "2024-04-01"), Next: date(t, "2024-04-08"),
+				AnnualNOK: 2600}},
+		},
+		{
+			name:      "yearly",
+			purchases: charges(t, "insurance", []int{999}, "2022-06-01", "2023-06-01", "2024-06-03"),
+			today:     "2024-07-01",
+			want: []*models.Subscription{{Vendor: "insurance", Category: "bills", Interval: "yearly", NOK: 999,
+				Charges: 3, First: date(t, "2022-06-01"), Last: date(t, "2024-06-03"), Next: date(t, "2025-06-03"),
+				AnnualNOK: 999}},
+		},
+		{
+			name: "irregular",
+			purchases: charges(t, "grocer", []int{129},
+				"2024-01-01", "2024-01-04", "2024-01-24", "2024-03-09", "2024-03-19"),
+			today: "2024-04-01",
+			want:  []*models.Subscription{},
+		},
+		{
+			name:      "too few charges",
+			purchases: charges(t, "netflix", []int{129}, "2024-01-15", "2024-02-15"),
+			today:     "2024-03-01",
+			want:      []*models.Subscription{},
+		},
+		{
+			name: "amounts too different",
+			purchases: charges(t, "grocer", []int{100, 500, 60, 300},
+				"2024-01-15", "2024-02-15", "2024-03-15", "2024-04-15"),
+			today: "2024-05-01",
+			want:  []*models.Subscription{},
+		},
+		{
+			name: "one month skipped",
+			purchases: charges(t, "netflix", []int{129},
+				"2024-01-15", "2024-02-15", "2024-03-15", "2024-05-15", "2024-06-15", "2024-07-15"),
+			today: "2024-07-20",
+			want: []*models.Subscription{{Vendor: "netflix", Category: "bills", Interval: "monthly", NOK: 129,
+				Charges: 6, First: date(t, "2024-01-15"), Last: date(t, "2024-07-15"), Next: date(t, "2024-08-15"),
+				AnnualNOK: 1548}},
+		},
+		{
+			name: "price increase",
+			purchases: charges(t, "netflix", []int{129, 129, 149},
+				"2024-01-15", "2024-02-15", "2024-03-15"),
+			today: "2024-04-01",
+			want: []*models.Subscription{{Vendor: "netflix", Category: "bills", Interval: "monthly", NOK: 149,
+				Charges: 3, First: date(t, "2024-01-15"), Last: date(t, "2024-03-15"), Next: date(t, "2024-04-15"),
+				AnnualNOK: 1788, PriceIncrease: 20}},
+		},
+		{
+			name: "missed",
+			purchases: charges(t, "netflix", []int{129},
+				"2023-11-15", "2023-12-15", "2024-01-15"),
+			today: "2024-02-23",
+			want: []*models.Subscription{{Vendor: "netflix", Category: "bills", Interval: "monthly", NOK: 129,
+				Charges: 3, First: date(t, "2023-11-15"), Last: date(t, "2024-01-15"), Next: date(t, "2024-02-15"),
+				AnnualNOK: 1548, Missed: true}},
+		},
+		{
+			name: "within the grace period",
+			purchases: charges(t, "netflix", []int{129},
+				"2023-11-15", "2023-12-15", "2024-01-15"),
+			today: "2024-02-22",
+			want: []*models.Subscription{{Vendor: "netflix", Category: "bills", Interval: "monthly", NOK: 129,
+				Charges: 3, First: date(t, "2023-11-15"), Last: date(t, "2024-01-15"), Next: date(t, "2024-02-15"),
+				AnnualNOK: 1548}},
+		},
+		{
+			name: "most costly first",
+			purchases: append(charges(t, "netflix", []int{129}, "2024-02-15", "2024-01-15", "2024-03-15"),
+				charges(t, "gym", []int{50}, "2024-03-04", "2024-03-11", "2024-03-18", "2024-03-25")...),
+			today: "2024-03-26",
+			want: []*models.Subscription{
+				{Vendor: "gym", Category: "bills", Interval: "weekly", NOK: 50, Charges: 4,
+					First: date(t, "2024-03-04"), Last: date(t, "2024-03-25"), Next: date(t, "2024-04-01"),
+					AnnualNOK: 2600},
+				{Vendor: "netflix", Category: "bills", Interval: "monthly", NOK: 129, Charges: 3,
+					First: date(t, "2024-01-15"), Last: date(t, "2024-03-15"), Next: date(t, "2024-04-15"),
+					AnnualNOK: 1548},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Detect(tc.purchases, date(t, tc.today))
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d subscriptions, want %d", len(got), len(tc.want))
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i], tc.want[i]) {
+					t.Errorf("got subscription %+v, want %+v", *got[i], *tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	// each interval's next charge falls within the gaps it allows
+	start := time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)
+	for _, iv := range intervals {
+		days := int(iv.next(start).Sub(start).Hours() / 24)
+		if days < iv.minDays || days > iv.maxDays {
+			t.Errorf("%s: next charge after %d days, want between %d and %d", iv.name, days, iv.minDays,
+				iv.maxDays)
+		}
+	}
+}
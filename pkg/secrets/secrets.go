@@ -0,0 +1,95 @@
+// Package secrets keeps passwords and tokens in a file encrypted with a
+// passphrase, and hides the secrets in use from logs and error messages.
+package secrets
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Redacted replaces secrets in text.
+const Redacted = "[redacted]"
+
+// minLength is the shortest secret which is redacted, as shorter ones would
+// mangle unrelated text.
+const minLength = 4
+
+var (
+	mu       sync.RWMutex
+	replacer = strings.NewReplacer()
+	known    = make(map[string]bool)
+)
+
+// Register adds secrets which Redact hides from then on.
+func Register(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, v := range values {
+		if len(v) >= minLength {
+			known[v] = true
+		}
+	}
+
+	// replace longer secrets first, in case one contains another
+	all := make([]string, 0, len(known))
+	for v := range known {
+		all = append(all, v)
+	}
+	sort.Slice(all, func(i, j int) bool { return len(all[i]) > len(all[j]) })
+	pairs := make([]string, 0, 2*len(all))
+	for _, v := range all {
+		pairs = append(pairs, v, Redacted)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact returns s with every registered secret replaced.
+func Redact(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return replacer.Replace(s)
+}
+
+// RedactError returns an error whose message has every registered secret
+// replaced, while errors.Is and errors.As still see err.
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err}
+}
+
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return Redact(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Hook redacts the message and fields of every log entry.
+type Hook struct{}
+
+func (Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (Hook) Fire(entry *log.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for k, v := range entry.Data {
+		switch v := v.(type) {
+		case string:
+			entry.Data[k] = Redact(v)
+		case error:
+			entry.Data[k] = Redact(v.Error())
+		}
+	}
+	return nil
+}
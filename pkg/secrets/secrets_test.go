@@ -0,0 +1,167 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tempPath returns the path of a secrets file in a directory which is removed
+// after the test.
+func tempPath(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "secrets.json")
+}
+
+func TestStore(t *testing.T) {
+	path := tempPath(t)
+	s, err := Open(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := s.Names(); len(names) != 0 {
+		t.Fatalf("a new file holds %q, want nothing", names)
+	}
+	s.Set("DB_PASSWORD", "hunter22")
+	s.Set("CLIENT_SECRET", "s3cret\nwith a newline")
+	s.Set("EMPTY", "")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"hunter22", "DB_PASSWORD"} {
+		if bytes.Contains(bs, []byte(v)) {
+			t.Errorf("%s is stored in plain text", v)
+		}
+	}
+
+	s, err = Open(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Names(), []string{"CLIENT_SECRET", "DB_PASSWORD", "EMPTY"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got names %q, want %q", got, want)
+	}
+	for name, want := range map[string]string{"DB_PASSWORD": "hunter22", "CLIENT_SECRET": "s3cret\nwith a newline",
+		"EMPTY": ""} {
+		if got, ok := s.Get(name); !ok || got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+
+	if !s.Delete("EMPTY") || s.Delete("EMPTY") {
+		t.Error("deleting a secret twice didn't report it only the first time")
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = Open(path, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get("EMPTY"); ok {
+		t.Error("a deleted secret was saved")
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	path := tempPath(t)
+	s, err := Open(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("DB_PASSWORD", "hunter22")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved file
+	if err := json.Unmarshal(bs, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		passphrase string
+		tamper     func(f *file)
+	}{
+		{"wrong passphrase", "correct horse battery", func(f *file) {}},
+		{"data changed", "correct horse", func(f *file) { f.Data[0] ^= 1 }},
+		{"data cut short", "correct horse", func(f *file) { f.Data = f.Data[:len(f.Data)-1] }},
+		{"salt changed", "correct horse", func(f *file) { f.Salt[0] ^= 1 }},
+		{"nonce changed", "correct horse", func(f *file) { f.Nonce[0] ^= 1 }},
+		{"nonce cut short", "correct horse", func(f *file) { f.Nonce = f.Nonce[1:] }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := file{
+				Version: saved.Version,
+				Salt:    append([]byte(nil), saved.Salt...),
+				Nonce:   append([]byte(nil), saved.Nonce...),
+				Data:    append([]byte(nil), saved.Data...),
+			}
+			tc.tamper(&f)
+			bs, err := json.Marshal(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := tempPath(t)
+			if err := ioutil.WriteFile(p, bs, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Open(p, tc.passphrase); err != ErrWrongPassphrase {
+				t.Errorf("got error %v, want %v", err, ErrWrongPassphrase)
+			}
+		})
+	}
+
+	if _, err := Open(path, ""); err == nil {
+		t.Error("opened a secrets file without a passphrase")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	Register("hunter22", "hunter2", "abc", "s3cret-token", "s3cret")
+
+	for _, tc := range []struct{ in, want string }{
+		{"password is hunter22", "password is " + Redacted},
+		{"password is hunter2", "password is " + Redacted},
+		{"hunter222", Redacted + "2"},
+		{"token s3cret-token and s3cret", "token " + Redacted + " and " + Redacted},
+		{"abc is too short to redact", "abc is too short to redact"},
+		{"nothing to hide", "nothing to hide"},
+		{"", ""},
+	} {
+		if got := Redact(tc.in); got != tc.want {
+			t.Errorf("Redact(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	wrapped := fmt.Errorf("connecting: %w", os.ErrPermission)
+	err := RedactError(fmt.Errorf("login as admin:hunter22: %w", wrapped))
+	if got, want := err.Error(), "login as admin:"+Redacted+": connecting: permission denied"; got != want {
+		t.Errorf("got error %q, want %q", got, want)
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		t.Error("the redacted error doesn't wrap the original")
+	}
+	if RedactError(nil) != nil {
+		t.Error("redacting no error returned one")
+	}
+}
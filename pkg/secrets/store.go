@@ -0,0 +1,168 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrWrongPassphrase is returned when a secrets file can't be decrypted,
+// which also happens if it's been tampered with.
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupt secrets file")
+
+// the scrypt parameters recommended for interactive logins
+const (
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+	keyLength = 32
+	saltSize  = 16
+)
+
+// fileVersion is bumped whenever the format of secrets files changes.
+const fileVersion = 1
+
+// file is how a secrets file is stored. Data is the sealed json of the
+// secrets by name.
+type file struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// Store is a set of secrets by name, kept in a file encrypted with a key
+// derived from a passphrase.
+type Store struct {
+	path       string
+	passphrase string
+	values     map[string]string
+}
+
+// Open decrypts the secrets file at path. A file which doesn't exist yet
+// holds no secrets.
+func Open(path, passphrase string) (*Store, error) {
+	if passphrase == "" {
+		return nil, errors.New("a passphrase is required for the secrets file")
+	}
+	s := &Store{path: path, passphrase: passphrase, values: make(map[string]string)}
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var f file
+	if err := json.Unmarshal(bs, &f); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("reading %s: unsupported version %d", path, f.Version)
+	}
+	aead, err := newAEAD(passphrase, f.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	data, err := aead.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if err := json.Unmarshal(data, &s.values); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Get returns the secret of the given name, and whether there is one.
+func (s *Store) Get(name string) (string, bool) {
+	v, ok := s.values[name]
+	return v, ok
+}
+
+// Set sets a secret, which is kept once the store is saved.
+func (s *Store) Set(name, value string) {
+	s.values[name] = value
+}
+
+// Delete removes a secret, reporting whether there was one.
+func (s *Store) Delete(name string) bool {
+	_, ok := s.values[name]
+	delete(s.values, name)
+	return ok
+}
+
+// Names returns the names of every secret in alphabetical order.
+func (s *Store) Names() []string {
+	var res []string
+	for name := range s.values {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Save encrypts the secrets with a new salt and nonce, replacing the file.
+func (s *Store) Save() error {
+	f := file{Version: fileVersion, Salt: make([]byte, saltSize)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(s.passphrase, f.Salt)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+	f.Data = aead.Seal(nil, f.Nonce, data, nil)
+	bs, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write a temporary file first so that a failure can't lose the secrets
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(bs, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// newAEAD returns AES-256-GCM keyed with the passphrase.
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/apiclient"
+	"github.com/j18e/sbanken-client/pkg/models"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// stubAPI serves /api/v1 with the given handlers in place of the real ones,
+// which need a database, while checking requests and responses against the
+// spec the way the real API does.
+func stubAPI(t *testing.T, handlers map[string]gin.HandlerFunc) *httptest.Server {
+	t.Helper()
+	spec, err := loadSpec("../../" + specFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.UseRawPath = true
+	r.UnescapePathValues = true
+	s := &Server{router: r, spec: spec}
+	v1 := r.Group(v1Prefix, s.apiErrors(), func(c *gin.Context) {
+		c.Set(userKey, &models.User{Username: "alice", Role: models.RoleAdmin})
+	}, s.validateRequest())
+	for route, h := range handlers {
+		parts := strings.SplitN(route, " ", 2)
+		v1.Handle(parts[0], parts[1], h)
+	}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func reply(status int, body interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if msg, ok := body.(string); ok {
+			c.String(status, msg)
+			return
+		}
+		c.JSON(status, body)
+	}
+}
+
+func TestAPIClient(t *testing.T) {
+	p1 := &models.Purchase{ID: "1", Date: models.Date{Year: 2020, Month: 3, Day: 14}, NOK: 420,
+		Account: "main", Category: "groceries", Location: "Oslo", Vendor: "Rema 1000", RawVendor: "REMA 1000 TORSHOV",
+		Tags: []string{"food"}}
+	p2 := &models.Purchase{ID: "2", Date: models.Date{Year: 2020, Month: 3, Day: 2}, NOK: 99,
+		Account: "main", Category: "groceries", Location: "Oslo", Vendor: "Kiwi", RawVendor: "KIWI 512"}
+	household := 4
+	finished := time.Date(2020, 3, 14, 12, 0, 5, 0, time.UTC)
+	run := &models.SyncRun{ID: 3, Trigger: models.SyncManual, Started: finished.Add(-5 * time.Second),
+		Finished: &finished, Accounts: []*models.AccountSync{{Account: "main", Fetched: 2, Added: 1, New: []string{"1"}}}}
+
+	srv := stubAPI(t, map[string]gin.HandlerFunc{
+		"GET /purchases": func(c *gin.Context) {
+			if c.Query("category") != "groceries" || c.Query("min") != "50" || c.Query("sort") != "-nok" {
+				c.String(http.StatusTeapot, "unexpected query %s", c.Request.URL.RawQuery)
+				return
+			}
+			if c.Query("cursor") == "" {
+				c.JSON(http.StatusOK, models.PurchasePage{Purchases: []*models.Purchase{p1}, Total: 2,
+					NextCursor: "next"})
+				return
+			}
+			c.JSON(http.StatusOK, models.PurchasePage{Purchases: []*models.Purchase{p2}, Total: 2})
+		},
+		"GET /purchases/:purchase": func(c *gin.Context) {
+			if c.Param("purchase") != p1.ID {
+				c.String(http.StatusNotFound, "purchase not found")
+				return
+			}
+			c.JSON(http.StatusOK, p1)
+		},
+		"PUT /purchases/:purchase/tags/:tag": reply(http.StatusOK, "tag added"),
+		"PUT /purchases/:purchase/splits":    reply(http.StatusOK, "splits updated"),
+		"GET /search": reply(http.StatusOK, []*models.SearchResult{
+			{Purchase: p1, Rank: 0.6, Highlights: map[string]string{"vendor": "<mark>Rema</mark> 1000"}},
+		}),
+		"GET /totals": reply(http.StatusOK, []*models.Total{
+			{Group: map[string]string{"category": "groceries"}, NOK: 519, Count: 2},
+		}),
+		"POST /settlements": func(c *gin.Context) {
+			var st models.Settlement
+			if err := c.ShouldBindJSON(&st); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			st.ID, st.Date = 8, models.Date{Year: 2020, Month: 3, Day: 15}
+			c.JSON(http.StatusCreated, st)
+		},
+		"PUT /account-payers": reply(http.StatusOK, models.AccountPayer{Account: "main", Person: "alice"}),
+		"GET /sync/:run":      reply(http.StatusOK, run),
+		"POST /webhooks": reply(http.StatusCreated, models.Webhook{ID: 2, URL: "https://example.com/hook",
+			Secret: "s3cret", Events: []string{"purchase.created"}, Active: true, Created: finished}),
+	})
+	client := apiclient.New(srv.URL, "token")
+	ctx := context.Background()
+
+	// the spec is only enforced on requests, mismatching responses are logged
+	hook := logtest.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	for _, tc := range []struct {
+		name string
+		call func() (interface{}, error)
+		want interface{}
+	}{
+		{"all purchases", func() (interface{}, error) {
+			min := 50
+			return client.AllPurchases(ctx, apiclient.Query{
+				Filter: apiclient.Filter{From: models.Date{Year: 2020, Month: 3, Day: 1}, Category: "groceries", Min: &min},
+				Sort:   "-nok",
+				Limit:  1,
+			})
+		}, []*models.Purchase{p1, p2}},
+		{"purchase", func() (interface{}, error) {
+			return client.Purchase(ctx, p1.ID)
+		}, p1},
+		{"add tag", func() (interface{}, error) {
+			return nil, client.AddTag(ctx, p1.ID, "to share")
+		}, nil},
+		{"split", func() (interface{}, error) {
+			return nil, client.SetSplits(ctx, p1.ID, []*models.Split{{Category: "groceries", NOK: 400},
+				{Category: "household", NOK: 20}})
+		}, nil},
+		{"search", func() (interface{}, error) {
+			res, err := client.Search(ctx, "rema", apiclient.Filter{Account: "main"}, 5)
+			if err != nil {
+				return nil, err
+			}
+			return res[0].Highlights["vendor"], nil
+		}, "<mark>Rema</mark> 1000"},
+		{"totals", func() (interface{}, error) {
+			res, err := client.Totals(ctx, apiclient.Filter{Tag: "food"}, "category")
+			if err != nil {
+				return nil, err
+			}
+			return res[0].NOK, nil
+		}, 519},
+		{"add settlement", func() (interface{}, error) {
+			st := &models.Settlement{From: "bob", To: "alice", NOK: 200, Household: &household}
+			err := client.AddSettlement(ctx, st)
+			return st, err
+		}, &models.Settlement{ID: 8, Date: models.Date{Year: 2020, Month: 3, Day: 15}, From: "bob", To: "alice",
+			NOK: 200, Household: &household}},
+		{"set account payer", func() (interface{}, error) {
+			return nil, client.SetAccountPayer(ctx, "main", "alice")
+		}, nil},
+		{"sync run", func() (interface{}, error) {
+			res, err := client.SyncRun(ctx, run.ID)
+			if err != nil {
+				return nil, err
+			}
+			return res.Accounts[0].New, nil
+		}, []string{"1"}},
+		{"add webhook", func() (interface{}, error) {
+			w := &models.Webhook{URL: "https://example.com/hook", Events: []string{"purchase.created"}, Active: true}
+			err := client.AddWebhook(ctx, w)
+			return w.Secret, err
+		}, "s3cret"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hook.Reset()
+			got, err := tc.call()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+			for _, e := range hook.AllEntries() {
+				if e.Level <= log.WarnLevel {
+					t.Error(e.Message)
+				}
+			}
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := client.Purchase(ctx, "nope"); !apiclient.IsNotFound(err) {
+			t.Errorf("got error %v, want a 404", err)
+		}
+	})
+	t.Run("invalid request", func(t *testing.T) {
+		// the spec requires a positive amount
+		err := client.AddSettlement(ctx, &models.Settlement{From: "bob", To: "alice", NOK: -1})
+		if e, ok := err.(*apiclient.Error); !ok || e.Status != http.StatusBadRequest {
+			t.Errorf("got error %v, want a 400", err)
+		}
+	})
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/auth"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sessionCookie   = "sbanken_session"
+	csrfCookie      = "sbanken_csrf"
+	loginCSRFCookie = "sbanken_login_csrf"
+	oidcStateCookie = "sbanken_oidc_state"
+	csrfHeader      = "X-CSRF-Token"
+	userKey         = "user"
+)
+
+// requireUser rejects requests that don't come from a logged in user. Pages
+// redirect to the login page while the API answers 401. Requests made with a
+// session cookie rather than an API token must carry the session's CSRF token
+// unless they only read.
+func (s *Server) requireUser(api bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
+			username, err := s.auth.TokenUser(strings.TrimPrefix(h, "Bearer "))
+			if err != nil {
+				s.rejectUser(c, api, err)
+				return
+			}
+			s.setUser(c, api, username)
+			return
+		}
+
+		token, _ := c.Cookie(sessionCookie)
+		sess, err := s.auth.Session(token)
+		if err != nil {
+			s.rejectUser(c, api, err)
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			got := c.GetHeader(csrfHeader)
+			if got == "" {
+				got = c.PostForm("csrf_token")
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(sess.CSRFToken)) != 1 {
+				c.String(http.StatusForbidden, "missing or invalid csrf token")
+				c.Abort()
+				return
+			}
+		}
+		s.setUser(c, api, sess.Username)
+	}
+}
+
+// setUser makes the user available to the handlers through currentUser.
+func (s *Server) setUser(c *gin.Context, api bool, username string) {
+	u, err := s.Storage.GetUser(username)
+	if err == storage.ErrNotFound {
+		s.rejectUser(c, api, auth.ErrInvalidCredentials)
+		return
+	} else if err != nil {
+		s.rejectUser(c, api, err)
+		return
+	}
+	c.Set(userKey, u)
+}
+
+// currentUser returns the user who made a request which passed requireUser.
+func currentUser(c *gin.Context) *models.User {
+	return c.MustGet(userKey).(*models.User)
+}
+
+// access returns what the user who made the request may see.
+func access(c *gin.Context) *storage.Access {
+	u := currentUser(c)
+	return &storage.Access{Username: u.Username, Admin: u.Can(models.RoleAdmin)}
+}
+
+// requireRole rejects requests from users whose role doesn't grant the given
+// role.
+func (s *Server) requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !currentUser(c).Can(role) {
+			c.String(http.StatusForbidden, "this requires the %s role", role)
+			c.Abort()
+		}
+	}
+}
+
+// visiblePurchase answers 404 for purchases the user may not see, so that
+// handlers of the :purchase routes don't have to check.
+func (s *Server) visiblePurchase() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := s.Storage.PurchaseVisible(c.Param("purchase"), access(c))
+		if err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "purchase not found")
+			c.Abort()
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func (s *Server) rejectUser(c *gin.Context, api bool, err error) {
+	if err != auth.ErrInvalidCredentials {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if api {
+		c.String(http.StatusUnauthorized, "not logged in")
+		c.Abort()
+		return
+	}
+	c.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+	c.Abort()
+}
+
+// setSession hands the browser the cookies of a new session. The CSRF
+// cookie is readable by scripts so that they can echo it in a header.
+func (s *Server) setSession(c *gin.Context, token, csrf string, expires time.Time) {
+	maxAge := int(time.Until(expires).Seconds())
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", s.auth.SecureCookies, true)
+	c.SetCookie(csrfCookie, csrf, maxAge, "/", "", s.auth.SecureCookies, false)
+}
+
+func (s *Server) clearSession(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, "", -1, "/", "", s.auth.SecureCookies, true)
+	c.SetCookie(csrfCookie, "", -1, "/", "", s.auth.SecureCookies, false)
+}
+
+// nextPage returns where to go after logging in, which has to be on this
+// site. Browsers ignore tabs and newlines in URLs and treat backslashes like
+// slashes, so anything containing them is rejected too.
+func nextPage(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(next, "/") ||
+		strings.HasPrefix(next, "//") || strings.ContainsAny(next, "\\\t\r\n") {
+		return "/"
+	}
+	return next
+}
+
+// loginCSRF returns the token the login form must be posted with, handing
+// the browser a cookie holding it unless it already has one.
+func (s *Server) loginCSRF(c *gin.Context) (string, error) {
+	if token, _ := c.Cookie(loginCSRFCookie); token != "" {
+		return token, nil
+	}
+	token, err := s.auth.LoginCSRFToken()
+	if err != nil {
+		return "", err
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(loginCSRFCookie, token, 3600, "/login", "", s.auth.SecureCookies, true)
+	return token, nil
+}
+
+func (s *Server) handlerLoginPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		csrf, err := s.loginCSRF(c)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		c.HTML(http.StatusOK, "login.html", gin.H{
+			"title":     "Log in",
+			"anonymous": true,
+			"next":      nextPage(c.Query("next")),
+			"oidc":      s.auth.OIDCEnabled(),
+			"csrf":      csrf,
+		})
+	}
+}
+
+func (s *Server) handlerLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// the form must come from the login page rather than another site
+		// logging the user in to an account of its own
+		csrf, _ := c.Cookie(loginCSRFCookie)
+		if csrf == "" || subtle.ConstantTimeCompare([]byte(c.PostForm("csrf_token")), []byte(csrf)) != 1 {
+			c.String(http.StatusForbidden, "missing or invalid csrf token - please reload the login page")
+			return
+		}
+
+		next := nextPage(c.PostForm("next"))
+		token, sess, err := s.auth.Login(c.PostForm("username"), c.PostForm("password"))
+		if err == auth.ErrInvalidCredentials {
+			log.Warnf("failed login for user %q from %s", c.PostForm("username"), c.ClientIP())
+			c.HTML(http.StatusUnauthorized, "login.html", gin.H{
+				"title":     "Log in",
+				"anonymous": true,
+				"next":      next,
+				"oidc":      s.auth.OIDCEnabled(),
+				"csrf":      csrf,
+				"username":  c.PostForm("username"),
+				"error":     "Wrong username or password.",
+			})
+			return
+		} else if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		c.SetCookie(loginCSRFCookie, "", -1, "/login", "", s.auth.SecureCookies, true)
+		s.setSession(c, token, sess.CSRFToken, sess.Expires)
+		c.Redirect(http.StatusSeeOther, next)
+	}
+}
+
+func (s *Server) handlerLogout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, _ := c.Cookie(sessionCookie)
+		if err := s.auth.Logout(token); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		s.clearSession(c)
+		c.Redirect(http.StatusSeeOther, "/login")
+	}
+}
+
+// The modes of the single sign-on flow, kept in the state cookie along with
+// the state, nonce and the page to go to once done.
+const (
+	oidcModeLogin = "login"
+	oidcModeLink  = "link"
+)
+
+func (s *Server) handlerOIDCLogin() gin.HandlerFunc {
+	return s.startOIDC(oidcModeLogin)
+}
+
+// handlerOIDCLink sends a logged in user to the provider to link their
+// identity there to their account.
+func (s *Server) handlerOIDCLink() gin.HandlerFunc {
+	return s.startOIDC(oidcModeLink)
+}
+
+func (s *Server) startOIDC(mode string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.auth.OIDCEnabled() {
+			c.String(http.StatusNotFound, "single sign-on is not configured")
+			return
+		}
+		state, nonce, err := s.auth.OIDCState()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		// remember where to go once the provider sends the user back
+		cookie := strings.Join([]string{state, nonce, mode, nextPage(c.Query("next"))}, "|")
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(oidcStateCookie, cookie, 600, "/", "", s.auth.SecureCookies, true)
+		c.Redirect(http.StatusFound, s.auth.OIDCAuthURL(state, nonce))
+	}
+}
+
+func (s *Server) handlerOIDCCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.auth.OIDCEnabled() {
+			c.String(http.StatusNotFound, "single sign-on is not configured")
+			return
+		}
+		cookie, _ := c.Cookie(oidcStateCookie)
+		c.SetCookie(oidcStateCookie, "", -1, "/", "", s.auth.SecureCookies, true)
+		parts := strings.SplitN(cookie, "|", 4)
+		if len(parts) != 4 || parts[0] == "" || c.Query("state") != parts[0] {
+			c.String(http.StatusBadRequest, "invalid login state, please try again")
+			return
+		}
+		nonce, mode, next := parts[1], parts[2], nextPage(parts[3])
+		if msg := c.Query("error"); msg != "" {
+			c.String(http.StatusUnauthorized, "login failed: %s", msg)
+			return
+		}
+
+		if mode == oidcModeLink {
+			// the identity goes to whoever holds the session now rather than
+			// anyone named in the cookie
+			token, _ := c.Cookie(sessionCookie)
+			sess, err := s.auth.Session(token)
+			if err != nil {
+				c.String(http.StatusUnauthorized, "log in with your password before linking single sign-on")
+				return
+			}
+			err = s.auth.OIDCLink(c.Request.Context(), c.Query("code"), nonce, sess.Username)
+			if err == auth.ErrInvalidCredentials {
+				c.String(http.StatusConflict, "this single sign-on identity is linked to another user")
+				return
+			} else if err != nil {
+				c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+				return
+			}
+			log.Infof("linked user %s to single sign-on", sess.Username)
+			c.Redirect(http.StatusSeeOther, next)
+			return
+		}
+
+		token, sess, err := s.auth.OIDCLogin(c.Request.Context(), c.Query("code"), nonce)
+		if err == auth.ErrInvalidCredentials {
+			c.String(http.StatusForbidden, "this user has no access")
+			return
+		} else if err == auth.ErrNotLinked {
+			c.String(http.StatusForbidden, "%v - log in with your password and go to /login/oidc/link", err)
+			return
+		} else if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		s.setSession(c, token, sess.CSRFToken, sess.Expires)
+		c.Redirect(http.StatusSeeOther, next)
+	}
+}
+
+func (s *Server) handlerAPITokens() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokens, err := s.Storage.APITokens(currentUser(c).Username)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+func (s *Server) handlerAPITokenCreate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if body.Name == "" {
+			c.String(http.StatusBadRequest, "name is required")
+			return
+		}
+		tok, err := s.auth.CreateToken(currentUser(c).Username, body.Name)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusCreated, tok)
+	}
+}
+
+func (s *Server) handlerAPITokenDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("token"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid token id")
+			return
+		}
+		err = s.Storage.DeleteAPIToken(currentUser(c).Username, id)
+		if err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "token %d not found", id)
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
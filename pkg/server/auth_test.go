@@ -0,0 +1,216 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/auth"
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// sessionStore only keeps sessions, which is all requireUser looks at before
+// the user is loaded from storage.
+type sessionStore struct {
+	auth.Store
+	sessions map[string]*models.Session
+	logins   int
+}
+
+func (m *sessionStore) AddSession(tokenHash string, sess *models.Session) error {
+	m.sessions[tokenHash] = sess
+	return nil
+}
+
+func (m *sessionStore) GetSession(tokenHash string) (*models.Session, error) {
+	sess, ok := m.sessions[tokenHash]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return sess, nil
+}
+
+func (m *sessionStore) APITokenUser(tokenHash string) (string, error) {
+	return "", storage.ErrNotFound
+}
+
+func (m *sessionStore) PasswordHash(username string) (string, error) {
+	m.logins++
+	return "", storage.ErrNotFound
+}
+
+// newTestServer returns a server whose sessions last for the given time,
+// along with the token and session of a user who is logged in.
+func newTestServer(t *testing.T, ttl time.Duration) (*Server, *sessionStore, string, *models.Session) {
+	t.Helper()
+	store := &sessionStore{sessions: make(map[string]*models.Session)}
+	a, err := auth.NewAuth(config.Auth{SessionTTL: ttl}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, sess, err := a.StartSession("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{auth: a}, store, token, sess
+}
+
+func serve(r *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestNextPage(t *testing.T) {
+	for _, tc := range []struct{ next, want string }{
+		{"/spending/2020/1", "/spending/2020/1"},
+		{"/search?q=rema%201000", "/search?q=rema%201000"},
+		{"", "/"},
+		{"spending", "/"},
+		{"https://evil.example", "/"},
+		{"//evil.example", "/"},
+		{"/\\evil.example", "/"},
+		{"/\t/evil.example", "/"},
+		{"/\n/evil.example", "/"},
+		{"javascript:alert(1)", "/"},
+	} {
+		if got := nextPage(tc.next); got != tc.want {
+			t.Errorf("nextPage(%q) = %q, want %q", tc.next, got, tc.want)
+		}
+	}
+}
+
+func TestRequireUserCSRF(t *testing.T) {
+	s, _, token, sess := newTestServer(t, time.Hour)
+	r := gin.New()
+	r.POST("/api/purchases", s.requireUser(true), func(c *gin.Context) {
+		t.Error("the request reached the handler")
+	})
+
+	for _, tc := range []struct {
+		name, header, form string
+	}{
+		{"no token", "", ""},
+		{"wrong header", "x" + sess.CSRFToken, ""},
+		{"wrong form field", "", "x" + sess.CSRFToken},
+		{"session token as csrf token", token, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{}
+			if tc.form != "" {
+				form.Set("csrf_token", tc.form)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/purchases", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+			if tc.header != "" {
+				req.Header.Set(csrfHeader, tc.header)
+			}
+			if w := serve(r, req); w.Code != http.StatusForbidden {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestRequireUserRejected(t *testing.T) {
+	s, _, token, sess := newTestServer(t, -time.Minute)
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		t.Error("the request reached the handler")
+	}
+	r.GET("/api/purchases", s.requireUser(true), handler)
+	r.GET("/spending", s.requireUser(false), handler)
+
+	for _, tc := range []struct {
+		name, path, bearer, location string
+		status                       int
+	}{
+		{"expired session, api", "/api/purchases", "", "", http.StatusUnauthorized},
+		{"expired session, page", "/spending?tag=food", "", "/login?next=%2Fspending%3Ftag%3Dfood", http.StatusFound},
+		{"unknown api token", "/api/purchases", "nope", "", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+			req.Header.Set(csrfHeader, sess.CSRFToken)
+			if tc.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.bearer)
+			}
+			w := serve(r, req)
+			if w.Code != tc.status {
+				t.Errorf("got status %d, want %d", w.Code, tc.status)
+			}
+			if got := w.Header().Get("Location"); got != tc.location {
+				t.Errorf("redirected to %q, want %q", got, tc.location)
+			}
+		})
+	}
+}
+
+func TestLoginCSRF(t *testing.T) {
+	s, store, _, _ := newTestServer(t, time.Hour)
+	r := gin.New()
+	r.POST("/login", s.handlerLogin())
+
+	for _, tc := range []struct {
+		name, cookie, form string
+	}{
+		{"no token", "", ""},
+		{"no cookie", "", "abc"},
+		{"no form field", "abc", ""},
+		{"different tokens", "abc", "abd"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{"username": {"alice"}, "password": {"hunter22"}}
+			if tc.form != "" {
+				form.Set("csrf_token", tc.form)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: loginCSRFCookie, Value: tc.cookie})
+			}
+			if w := serve(r, req); w.Code != http.StatusForbidden {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+	if store.logins > 0 {
+		t.Errorf("the password was checked %d times without a valid csrf token", store.logins)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	s := &Server{}
+	for _, tc := range []struct {
+		role, required string
+		status         int
+	}{
+		{models.RoleViewer, models.RoleViewer, http.StatusOK},
+		{models.RoleViewer, models.RoleEditor, http.StatusForbidden},
+		{models.RoleEditor, models.RoleAdmin, http.StatusForbidden},
+		{models.RoleAdmin, models.RoleEditor, http.StatusOK},
+		{"", models.RoleViewer, http.StatusForbidden},
+	} {
+		r := gin.New()
+		r.GET("/", func(c *gin.Context) {
+			c.Set(userKey, &models.User{Username: "alice", Role: tc.role})
+		}, s.requireRole(tc.required), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		if w := serve(r, httptest.NewRequest(http.MethodGet, "/", nil)); w.Code != tc.status {
+			t.Errorf("%q requiring %q: got status %d, want %d", tc.role, tc.required, w.Code, tc.status)
+		}
+	}
+}
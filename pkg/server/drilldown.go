@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// drilldown is everything known about the purchases from one vendor or in
+// one category.
+type drilldown struct {
+	Stats  *models.Stats        `json:"stats"`
+	Months []*models.Total      `json:"months"`
+	Page   *models.PurchasePage `json:"purchases"`
+}
+
+// drilldownFilter returns a filter matching the purchases visible through the
+// access where field, which is either vendor or category, has the given
+// value.
+func drilldownFilter(field, value string, access *storage.Access) storage.Filter {
+	if field == "vendor" {
+		return storage.Filter{Vendor: value, Access: access}
+	}
+	return storage.Filter{Category: value, Access: access}
+}
+
+func (s *Server) drilldown(filter storage.Filter, cursor string) (*drilldown, error) {
+	var res drilldown
+	var err error
+	if res.Stats, err = s.Storage.Stats(filter); err != nil {
+		return nil, err
+	}
+	if res.Months, err = s.Storage.Totals(filter, "month"); err != nil {
+		return nil, err
+	}
+	if res.Page, err = s.Storage.QueryPurchases(storage.Query{Filter: filter, Cursor: cursor}); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// handlerDrilldown shows the history of a vendor or category, as given by
+// field.
+func (s *Server) handlerDrilldown(field string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param(field)
+		dd, err := s.drilldown(drilldownFilter(field, name, access(c)), "")
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		if dd.Stats.Count < 1 {
+			c.String(http.StatusNotFound, "no purchases found for %s %s", field, name)
+			return
+		}
+
+		var labels []string
+		var values []int
+		for _, t := range dd.Months {
+			labels = append(labels, t.Group["month"])
+			values = append(values, t.NOK)
+		}
+
+		c.HTML(http.StatusOK, "drilldown.html", gin.H{
+			"title":       fmt.Sprintf("Spending %s %s", map[string]string{"vendor": "at", "category": "on"}[field], name),
+			"field":       field,
+			"name":        name,
+			"stats":       dd.Stats,
+			"payload":     dd.Page.Purchases,
+			"total":       dd.Page.Total,
+			"chartLabels": labels,
+			"chartSeries": []gin.H{{"name": name, "values": values}},
+			"user":        currentUser(c),
+		})
+	}
+}
+
+func (s *Server) handlerAPIDrilldown(field string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dd, err := s.drilldown(drilldownFilter(field, c.Param(field), access(c)), c.Query("cursor"))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if dd.Stats.Count < 1 {
+			c.String(http.StatusNotFound, "%s not found", field)
+			return
+		}
+		c.JSON(http.StatusOK, dd)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+const (
+	// keepAliveInterval is how often a comment is sent on an idle event
+	// stream, so that proxies don't close it.
+	keepAliveInterval = 30 * time.Second
+
+	// totalsDelay gathers changes arriving together, like the purchases of a
+	// sync, before sending the new totals.
+	totalsDelay = time.Second
+)
+
+// handlerAPIEvents streams what happens as Server-Sent Events: changes to
+// purchases on the accounts the user can see, followed by the new totals of
+// the months they were in, and finished syncs listing the accounts the user
+// can see. The data of each event is the
+// event as sent to webhooks.
+func (s *Server) handlerAPIEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		evs, unsubscribe := s.events.Subscribe()
+		defer unsubscribe()
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		// the months to send totals of once the changes stop coming
+		months := make(map[string]models.Date)
+		// whether the user can see each account seen so far, worked out
+		// again once who can see what changes
+		visible := make(map[string]bool)
+		var sendTotals <-chan time.Time
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		// keep nginx from buffering the stream
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-keepAlive.C:
+				io.WriteString(w, ": keep-alive\n\n")
+			case <-sendTotals:
+				sendTotals = nil
+				for key, month := range months {
+					filter := storage.MonthFilter(month)
+					filter.Access = access(c)
+					total, err := s.Storage.Total(filter)
+					if err != nil {
+						c.Error(err)
+						return false
+					}
+					c.SSEvent("totals", gin.H{"month": key, "nok": total})
+				}
+				months = make(map[string]models.Date)
+			case e := <-evs:
+				switch e.Type {
+				case events.AccessChanged:
+					visible = make(map[string]bool)
+				case events.PurchaseCreated, events.PurchaseUpdated, events.PurchaseDeleted:
+					account := e.Purchase.Account
+					if _, ok := visible[account]; !ok {
+						res, err := s.Storage.VisibleAccounts([]string{account}, access(c))
+						if err != nil {
+							c.Error(err)
+							return false
+						}
+						visible[account] = res[account]
+					}
+					if !visible[account] {
+						return true
+					}
+					c.SSEvent(e.Type, e)
+					month := e.Purchase.Date
+					months[fmt.Sprintf("%04d-%02d", month.Year, int(month.Month))] = month
+					if sendTotals == nil {
+						sendTotals = time.After(totalsDelay)
+					}
+				case events.SyncFinished, events.SyncFailed:
+					if e.Sync != nil {
+						runs, err := s.visibleSyncs(access(c), e.Sync)
+						if err != nil {
+							c.Error(err)
+							return false
+						}
+						e.Sync = runs[0]
+					}
+					c.SSEvent(e.Type, e)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// handlerPurchaseRow renders the row of a purchase in the spending table,
+// for replacing it when it changes.
+func (s *Server) handlerPurchaseRow() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := s.Storage.GetPurchase(c.Param("purchase"))
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		c.HTML(http.StatusOK, "purchase_row.html", gin.H{
+			"purchase": p,
+			"editor":   currentUser(c).Can(models.RoleEditor),
+		})
+	}
+}
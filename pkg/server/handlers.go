@@ -1,13 +1,20 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/export"
+	"github.com/j18e/sbanken-client/pkg/forecast"
 	"github.com/j18e/sbanken-client/pkg/models"
 	"github.com/j18e/sbanken-client/pkg/storage"
+	log "github.com/sirupsen/logrus"
 )
 
 func (s *Server) handlerHome() gin.HandlerFunc {
@@ -30,24 +37,163 @@ func (s *Server) handlerSpendingMonth() gin.HandlerFunc {
 		}
 
 		month := models.Date{Year: path.Year, Month: path.Month, MonthNum: int(path.Month)}
-		purchases, err := s.Storage.GetPurchases(month)
+		filter := storage.MonthFilter(month)
+		filter.Access = access(c)
+		tags, err := s.Storage.Totals(filter, "tag")
 		if err != nil {
 			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
 			return
 		}
 
-		var total int
-		for _, p := range purchases {
-			total += p.NOK
+		filter.Tag = c.Query("tag")
+		purchases, err := s.Storage.Purchases(filter)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+
+		total, err := s.Storage.Total(filter)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+
+		// only months which aren't over yet have anything to forecast
+		var fc *models.Forecast
+		if !storage.MonthFilter(month).To.Time().Before(models.DateToday().Time()) {
+			if fc, err = s.forecast(month, access(c)); err != nil {
+				c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+				return
+			}
 		}
 
 		c.HTML(http.StatusOK, "spending.html", gin.H{
 			"title":     fmt.Sprintf("Spending in %s", month),
 			"payload":   purchases,
 			"month":     month,
+			"year":      month.Year,
 			"prevMonth": month.SubMonth(),
 			"nextMonth": month.AddMonth(),
 			"total":     total,
+			"tags":      tags,
+			"tag":       filter.Tag,
+			"forecast":  fc,
+			"user":      currentUser(c),
+			"editor":    currentUser(c).Can(models.RoleEditor),
+		})
+	}
+}
+
+func (s *Server) handlerSpendingYear() gin.HandlerFunc {
+	type PathArgs struct {
+		Year int `binding:"required" uri:"year"`
+	}
+	type categoryRow struct {
+		Name   string
+		Months [12]int
+		Total  int
+	}
+	return func(c *gin.Context) {
+		var path PathArgs
+		if err := c.ShouldBindUri(&path); err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		yearFilter := storage.Filter{
+			From:   models.Date{Year: path.Year, Month: time.January, MonthNum: 1, Day: 1},
+			To:     models.Date{Year: path.Year, Month: time.December, MonthNum: 12, Day: 31},
+			Access: access(c),
+		}
+		totals, err := s.Storage.Totals(yearFilter, "category", "month")
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+
+		// arrange the totals in a category by month table
+		var months [12]int
+		var total int
+		var categories []*categoryRow
+		rows := make(map[string]*categoryRow)
+		for _, t := range totals {
+			month, err := models.ParseDate(t.Group["month"] + "-01")
+			if err != nil {
+				c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+				return
+			}
+			row, ok := rows[t.Group["category"]]
+			if !ok {
+				row = &categoryRow{Name: t.Group["category"]}
+				rows[row.Name] = row
+				categories = append(categories, row)
+			}
+			row.Months[month.Month-1] += t.NOK
+			row.Total += t.NOK
+			months[month.Month-1] += t.NOK
+			total += t.NOK
+		}
+
+		// compare spending so far this year with the same period last year
+		today := models.DateToday()
+		elapsed := 12
+		ytdFilter := yearFilter
+		if path.Year == today.Year {
+			elapsed = int(today.Month)
+			ytdFilter.To = today
+		} else if path.Year > today.Year {
+			elapsed = 0
+		}
+		lastYearFilter := ytdFilter
+		lastYearFilter.From.Year--
+		lastYearFilter.To = models.DateFromTime(ytdFilter.To.Time().AddDate(-1, 0, 0))
+		ytd, err := s.Storage.Total(ytdFilter)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		lastYTD, err := s.Storage.Total(lastYearFilter)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		average := 0
+		if elapsed > 0 {
+			average = total / elapsed
+		}
+
+		// chart series are stacked per category
+		var monthLinks []models.Date
+		var labels []string
+		for m := time.January; m <= time.December; m++ {
+			monthLinks = append(monthLinks, models.Date{Year: path.Year, Month: m, MonthNum: int(m)})
+			labels = append(labels, m.String()[:3])
+		}
+		type series struct {
+			Name   string `json:"name"`
+			Values []int  `json:"values"`
+		}
+		chart := []series{}
+		for _, row := range categories {
+			chart = append(chart, series{Name: row.Name, Values: row.Months[:]})
+		}
+
+		c.HTML(http.StatusOK, "spending_year.html", gin.H{
+			"title":       fmt.Sprintf("Spending in %d", path.Year),
+			"year":        path.Year,
+			"prevYear":    path.Year - 1,
+			"nextYear":    path.Year + 1,
+			"months":      monthLinks,
+			"monthTotals": months,
+			"categories":  categories,
+			"total":       total,
+			"average":     average,
+			"ytd":         ytd,
+			"lastYTD":     lastYTD,
+			"ytdTo":       ytdFilter.To,
+			"chartLabels": labels,
+			"chartSeries": chart,
+			"user":        currentUser(c),
 		})
 	}
 }
@@ -62,11 +208,13 @@ func (s *Server) handlerAPIPurchases() gin.HandlerFunc {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
-		p, err := s.Storage.GetPurchases(models.Date{
+		filter := storage.MonthFilter(models.Date{
 			Year:     params.Year,
 			Month:    time.Month(params.Month),
 			MonthNum: params.Month,
 		})
+		filter.Access = access(c)
+		p, err := s.Storage.Purchases(filter)
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
@@ -75,6 +223,211 @@ func (s *Server) handlerAPIPurchases() gin.HandlerFunc {
 	}
 }
 
+func (s *Server) handlerAPIQueryPurchases() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params struct {
+			filterParams
+			Sort   string `form:"sort"`
+			Limit  int    `form:"limit"`
+			Cursor string `form:"cursor"`
+		}
+		if err := c.ShouldBindQuery(&params); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		filter, err := params.filter(access(c))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		page, err := s.Storage.QueryPurchases(storage.Query{
+			Filter: filter,
+			Sort:   params.Sort,
+			Limit:  params.Limit,
+			Cursor: params.Cursor,
+		})
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func (s *Server) handlerAPITotals() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params struct {
+			filterParams
+			By string `form:"by"`
+		}
+		if err := c.ShouldBindQuery(&params); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		filter, err := params.filter(access(c))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var groupBy []string
+		if params.By != "" {
+			groupBy = strings.Split(params.By, ",")
+		}
+		totals, err := s.Storage.Totals(filter, groupBy...)
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, totals)
+	}
+}
+
+// dailyTotals returns the cumulative spending at the end of each day of the
+// month, up to and including the last day given.
+func (s *Server) dailyTotals(month models.Date, last int, access *storage.Access) ([]*int, error) {
+	filter := storage.MonthFilter(month)
+	filter.Access = access
+	totals, err := s.Storage.Totals(filter, "day")
+	if err != nil {
+		return nil, err
+	}
+	byDay := make(map[int]int)
+	for _, t := range totals {
+		d, err := models.ParseDate(t.Group["day"])
+		if err != nil {
+			return nil, err
+		}
+		byDay[d.Day] = t.NOK
+	}
+
+	res := make([]*int, filter.To.Day)
+	sum := 0
+	for day := 1; day <= filter.To.Day && day <= last; day++ {
+		sum += byDay[day]
+		cumulative := sum
+		res[day-1] = &cumulative
+	}
+	return res, nil
+}
+
+func (s *Server) handlerAPICharts() gin.HandlerFunc {
+	const topVendors = 10
+	return func(c *gin.Context) {
+		var params struct {
+			Year  int `uri:"year" binding:"required"`
+			Month int `uri:"month" binding:"required"`
+		}
+		if err := c.BindUri(&params); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		month := models.Date{Year: params.Year, Month: time.Month(params.Month), MonthNum: params.Month}
+		filter := storage.MonthFilter(month)
+		filter.Access = access(c)
+
+		categories, err := s.Storage.Totals(filter, "category")
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		sort.Slice(categories, func(i, j int) bool { return categories[i].NOK > categories[j].NOK })
+
+		vendors, err := s.Storage.Totals(filter, "vendor")
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		sort.Slice(vendors, func(i, j int) bool { return vendors[i].NOK > vendors[j].NOK })
+		if len(vendors) > topVendors {
+			vendors = vendors[:topVendors]
+		}
+
+		// the current month's line stops at today
+		last := filter.To.Day
+		if today := models.DateToday(); today.Year == month.Year && today.Month == month.Month {
+			last = today.Day
+		}
+		current, err := s.dailyTotals(month, last, filter.Access)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		previous, err := s.dailyTotals(month.SubMonth(), 31, filter.Access)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		monthly, err := s.budget(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var budget []int
+		if monthly > 0 {
+			for day := 1; day <= filter.To.Day; day++ {
+				budget = append(budget, monthly*day/filter.To.Day)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"categories": categories,
+			"vendors":    vendors,
+			"daily":      current,
+			"previous":   previous,
+			"budget":     budget,
+		})
+	}
+}
+
+func (s *Server) handlerAPIForecast() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params struct {
+			Year  int `uri:"year" binding:"required"`
+			Month int `uri:"month" binding:"required"`
+		}
+		if err := c.BindUri(&params); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		month := models.Date{Year: params.Year, Month: time.Month(params.Month), MonthNum: params.Month}
+		fc, err := s.forecast(month, access(c))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, fc)
+	}
+}
+
+// forecast forecasts the spending of a month with the cached recurring
+// payments.
+func (s *Server) forecast(month models.Date, a *storage.Access) (*models.Forecast, error) {
+	subs, err := s.subscriptions.get(s.Storage, a)
+	if err != nil {
+		return nil, fmt.Errorf("finding recurring payments: %w", err)
+	}
+	return forecast.Month(s.Storage, month, s.income, a, subs)
+}
+
+// budget returns the monthly budget of the user who made the request, falling
+// back to MONTHLY_BUDGET.
+func (s *Server) budget(c *gin.Context) (int, error) {
+	budget, err := s.Storage.Budget(currentUser(c).Username)
+	if err != nil || budget > 0 {
+		return budget, err
+	}
+	return s.defaultBudget, nil
+}
+
 func (s *Server) handlerAPIPurchase() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		p, err := s.Storage.GetPurchase(c.Param("purchase"))
@@ -99,7 +452,13 @@ func (s *Server) handlerAPIPurchase() gin.HandlerFunc {
 
 func (s *Server) handlerAPIPurchaseDelete() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if err := s.Storage.DeletePurchase(c.Param("purchase")); err != nil {
+		// keep the purchase to tell others what was deleted
+		p, err := s.Storage.GetPurchase(c.Param("purchase"))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if err := s.Storage.DeletePurchase(p.ID); err != nil {
 			if err == storage.ErrNotFound {
 				c.String(http.StatusNotFound, "purchase not found")
 			} else {
@@ -107,6 +466,93 @@ func (s *Server) handlerAPIPurchaseDelete() gin.HandlerFunc {
 			}
 			return
 		}
+		e := events.New(events.PurchaseDeleted)
+		e.Purchase = p
+		s.events.Publish(e)
 		c.String(http.StatusOK, "purchase deleted")
 	}
 }
+
+// filterParams are the query parameters used to narrow down purchases.
+type filterParams struct {
+	From     string `form:"from"`
+	To       string `form:"to"`
+	Category string `form:"category"`
+	Vendor   string `form:"vendor"`
+	Account  string `form:"account"`
+	Min      *int   `form:"min"`
+	Max      *int   `form:"max"`
+	Tag      string `form:"tag"`
+	Text     string `form:"q"`
+}
+
+// filter returns the filter for the parameters, limited to what the access
+// allows.
+func (fp filterParams) filter(access *storage.Access) (storage.Filter, error) {
+	f := storage.Filter{
+		Access:   access,
+		Category: fp.Category,
+		Vendor:   fp.Vendor,
+		Account:  fp.Account,
+		MinNOK:   fp.Min,
+		MaxNOK:   fp.Max,
+		Tag:      fp.Tag,
+		Text:     fp.Text,
+	}
+	var err error
+	if fp.From != "" {
+		if f.From, err = models.ParseDate(fp.From); err != nil {
+			return f, err
+		}
+	}
+	if fp.To != "" {
+		if f.To, err = models.ParseDate(fp.To); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func (s *Server) handlerAPIExport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params filterParams
+		if err := c.ShouldBindQuery(&params); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		filter, err := params.filter(access(c))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		format := c.Param("format")
+		if err := export.CheckFormat(format); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		// writers may write a header straight away, so the response headers
+		// must be set first
+		c.Header("Content-Type", export.ContentType(format))
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="purchases.%s"`, export.Extension(format)))
+		w, err := export.NewWriter(format, c.Writer, export.Options{
+			From:    filter.From,
+			To:      filter.To,
+			Account: filter.Account,
+		})
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		// the status has been sent by the time rows are being written, so
+		// errors can only be logged
+		if err := s.Storage.EachPurchase(filter, w.Write); err != nil {
+			log.Errorf("exporting purchases: %v", err)
+			return
+		}
+		if err := w.Close(); err != nil {
+			log.Errorf("exporting purchases: %v", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/metrics"
+)
+
+// checkDB pings the database, giving up after a couple of seconds.
+func (s *Server) checkDB(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return s.Storage.Ping(ctx)
+}
+
+// checkSync fails when purchases haven't been loaded from Sbanken for longer
+// than SYNC_MAX_AGE.
+func (s *Server) checkSync() error {
+	last := metrics.LastSyncSuccess()
+	if last.IsZero() {
+		return fmt.Errorf("no successful sync yet")
+	}
+	if age := time.Since(last); age > s.syncMaxAge {
+		return fmt.Errorf("last successful sync was %v ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+// requireMetricsToken rejects scrapes of /metrics which don't carry the
+// metrics token, if one is configured.
+func (s *Server) requireMetricsToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.metricsToken == "" {
+			return
+		}
+		h := c.GetHeader("Authorization")
+		if !strings.HasPrefix(h, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(h, "Bearer ")), []byte(s.metricsToken)) != 1 {
+			c.String(http.StatusUnauthorized, "missing or invalid metrics token")
+			c.Abort()
+		}
+	}
+}
+
+// handlerHealthz reports whether the server is alive, which it is as long as
+// it can reach the database.
+func (s *Server) handlerHealthz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.checkDB(c.Request.Context()); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"database": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"database": "ok"})
+	}
+}
+
+// handlerReadyz reports whether the server is serving up to date purchases.
+func (s *Server) handlerReadyz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := http.StatusOK
+		checks := gin.H{"database": "ok", "sync": "ok"}
+		if err := s.checkDB(c.Request.Context()); err != nil {
+			status = http.StatusServiceUnavailable
+			checks["database"] = err.Error()
+		}
+		if err := s.checkSync(); err != nil {
+			status = http.StatusServiceUnavailable
+			checks["sync"] = err.Error()
+		}
+		c.JSON(status, checks)
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/household"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// settleUp is the state of the household's shared expenses.
+type settleUp struct {
+	Balances    []*models.Balance    `json:"balances"`
+	Transfers   []*models.Transfer   `json:"transfers"`
+	Unassigned  []*models.Purchase   `json:"unassigned"`
+	Settlements []*models.Settlement `json:"settlements"`
+	Shared      []*models.Purchase   `json:"-"`
+}
+
+func (s *Server) settleUp(access *storage.Access) (*settleUp, error) {
+	var res settleUp
+	var err error
+	if res.Shared, err = s.Storage.Purchases(storage.Filter{Shared: true, Access: access}); err != nil {
+		return nil, err
+	}
+	if res.Settlements, err = s.Storage.Settlements(access); err != nil {
+		return nil, err
+	}
+	res.Balances, res.Unassigned = household.Balances(res.Shared, res.Settlements)
+	res.Transfers = household.SettleUp(res.Balances)
+	if res.Unassigned == nil {
+		res.Unassigned = []*models.Purchase{}
+	}
+	return &res, nil
+}
+
+func (s *Server) handlerSettleUp() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		su, err := s.settleUp(access(c))
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		payers, err := s.Storage.AccountPayers(access(c))
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		c.HTML(http.StatusOK, "settle.html", gin.H{
+			"title":   "Settle up",
+			"settle":  su,
+			"payers":  payers,
+			"payload": su.Shared,
+			"today":   models.DateToday(),
+			"user":    currentUser(c),
+		})
+	}
+}
+
+func (s *Server) handlerAPIBalances() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		su, err := s.settleUp(access(c))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, su)
+	}
+}
+
+func (s *Server) handlerAPIShares() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var shares []*models.Share
+		if c.Request.Method != http.MethodDelete {
+			if err := c.ShouldBindJSON(&shares); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		for _, sh := range shares {
+			if sh.Person == "" || sh.Weight < 1 {
+				c.String(http.StatusBadRequest, "every share needs a person and a positive weight")
+				return
+			}
+		}
+		if err := s.Storage.SetShares(c.Param("purchase"), shares); err != nil {
+			if err == storage.ErrNotFound {
+				c.String(http.StatusNotFound, "purchase not found")
+			} else {
+				c.AbortWithError(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		c.String(http.StatusOK, "shares updated")
+	}
+}
+
+func (s *Server) handlerAPIPayer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Person string `json:"person"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.Storage.SetPayer(c.Param("purchase"), body.Person); err != nil {
+			if err == storage.ErrNotFound {
+				c.String(http.StatusNotFound, "purchase not found")
+			} else {
+				c.AbortWithError(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		c.String(http.StatusOK, "payer updated")
+	}
+}
+
+func (s *Server) handlerAPIAccountPayers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payers, err := s.Storage.AccountPayers(access(c))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, payers)
+	}
+}
+
+func (s *Server) handlerAPIAccountPayerSet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ap models.AccountPayer
+		if err := c.ShouldBindJSON(&ap); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.Storage.SetAccountPayer(&ap, access(c)); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "account %s not found", ap.Account)
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, ap)
+	}
+}
+
+func (s *Server) handlerAPISettlements() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settlements, err := s.Storage.Settlements(access(c))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, settlements)
+	}
+}
+
+func (s *Server) handlerAPISettlementAdd() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Date      string `json:"date"`
+			From      string `json:"from" binding:"required"`
+			To        string `json:"to" binding:"required"`
+			NOK       int    `json:"nok" binding:"required,min=1"`
+			Household *int   `json:"household"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		st := models.Settlement{Date: models.DateToday(), From: body.From, To: body.To, NOK: body.NOK, Household: body.Household}
+		if body.Date != "" {
+			var err error
+			if st.Date, err = models.ParseDate(body.Date); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if err := s.Storage.AddSettlement(&st, access(c)); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "household %d not found", *st.Household)
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusCreated, st)
+	}
+}
+
+func (s *Server) handlerAPISettlementDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("settlement"))
+		if err != nil {
+			c.String(http.StatusNotFound, "settlement not found")
+			return
+		}
+		if err := s.Storage.DeleteSettlement(id, access(c)); err != nil {
+			if err == storage.ErrNotFound {
+				c.String(http.StatusNotFound, "settlement not found")
+			} else {
+				c.AbortWithError(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		c.String(http.StatusOK, "settlement deleted")
+	}
+}
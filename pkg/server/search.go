@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+	"html"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// searchParams are the query parameters of a search. The filter narrows it
+// down, by date for instance, with q being the words to search for.
+type searchParams struct {
+	filterParams
+	Limit int `form:"limit"`
+}
+
+// search runs the search asked for by the query parameters, answering 400 if
+// they're invalid.
+func (s *Server) search(c *gin.Context) ([]*models.SearchResult, bool) {
+	var params searchParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	filter, err := params.filter(access(c))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	filter.Text = ""
+
+	results, err := s.Storage.Search(params.Text, filter, params.Limit)
+	if errors.Is(err, storage.ErrInvalidQuery) {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, false
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return results, true
+}
+
+// handlerAPISearch searches vendors, locations, categories, notes and tags
+// for the words in q, best matches first.
+func (s *Server) handlerAPISearch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		results, ok := s.search(c)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// searchRow is a search result with every field ready to be shown,
+// highlighted where the search matched. The vendor as reported by the bank
+// is only shown if it matched.
+type searchRow struct {
+	*models.SearchResult
+	Vendor    template.HTML
+	RawVendor template.HTML
+	Location  template.HTML
+	Category  template.HTML
+	Notes     template.HTML
+	Tags      template.HTML
+}
+
+func (s *Server) handlerSearch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := c.Query("q")
+		data := gin.H{
+			"title": "Search",
+			"q":     q,
+			"user":  currentUser(c),
+		}
+		if strings.TrimSpace(q) == "" {
+			c.HTML(http.StatusOK, "search.html", data)
+			return
+		}
+
+		results, ok := s.search(c)
+		if !ok {
+			return
+		}
+		var rows []searchRow
+		for _, r := range results {
+			field := func(name, value string) template.HTML {
+				if hl, ok := r.Highlights[name]; ok {
+					// highlights are escaped already
+					return template.HTML(hl)
+				}
+				return template.HTML(html.EscapeString(value))
+			}
+			rows = append(rows, searchRow{
+				SearchResult: r,
+				Vendor:       field("vendor", r.Purchase.Vendor),
+				RawVendor:    template.HTML(r.Highlights["raw_vendor"]),
+				Location:     field("location", r.Purchase.Location),
+				Category:     field("category", r.Purchase.Category),
+				Notes:        field("notes", r.Purchase.Notes),
+				Tags:         field("tags", strings.Join(r.Purchase.Tags, ", ")),
+			})
+		}
+		data["payload"] = rows
+		c.HTML(http.StatusOK, "search.html", data)
+	}
+}
@@ -2,19 +2,34 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"html/template"
+	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/auth"
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/models"
 	"github.com/j18e/sbanken-client/pkg/storage"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/j18e/sbanken-client/pkg/webhooks"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
-func NewServer(stor *storage.Storage) *Server {
-	var conf struct {
-		Debug bool `required:"false" envconfig:"DEBUG"`
-	}
-	if err := envconfig.Process("", &conf); err != nil {
-		log.Fatal(err)
+// Syncer loads purchases from the bank on demand.
+type Syncer interface {
+	StartSync(trigger string) (int, <-chan struct{}, error)
+	SyncRuns() ([]*models.SyncRun, error)
+	SyncRun(id int) (*models.SyncRun, error)
+}
+
+func NewServer(conf config.Server, stor *storage.Storage, authn *auth.Auth, syncer Syncer, bus *events.Bus,
+	hooks *webhooks.Dispatcher) (*Server, error) {
+	spec, err := loadSpec(specFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the api spec: %w", err)
 	}
 	if conf.Debug {
 		gin.SetMode(gin.DebugMode)
@@ -23,32 +38,162 @@ func NewServer(stor *storage.Storage) *Server {
 	}
 
 	r := gin.Default()
+	// vendor names may contain slashes
+	r.UseRawPath = true
+	r.UnescapePathValues = true
 	r.Routes()
-	return &Server{Storage: stor, router: r}
+	return &Server{Storage: stor, auth: authn, syncer: syncer, events: bus, webhooks: hooks, router: r,
+		spec: spec, defaultBudget: conf.Budget, income: conf.Income, syncMaxAge: conf.SyncMaxAge,
+		metricsToken: conf.MetricsToken}, nil
 }
 
 type Server struct {
-	Storage *storage.Storage
-	router  *gin.Engine
+	Storage       *storage.Storage
+	auth          *auth.Auth
+	syncer        Syncer
+	events        *events.Bus
+	webhooks      *webhooks.Dispatcher
+	router        *gin.Engine
+	spec          *apiSpec
+	defaultBudget int
+	income        int
+	syncMaxAge    time.Duration
+	metricsToken  string
+	subscriptions subscriptionCache
 }
 
-func (s *Server) Routes() {
+// Routes registers the pages and the api, checking the versioned api against
+// its spec.
+func (s *Server) Routes() error {
+	s.router.SetFuncMap(template.FuncMap{"pathEscape": url.PathEscape, "dict": dict})
 	s.router.LoadHTMLGlob("templates/*")
 	s.router.Static("/assets", "./static")
 	s.router.StaticFile("/favicon.ico", "./static/favicon.ico")
-	s.router.GET("/", s.handlerHome())
-	s.router.GET("/spending/:year/:month", s.handlerSpendingMonth())
-
-	// api endpoints
-	s.router.GET("api/purchases/:year/:month", s.handlerAPIPurchases())
-	s.router.GET("/api/purchase/:purchase", s.handlerAPIPurchase())
-	// s.router.PUT("/api/purchase/:purchase", s.handlerPurchase())
-	s.router.DELETE("/api/purchase/:purchase", s.handlerAPIPurchaseDelete())
+
+	// monitoring
+	s.router.GET("/metrics", s.requireMetricsToken(), gin.WrapH(promhttp.Handler()))
+	s.router.GET("/healthz", s.handlerHealthz())
+	s.router.GET("/readyz", s.handlerReadyz())
+
+	// login
+	s.router.GET("/login", s.handlerLoginPage())
+	s.router.POST("/login", s.handlerLogin())
+	s.router.GET("/login/oidc", s.handlerOIDCLogin())
+	s.router.GET("/login/oidc/callback", s.handlerOIDCCallback())
+	s.router.GET("/login/oidc/link", s.requireUser(false), s.handlerOIDCLink())
+	s.router.POST("/logout", s.requireUser(false), s.handlerLogout())
+
+	// pages
+	pages := s.router.Group("/", s.requireUser(false))
+	pages.GET("/", s.handlerHome())
+	pages.GET("/spending/:year", s.handlerSpendingYear())
+	pages.GET("/spending/:year/:month", s.handlerSpendingMonth())
+	pages.GET("/vendors/:vendor", s.handlerDrilldown("vendor"))
+	pages.GET("/categories/:category", s.handlerDrilldown("category"))
+	pages.GET("/settle", s.handlerSettleUp())
+	pages.GET("/subscriptions", s.handlerSubscriptions())
+	pages.GET("/sync", s.handlerSyncRuns())
+	pages.GET("/search", s.handlerSearch())
+	pages.GET("/purchase/:purchase/row", s.visiblePurchase(), s.handlerPurchaseRow())
+
+	// api endpoints, where viewers only read, editors change purchases and
+	// admins manage who sees what
+	api := s.router.Group("/api", s.requireUser(true))
+	editor := s.requireRole(models.RoleEditor)
+	admin := s.requireRole(models.RoleAdmin)
+	api.GET("/purchases", s.handlerAPIQueryPurchases())
+	api.GET("/purchases/:year/:month", s.handlerAPIPurchases())
+	api.GET("/search", s.handlerAPISearch())
+	purchase := api.Group("/purchase/:purchase", s.visiblePurchase())
+	purchase.GET("", s.handlerAPIPurchase())
+	// purchase.PUT("", editor, s.handlerPurchase())
+	purchase.DELETE("", editor, s.handlerAPIPurchaseDelete())
+	changed := s.purchaseChanged()
+	purchase.PUT("/tags/:tag", editor, changed, s.handlerAPITagAdd())
+	purchase.DELETE("/tags/:tag", editor, changed, s.handlerAPITagRemove())
+	purchase.PUT("/notes", editor, changed, s.handlerAPINotes())
+	purchase.PUT("/splits", editor, changed, s.handlerAPISplits())
+	purchase.DELETE("/splits", editor, changed, s.handlerAPISplits())
+	purchase.PUT("/shares", editor, changed, s.handlerAPIShares())
+	purchase.DELETE("/shares", editor, changed, s.handlerAPIShares())
+	purchase.PUT("/payer", editor, changed, s.handlerAPIPayer())
+	api.GET("/export/:format", s.handlerAPIExport())
+	api.GET("/totals", s.handlerAPITotals())
+	api.GET("/charts/:year/:month", s.handlerAPICharts())
+	api.GET("/forecast/:year/:month", s.handlerAPIForecast())
+	api.GET("/vendors/:vendor", s.handlerAPIDrilldown("vendor"))
+	api.GET("/categories/:category", s.handlerAPIDrilldown("category"))
+	api.GET("/vendor-aliases", s.handlerAPIVendorAliases())
+	api.PUT("/vendor-aliases", admin, s.handlerAPIVendorAliasSet())
+	api.POST("/vendor-aliases/apply", admin, s.handlerAPIVendorAliasesApply())
+	api.DELETE("/vendor-aliases/:raw", admin, s.handlerAPIVendorAliasDelete())
+	api.GET("/tokens", s.handlerAPITokens())
+	api.POST("/tokens", s.handlerAPITokenCreate())
+	api.DELETE("/tokens/:token", s.handlerAPITokenDelete())
+	api.GET("/balances", s.handlerAPIBalances())
+	api.GET("/subscriptions", s.handlerAPISubscriptions())
+	api.GET("/account-payers", s.handlerAPIAccountPayers())
+	api.PUT("/account-payers", editor, s.handlerAPIAccountPayerSet())
+	api.GET("/settlements", s.handlerAPISettlements())
+	api.POST("/settlements", editor, s.handlerAPISettlementAdd())
+	api.DELETE("/settlements/:settlement", editor, s.handlerAPISettlementDelete())
+	api.GET("/sync", s.handlerAPISyncRuns())
+	api.POST("/sync", editor, s.handlerAPISync())
+	api.GET("/sync/:run", s.handlerAPISyncRun())
+	api.GET("/events", s.handlerAPIEvents())
+	api.GET("/me", s.handlerAPIMe())
+	api.PUT("/me", s.handlerAPIMeUpdate())
+	api.GET("/users", admin, s.handlerAPIUsers())
+	api.POST("/users", admin, s.handlerAPIUserAdd())
+	api.PUT("/users/:user", admin, s.handlerAPIUserUpdate())
+	api.DELETE("/users/:user", admin, s.handlerAPIUserDelete())
+	api.GET("/households", s.handlerAPIHouseholds())
+	api.POST("/households", admin, s.handlerAPIHouseholdAdd())
+	api.PUT("/households/:household/budget", admin, s.handlerAPIHouseholdBudget())
+	api.DELETE("/households/:household", admin, s.handlerAPIHouseholdDelete())
+	api.PUT("/households/:household/members/:user", admin, s.handlerAPIHouseholdMember())
+	api.DELETE("/households/:household/members/:user", admin, s.handlerAPIHouseholdMember())
+	api.GET("/accounts", s.handlerAPIAccounts())
+	api.PUT("/accounts/:account/owner", admin, s.handlerAPIAccountOwner())
+	api.GET("/webhooks", admin, s.handlerAPIWebhooks())
+	api.POST("/webhooks", admin, s.handlerAPIWebhookAdd())
+	api.GET("/webhooks/:webhook", admin, s.handlerAPIWebhook())
+	api.PUT("/webhooks/:webhook", admin, s.handlerAPIWebhookUpdate())
+	api.DELETE("/webhooks/:webhook", admin, s.handlerAPIWebhookDelete())
+	api.GET("/webhooks/:webhook/deliveries", admin, s.handlerAPIWebhookDeliveries())
+	api.POST("/webhooks/:webhook/ping", admin, s.handlerAPIWebhookPing())
+
+	// the versioned api, which other tools should use
+	if err := s.routesV1(); err != nil {
+		return err
+	}
+	s.router.NoRoute(s.handlerNoRoute())
+	return nil
+}
+
+// dict builds a map from pairs of keys and values, for passing several values
+// to a template.
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict needs pairs of keys and values")
+	}
+	res := make(map[string]interface{})
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings, got %v", pairs[i])
+		}
+		res[key] = pairs[i+1]
+	}
+	return res, nil
 }
 
 func (s *Server) Run(ctx context.Context) error {
 	const listenAddr = ":8000"
 	errchan := make(chan error, 1)
+	if s.events != nil {
+		go s.watchPurchases(ctx)
+	}
 	go func() {
 		log.Infof("http server listening on %s", listenAddr)
 		errchan <- s.router.Run(listenAddr)
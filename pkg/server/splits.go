@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+func (s *Server) handlerAPISplits() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var splits []*models.Split
+		if c.Request.Method != http.MethodDelete {
+			if err := c.ShouldBindJSON(&splits); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if err := s.Storage.SetSplits(c.Param("purchase"), splits); err != nil {
+			if err == storage.ErrNotFound {
+				c.String(http.StatusNotFound, "purchase not found")
+			} else if errors.Is(err, storage.ErrInvalidSplit) {
+				c.String(http.StatusBadRequest, err.Error())
+			} else {
+				c.AbortWithError(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		c.String(http.StatusOK, "splits updated")
+	}
+}
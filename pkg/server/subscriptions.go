@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/recurring"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// subscriptionCache keeps the recurring payments found through each access
+// until purchases change, as finding them goes through years of purchases.
+// Requests through the same access wait for the one finding them, while the
+// rest carry on.
+type subscriptionCache struct {
+	mu      sync.Mutex
+	entries map[storage.Access]*subscriptionEntry
+}
+
+// subscriptionEntry holds the recurring payments found through an access,
+// which are ready once done is closed.
+type subscriptionEntry struct {
+	done chan struct{}
+	subs []*models.Subscription
+	err  error
+}
+
+// get returns the recurring payments visible through the access, finding
+// them if they aren't cached.
+func (sc *subscriptionCache) get(stor *storage.Storage, a *storage.Access) ([]*models.Subscription, error) {
+	sc.mu.Lock()
+	if e, ok := sc.entries[*a]; ok {
+		sc.mu.Unlock()
+		<-e.done
+		return e.subs, e.err
+	}
+	if sc.entries == nil {
+		sc.entries = make(map[storage.Access]*subscriptionEntry)
+	}
+	e := &subscriptionEntry{done: make(chan struct{})}
+	entries := sc.entries
+	entries[*a] = e
+	sc.mu.Unlock()
+
+	e.subs, e.err = recurring.Find(stor, a)
+	close(e.done)
+	if e.err != nil {
+		// the next request tries again
+		sc.mu.Lock()
+		if entries[*a] == e {
+			delete(entries, *a)
+		}
+		sc.mu.Unlock()
+	}
+	return e.subs, e.err
+}
+
+// clear drops every cached subscription, for when purchases or who can see
+// them change. Those being found already are only handed to the requests
+// waiting for them.
+func (sc *subscriptionCache) clear() {
+	sc.mu.Lock()
+	sc.entries = nil
+	sc.mu.Unlock()
+}
+
+// watchPurchases clears the subscription cache whenever a sync finishes, a
+// purchase changes or who can see which accounts changes.
+func (s *Server) watchPurchases(ctx context.Context) {
+	evs, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-evs:
+			switch e.Type {
+			case events.SyncFinished, events.PurchaseCreated, events.PurchaseUpdated, events.PurchaseDeleted,
+				events.AccessChanged:
+				s.subscriptions.clear()
+			}
+		}
+	}
+}
+
+func (s *Server) handlerSubscriptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subs, err := s.subscriptions.get(s.Storage, access(c))
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		var annual int
+		for _, sub := range subs {
+			if !sub.Missed {
+				annual += sub.AnnualNOK
+			}
+		}
+		c.HTML(http.StatusOK, "subscriptions.html", gin.H{
+			"title":   "Subscriptions",
+			"payload": subs,
+			"annual":  annual,
+			"user":    currentUser(c),
+		})
+	}
+}
+
+func (s *Server) handlerAPISubscriptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subs, err := s.subscriptions.get(s.Storage, access(c))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, subs)
+	}
+}
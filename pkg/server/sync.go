@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+func (s *Server) handlerSyncRuns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		runs, err := s.syncer.SyncRuns()
+		if err == nil {
+			runs, err = s.visibleSyncs(access(c), runs...)
+		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, "an error occurred: %v", err)
+			return
+		}
+		c.HTML(http.StatusOK, "sync.html", gin.H{
+			"title":   "Sync history",
+			"payload": runs,
+			"user":    currentUser(c),
+		})
+	}
+}
+
+func (s *Server) handlerAPISyncRuns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		runs, err := s.syncer.SyncRuns()
+		if err == nil {
+			runs, err = s.visibleSyncs(access(c), runs...)
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, runs)
+	}
+}
+
+func (s *Server) handlerAPISyncRun() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("run"))
+		if err != nil {
+			c.String(http.StatusNotFound, "sync not found")
+			return
+		}
+		run, err := s.syncer.SyncRun(id)
+		if err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "sync not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		runs, err := s.visibleSyncs(access(c), run)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, runs[0])
+	}
+}
+
+// handlerAPISync starts loading purchases from Sbanken, or joins the sync
+// which is already running. The sync is returned once it's done if the wait
+// query parameter is true, and while it's running otherwise.
+func (s *Server) handlerAPISync() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, done, err := s.syncer.StartSync(models.SyncManual)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		status := http.StatusAccepted
+		if c.Query("wait") == "true" {
+			select {
+			case <-done:
+			case <-c.Request.Context().Done():
+				return
+			}
+			status = http.StatusOK
+		}
+		run, err := s.syncer.SyncRun(id)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		runs, err := s.visibleSyncs(access(c), run)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(status, runs[0])
+	}
+}
+
+// visibleSyncs returns copies of the syncs which only list the accounts
+// visible through the access. The syncs themselves may be shared with other
+// requests, so they're left alone.
+func (s *Server) visibleSyncs(a *storage.Access, runs ...*models.SyncRun) ([]*models.SyncRun, error) {
+	var names []string
+	for _, run := range runs {
+		for _, acct := range run.Accounts {
+			names = append(names, acct.Account)
+		}
+	}
+	visible, err := s.Storage.VisibleAccounts(names, a)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*models.SyncRun, len(runs))
+	for i, run := range runs {
+		cp := *run
+		cp.Accounts = []*models.AccountSync{}
+		for _, acct := range run.Accounts {
+			if visible[acct.Account] {
+				cp.Accounts = append(cp.Accounts, acct)
+			}
+		}
+		res[i] = &cp
+	}
+	return res, nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// cleanTag trims and lowercases a tag, returning false if it isn't usable.
+func cleanTag(tag string) (string, bool) {
+	const maxLength = 64
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	return tag, tag != "" && len(tag) <= maxLength
+}
+
+func (s *Server) handlerAPITagAdd() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tag, ok := cleanTag(c.Param("tag"))
+		if !ok {
+			c.String(http.StatusBadRequest, "invalid tag")
+			return
+		}
+		if err := s.Storage.AddTag(c.Param("purchase"), tag); err != nil {
+			if err == storage.ErrNotFound {
+				c.String(http.StatusNotFound, "purchase not found")
+			} else {
+				c.AbortWithError(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		c.String(http.StatusOK, "tag added")
+	}
+}
+
+func (s *Server) handlerAPITagRemove() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tag, _ := cleanTag(c.Param("tag"))
+		if err := s.Storage.RemoveTag(c.Param("purchase"), tag); err != nil {
+			if err == storage.ErrNotFound {
+				c.String(http.StatusNotFound, "tag not found")
+			} else {
+				c.AbortWithError(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		c.String(http.StatusOK, "tag removed")
+	}
+}
+
+func (s *Server) handlerAPINotes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Notes string `json:"notes"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.Storage.SetNotes(c.Param("purchase"), strings.TrimSpace(body.Notes)); err != nil {
+			if err == storage.ErrNotFound {
+				c.String(http.StatusNotFound, "purchase not found")
+			} else {
+				c.AbortWithError(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		c.String(http.StatusOK, "notes updated")
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// accessChanged drops what's been worked out about who can see which
+// accounts, once account owners or household members change.
+func (s *Server) accessChanged() {
+	s.subscriptions.clear()
+	s.events.Publish(events.New(events.AccessChanged))
+}
+
+func validRole(role string) bool {
+	for _, r := range models.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handlerAPIMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, currentUser(c))
+	}
+}
+
+// handlerAPIMeUpdate lets users set their own budget and pushover user key.
+func (s *Server) handlerAPIMeUpdate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Budget *int `json:"budget"`
+			// left as it is when missing
+			PushoverUser *string `json:"pushover_user"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		u := *currentUser(c)
+		u.Budget = body.Budget
+		if body.PushoverUser != nil {
+			u.PushoverUser = *body.PushoverUser
+		}
+		if err := s.Storage.UpdateUser(&u); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, u)
+	}
+}
+
+func (s *Server) handlerAPIUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		users, err := s.Storage.Users()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, users)
+	}
+}
+
+func (s *Server) handlerAPIUserAdd() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Username string `json:"username" binding:"required"`
+			Password string `json:"password" binding:"required"`
+			Role     string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if body.Role == "" {
+			body.Role = models.RoleViewer
+		}
+		if !validRole(body.Role) {
+			c.String(http.StatusBadRequest, "role must be one of %v", models.Roles)
+			return
+		}
+		if err := s.auth.AddUser(body.Username, body.Password, body.Role); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusCreated, models.User{Username: body.Username, Role: body.Role})
+	}
+}
+
+func (s *Server) handlerAPIUserUpdate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var u models.User
+		if err := c.ShouldBindJSON(&u); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		u.Username = c.Param("user")
+		if !validRole(u.Role) {
+			c.String(http.StatusBadRequest, "role must be one of %v", models.Roles)
+			return
+		}
+		if u.Username == currentUser(c).Username && u.Role != models.RoleAdmin {
+			c.String(http.StatusBadRequest, "admins can't demote themselves")
+			return
+		}
+		if err := s.Storage.UpdateUser(&u); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "user %s not found", u.Username)
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, u)
+	}
+}
+
+func (s *Server) handlerAPIUserDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("user")
+		if username == currentUser(c).Username {
+			c.String(http.StatusBadRequest, "admins can't delete themselves")
+			return
+		}
+		if err := s.Storage.DeleteUser(username); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "user %s not found", username)
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		s.accessChanged()
+		c.String(http.StatusOK, "user deleted")
+	}
+}
+
+func (s *Server) handlerAPIHouseholds() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		households, err := s.Storage.Households()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		// users only see the households they are in
+		if u := currentUser(c); !u.Can(models.RoleAdmin) {
+			var mine []*models.Household
+			for _, h := range households {
+				for _, m := range h.Members {
+					if m == u.Username {
+						mine = append(mine, h)
+					}
+				}
+			}
+			households = mine
+		}
+		if households == nil {
+			households = []*models.Household{}
+		}
+		c.JSON(http.StatusOK, households)
+	}
+}
+
+func (s *Server) handlerAPIHouseholdAdd() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var h models.Household
+		if err := c.ShouldBindJSON(&h); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if h.Name == "" {
+			c.String(http.StatusBadRequest, "name is required")
+			return
+		}
+		h.Members = []string{}
+		if err := s.Storage.AddHousehold(&h); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusCreated, h)
+	}
+}
+
+// householdParam reads the household ID from the path, answering 404 if it
+// isn't one.
+func householdParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("household"))
+	if err != nil {
+		c.String(http.StatusNotFound, "household not found")
+		return 0, false
+	}
+	return id, true
+}
+
+func (s *Server) handlerAPIHouseholdBudget() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := householdParam(c)
+		if !ok {
+			return
+		}
+		var body struct {
+			Budget *int `json:"budget"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.Storage.SetHouseholdBudget(id, body.Budget); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "household not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.String(http.StatusOK, "budget updated")
+	}
+}
+
+func (s *Server) handlerAPIHouseholdDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := householdParam(c)
+		if !ok {
+			return
+		}
+		if err := s.Storage.DeleteHousehold(id); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "household not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		s.accessChanged()
+		c.String(http.StatusOK, "household deleted")
+	}
+}
+
+// handlerAPIHouseholdMember adds or, when deleting, removes a user from a
+// household.
+func (s *Server) handlerAPIHouseholdMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := householdParam(c)
+		if !ok {
+			return
+		}
+		var err error
+		if c.Request.Method == http.MethodDelete {
+			err = s.Storage.RemoveHouseholdMember(id, c.Param("user"))
+		} else {
+			err = s.Storage.AddHouseholdMember(id, c.Param("user"))
+		}
+		if err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "household or member not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		s.accessChanged()
+		c.String(http.StatusOK, "members updated")
+	}
+}
+
+func (s *Server) handlerAPIAccounts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accounts, err := s.Storage.Accounts(access(c))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, accounts)
+	}
+}
+
+// handlerAPIAccountOwner gives an account to a user or a household.
+func (s *Server) handlerAPIAccountOwner() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var a models.Account
+		if err := c.ShouldBindJSON(&a); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		a.Name = c.Param("account")
+		if a.Owner != "" && a.Household != nil {
+			c.String(http.StatusBadRequest, "an account is owned by either a user or a household")
+			return
+		}
+		if err := s.Storage.SetAccountOwner(&a); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "owner not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		s.accessChanged()
+		c.JSON(http.StatusOK, a)
+	}
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// specFile describes /api/v1, which is checked against it.
+	specFile = "api/openapi.yaml"
+	v1Prefix = "/api/v1"
+
+	validationKey = "openapi"
+)
+
+func init() {
+	// keep validation errors to one line, as they're shown to clients
+	openapi3.SchemaErrorDetailsDisabled = true
+}
+
+// apiSpec is the OpenAPI document of /api/v1.
+type apiSpec struct {
+	doc    *openapi3.T
+	router routers.Router
+	yaml   []byte
+	json   []byte
+}
+
+func loadSpec(path string) (*apiSpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("validating %s: %w", path, err)
+	}
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &apiSpec{doc: doc, router: router, yaml: raw, json: js}, nil
+}
+
+// routesV1 registers the versioned API, which reuses the handlers of /api
+// with every request and response checked against the spec. It fails if a
+// route is missing from the spec.
+func (s *Server) routesV1() error {
+	s.router.GET(v1Prefix+"/openapi.yaml", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/yaml", s.spec.yaml)
+	})
+	s.router.GET(v1Prefix+"/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", s.spec.json)
+	})
+
+	v1 := s.router.Group(v1Prefix, s.apiErrors(), s.requireUser(true), s.validateRequest())
+	editor := s.requireRole(models.RoleEditor)
+	admin := s.requireRole(models.RoleAdmin)
+	changed := s.purchaseChanged()
+	v1.GET("/purchases", s.handlerAPIQueryPurchases())
+	purchase := v1.Group("/purchases/:purchase", s.visiblePurchase())
+	purchase.GET("", s.handlerAPIPurchase())
+	purchase.DELETE("", editor, s.handlerAPIPurchaseDelete())
+	purchase.PUT("/tags/:tag", editor, changed, s.handlerAPITagAdd())
+	purchase.DELETE("/tags/:tag", editor, changed, s.handlerAPITagRemove())
+	purchase.PUT("/notes", editor, changed, s.handlerAPINotes())
+	purchase.PUT("/splits", editor, changed, s.handlerAPISplits())
+	purchase.DELETE("/splits", editor, changed, s.handlerAPISplits())
+	purchase.PUT("/shares", editor, changed, s.handlerAPIShares())
+	purchase.DELETE("/shares", editor, changed, s.handlerAPIShares())
+	purchase.PUT("/payer", editor, changed, s.handlerAPIPayer())
+	v1.GET("/search", s.handlerAPISearch())
+	v1.GET("/totals", s.handlerAPITotals())
+	v1.GET("/forecast/:year/:month", s.handlerAPIForecast())
+	v1.GET("/subscriptions", s.handlerAPISubscriptions())
+	v1.GET("/balances", s.handlerAPIBalances())
+	v1.GET("/settlements", s.handlerAPISettlements())
+	v1.POST("/settlements", editor, s.handlerAPISettlementAdd())
+	v1.DELETE("/settlements/:settlement", editor, s.handlerAPISettlementDelete())
+	v1.GET("/account-payers", s.handlerAPIAccountPayers())
+	v1.PUT("/account-payers", editor, s.handlerAPIAccountPayerSet())
+	v1.GET("/vendor-aliases", s.handlerAPIVendorAliases())
+	v1.PUT("/vendor-aliases", admin, s.handlerAPIVendorAliasSet())
+	v1.POST("/vendor-aliases/apply", admin, s.handlerAPIVendorAliasesApply())
+	v1.DELETE("/vendor-aliases/:raw", admin, s.handlerAPIVendorAliasDelete())
+	v1.GET("/sync", s.handlerAPISyncRuns())
+	v1.POST("/sync", editor, s.handlerAPISync())
+	v1.GET("/sync/:run", s.handlerAPISyncRun())
+	v1.GET("/events", s.handlerAPIEvents())
+	v1.GET("/me", s.handlerAPIMe())
+	v1.PUT("/me", s.handlerAPIMeUpdate())
+	v1.GET("/users", admin, s.handlerAPIUsers())
+	v1.POST("/users", admin, s.handlerAPIUserAdd())
+	v1.PUT("/users/:user", admin, s.handlerAPIUserUpdate())
+	v1.DELETE("/users/:user", admin, s.handlerAPIUserDelete())
+	v1.GET("/households", s.handlerAPIHouseholds())
+	v1.POST("/households", admin, s.handlerAPIHouseholdAdd())
+	v1.DELETE("/households/:household", admin, s.handlerAPIHouseholdDelete())
+	v1.PUT("/households/:household/budget", admin, s.handlerAPIHouseholdBudget())
+	v1.PUT("/households/:household/members/:user", admin, s.handlerAPIHouseholdMember())
+	v1.DELETE("/households/:household/members/:user", admin, s.handlerAPIHouseholdMember())
+	v1.GET("/accounts", s.handlerAPIAccounts())
+	v1.PUT("/accounts/:account/owner", admin, s.handlerAPIAccountOwner())
+	v1.GET("/tokens", s.handlerAPITokens())
+	v1.POST("/tokens", s.handlerAPITokenCreate())
+	v1.DELETE("/tokens/:token", s.handlerAPITokenDelete())
+	v1.GET("/webhooks", admin, s.handlerAPIWebhooks())
+	v1.POST("/webhooks", admin, s.handlerAPIWebhookAdd())
+	v1.GET("/webhooks/:webhook", admin, s.handlerAPIWebhook())
+	v1.PUT("/webhooks/:webhook", admin, s.handlerAPIWebhookUpdate())
+	v1.DELETE("/webhooks/:webhook", admin, s.handlerAPIWebhookDelete())
+	v1.GET("/webhooks/:webhook/deliveries", admin, s.handlerAPIWebhookDeliveries())
+	v1.POST("/webhooks/:webhook/ping", admin, s.handlerAPIWebhookPing())
+
+	return s.checkSpec()
+}
+
+// checkSpec makes sure that every route of /api/v1 is described by the spec
+// and the other way around.
+func (s *Server) checkSpec() error {
+	documented := make(map[string]bool)
+	for path, item := range s.spec.doc.Paths {
+		for method := range item.Operations() {
+			documented[method+" "+path] = true
+		}
+	}
+	for _, r := range s.router.Routes() {
+		if !strings.HasPrefix(r.Path, v1Prefix+"/") || strings.HasPrefix(r.Path, v1Prefix+"/openapi.") {
+			continue
+		}
+		// gin's :param is the spec's {param}
+		parts := strings.Split(strings.TrimPrefix(r.Path, v1Prefix), "/")
+		for i, p := range parts {
+			if strings.HasPrefix(p, ":") {
+				parts[i] = "{" + p[1:] + "}"
+			}
+		}
+		key := r.Method + " " + strings.Join(parts, "/")
+		if !documented[key] {
+			return fmt.Errorf("%s: %s is missing", specFile, key)
+		}
+		delete(documented, key)
+	}
+	for key := range documented {
+		return fmt.Errorf("%s: %s has no handler", specFile, key)
+	}
+	return nil
+}
+
+// apiError is the body of every error answered by /api/v1.
+type apiError struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func newAPIError(status int, msg string) apiError {
+	var e apiError
+	e.Error.Status = status
+	e.Error.Message = msg
+	return e
+}
+
+// handlerNoRoute answers requests for unknown pages with a 404, which is an
+// apiError under /api/v1.
+func (s *Server) handlerNoRoute() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, v1Prefix+"/") {
+			c.JSON(http.StatusNotFound, newAPIError(http.StatusNotFound, "no such endpoint"))
+		}
+	}
+}
+
+// validateRequest answers 400 to requests which don't match the spec. The
+// request is kept for validating the response.
+func (s *Server) validateRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// match the escaped path, as path parameters may contain slashes
+		req := *c.Request
+		u := *req.URL
+		u.Path = u.EscapedPath()
+		req.URL = &u
+		route, params, err := s.spec.router.FindRoute(&req)
+		if err != nil {
+			c.String(http.StatusNotFound, "no such endpoint")
+			c.Abort()
+			return
+		}
+		for k, v := range params {
+			if unescaped, err := url.PathUnescape(v); err == nil {
+				params[k] = unescaped
+			}
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: params,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				// requireUser has already let the user in
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			c.Abort()
+			return
+		}
+		c.Set(validationKey, input)
+	}
+}
+
+// apiErrors gives the responses of /api/v1 consistent bodies: errors become
+// an apiError, hiding the details of server errors, and text messages are
+// wrapped in JSON. Responses are checked against the spec, with mismatches
+// logged. Event streams are passed through as they are.
+func (s *Server) apiErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if w.streaming {
+			return
+		}
+
+		status, body := w.status, w.body.Bytes()
+		header := c.Writer.Header()
+		text := strings.HasPrefix(header.Get("Content-Type"), "text/plain")
+		switch {
+		case status >= http.StatusInternalServerError || (status >= http.StatusBadRequest && len(body) == 0):
+			body = mustJSON(header, newAPIError(status, http.StatusText(status)))
+		case status >= http.StatusBadRequest && text:
+			body = mustJSON(header, newAPIError(status, strings.TrimSpace(string(body))))
+		case text:
+			body = mustJSON(header, gin.H{"message": string(body)})
+		}
+
+		if v, ok := c.Get(validationKey); ok {
+			input := &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: v.(*openapi3filter.RequestValidationInput),
+				Status:                 status,
+				Header:                 header,
+				Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+			}
+			input.SetBodyBytes(body)
+			if err := openapi3filter.ValidateResponse(c.Request.Context(), input); err != nil {
+				log.Warnf("response to %s %s doesn't match %s: %v", c.Request.Method, c.Request.URL.Path,
+					specFile, err)
+			}
+		}
+
+		c.Writer.WriteHeader(status)
+		if len(body) > 0 {
+			c.Writer.Write(body)
+		}
+	}
+}
+
+func mustJSON(header http.Header, v interface{}) []byte {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	return bs
+}
+
+// bufferedWriter holds back a response until the handlers are done with it,
+// unless it's an event stream.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	streaming bool
+}
+
+func (w *bufferedWriter) stream() bool {
+	if !w.streaming && strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		w.streaming = true
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	return w.streaming
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+	w.stream()
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	if w.stream() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	if w.stream() {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	if w.stream() {
+		return w.ResponseWriter.WriteString(s)
+	}
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Flush() {
+	if w.stream() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *bufferedWriter) Status() int {
+	if w.streaming {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if w.streaming {
+		return w.ResponseWriter.Size()
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	if w.streaming {
+		return w.ResponseWriter.Written()
+	}
+	return w.body.Len() > 0
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+	"github.com/j18e/sbanken-client/pkg/vendors"
+)
+
+func (s *Server) handlerAPIVendorAliases() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		aliases, err := s.Storage.VendorAliases()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, aliases)
+	}
+}
+
+func (s *Server) handlerAPIVendorAliasSet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var alias models.VendorAlias
+		if err := c.ShouldBindJSON(&alias); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.Storage.SetVendorAlias(&alias); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		changed, err := vendors.Rename(s.Storage)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"alias": alias, "renamed": changed})
+	}
+}
+
+func (s *Server) handlerAPIVendorAliasDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.Storage.DeleteVendorAlias(c.Param("raw")); err != nil {
+			if err == storage.ErrNotFound {
+				c.String(http.StatusNotFound, "alias not found")
+			} else {
+				c.AbortWithError(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		changed, err := vendors.Rename(s.Storage)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"renamed": changed})
+	}
+}
+
+func (s *Server) handlerAPIVendorAliasesApply() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		changed, err := vendors.Rename(s.Storage)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"renamed": changed})
+	}
+}
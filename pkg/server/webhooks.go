@@ -0,0 +1,249 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+	"github.com/j18e/sbanken-client/pkg/webhooks"
+)
+
+// deliveryLogSize is how many deliveries to a webhook are listed.
+const deliveryLogSize = 100
+
+// webhookBody is what's sent to create or change a webhook.
+type webhookBody struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+func (b webhookBody) validate() error {
+	u, err := url.Parse(b.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	for _, e := range b.Events {
+		if !events.ValidType(e) {
+			return fmt.Errorf("unknown event %q - must be one of %v", e, events.Types)
+		}
+	}
+	return nil
+}
+
+// webhookParam reads the webhook ID from the path, answering 404 if it isn't
+// one.
+func webhookParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("webhook"))
+	if err != nil {
+		c.String(http.StatusNotFound, "webhook not found")
+		return 0, false
+	}
+	return id, true
+}
+
+func (s *Server) handlerAPIWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hooks, err := s.Storage.Webhooks()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		for _, w := range hooks {
+			w.Secret = ""
+		}
+		c.JSON(http.StatusOK, hooks)
+	}
+}
+
+// handlerAPIWebhookAdd creates a webhook, answering with its secret, which
+// is generated unless one is given.
+func (s *Server) handlerAPIWebhookAdd() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body webhookBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := body.validate(); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		w := models.Webhook{
+			URL:     body.URL,
+			Secret:  body.Secret,
+			Events:  body.Events,
+			Active:  body.Active == nil || *body.Active,
+			Created: time.Now(),
+		}
+		if w.Secret == "" {
+			w.Secret = webhooks.NewSecret()
+		}
+		if w.Events == nil {
+			w.Events = []string{}
+		}
+		if err := s.Storage.AddWebhook(&w); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		s.webhooks.HooksChanged()
+		c.JSON(http.StatusCreated, w)
+	}
+}
+
+func (s *Server) handlerAPIWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := webhookParam(c)
+		if !ok {
+			return
+		}
+		w, err := s.Storage.GetWebhook(id)
+		if err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "webhook not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		w.Secret = ""
+		c.JSON(http.StatusOK, w)
+	}
+}
+
+// handlerAPIWebhookUpdate replaces the URL and events of a webhook, along
+// with its secret and whether it's active when those are given.
+func (s *Server) handlerAPIWebhookUpdate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := webhookParam(c)
+		if !ok {
+			return
+		}
+		var body webhookBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := body.validate(); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		w, err := s.Storage.GetWebhook(id)
+		if err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "webhook not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		w.URL = body.URL
+		w.Events = body.Events
+		if w.Events == nil {
+			w.Events = []string{}
+		}
+		if body.Secret != "" {
+			w.Secret = body.Secret
+		}
+		if body.Active != nil {
+			w.Active = *body.Active
+		}
+		if err := s.Storage.UpdateWebhook(w); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "webhook not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		s.webhooks.HooksChanged()
+		w.Secret = ""
+		c.JSON(http.StatusOK, w)
+	}
+}
+
+func (s *Server) handlerAPIWebhookDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := webhookParam(c)
+		if !ok {
+			return
+		}
+		if err := s.Storage.DeleteWebhook(id); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "webhook not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		s.webhooks.HooksChanged()
+		c.String(http.StatusOK, "webhook deleted")
+	}
+}
+
+// handlerAPIWebhookDeliveries lists the latest attempts at delivering events
+// to a webhook, newest first.
+func (s *Server) handlerAPIWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := webhookParam(c)
+		if !ok {
+			return
+		}
+		if _, err := s.Storage.GetWebhook(id); err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "webhook not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		deliveries, err := s.Storage.WebhookDeliveries(id, deliveryLogSize)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+// handlerAPIWebhookPing sends a ping event to a webhook, whether it's active
+// or not, answering with the ID of the event to look for among the
+// deliveries.
+func (s *Server) handlerAPIWebhookPing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := webhookParam(c)
+		if !ok {
+			return
+		}
+		w, err := s.Storage.GetWebhook(id)
+		if err == storage.ErrNotFound {
+			c.String(http.StatusNotFound, "webhook not found")
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"event_id": s.webhooks.Ping(w)})
+	}
+}
+
+// purchaseChanged publishes the purchase in the path as updated once the
+// handler has changed it successfully.
+func (s *Server) purchaseChanged() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.IsAborted() || c.Writer.Status() >= 300 {
+			return
+		}
+		p, err := s.Storage.GetPurchase(c.Param("purchase"))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		e := events.New(events.PurchaseUpdated)
+		e.Purchase = p
+		s.events.Publish(e)
+	}
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/metrics"
+)
+
+// timedDB is a *sql.DB which observes the latency of queries.
+type timedDB struct {
+	*sql.DB
+}
+
+func observe(operation string, start time.Time) {
+	metrics.DBLatency.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (db timedDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observe("exec", time.Now())
+	return db.DB.Exec(query, args...)
+}
+
+func (db timedDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observe("query", time.Now())
+	return db.DB.Query(query, args...)
+}
+
+func (db timedDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observe("query_row", time.Now())
+	return db.DB.QueryRow(query, args...)
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// Filter narrows down which purchases are read from storage. Fields left at
+// their zero value match every purchase.
+type Filter struct {
+	From     models.Date // inclusive
+	To       models.Date // inclusive
+	Category string
+	Vendor   string
+	Account  string
+	MinNOK   *int
+	MaxNOK   *int
+	Tag      string
+	Shared   bool   // only purchases shared within the household
+	Text     string // matched against vendor, location, category and notes
+	Access   *Access
+}
+
+// Access limits purchases to those a user may see: the ones on accounts owned
+// by the user or one of their households and, for admins, on accounts nobody
+// owns yet. A nil *Access sees every purchase.
+type Access struct {
+	Username string
+	Admin    bool
+}
+
+// condition returns the SQL condition matching the accounts visible through
+// the access, with the account column taken from the given table.
+func (a *Access) condition(table string) (string, []interface{}) {
+	cond := table + ".account IN (SELECT account FROM account_owners WHERE username = ? OR household_id IN " +
+		"(SELECT household_id FROM household_members WHERE username = ?))"
+	if a.Admin {
+		cond = "(" + cond + " OR NOT EXISTS (SELECT 1 FROM account_owners WHERE account = " + table + ".account))"
+	}
+	return cond, []interface{}{a.Username, a.Username}
+}
+
+// ownerCondition returns the SQL condition matching the rows of the given
+// table which belong to the user or one of their households, the table
+// having username and household_id columns like account_owners does. Admins
+// also see the rows which belong to nobody.
+func (a *Access) ownerCondition(table string) (string, []interface{}) {
+	cond := table + ".username = ? OR " + table + ".household_id IN " +
+		"(SELECT household_id FROM household_members WHERE username = ?)"
+	if a.Admin {
+		cond += " OR (" + table + ".username IS NULL AND " + table + ".household_id IS NULL)"
+	}
+	return "(" + cond + ")", []interface{}{a.Username, a.Username}
+}
+
+// where returns the SQL condition and arguments matching the filter when
+// selecting from the given table, which is either the purchases table or the
+// spending view. Categories of split purchases match either the purchase or
+// its parts in the purchases table, but only the parts in the spending view.
+func (f Filter) where(table string) whereClause {
+	var w whereClause
+	if !f.From.IsZero() {
+		w.add("date >= ?", f.From.Stamp())
+	}
+	if !f.To.IsZero() {
+		w.add("date <= ?", f.To.Stamp())
+	}
+	if f.Category != "" && table == spendingView {
+		w.add("category = ?", f.Category)
+	} else if f.Category != "" {
+		w.add("(category = ? OR EXISTS (SELECT 1 FROM purchase_splits WHERE purchase_id = purchases.id AND "+
+			"purchase_splits.category = ?))", f.Category, f.Category)
+	}
+	if f.Vendor != "" {
+		w.add("vendor = ?", f.Vendor)
+	}
+	if f.Account != "" {
+		w.add("account = ?", f.Account)
+	}
+	if f.MinNOK != nil {
+		w.add("nok >= ?", *f.MinNOK)
+	}
+	if f.MaxNOK != nil {
+		w.add("nok <= ?", *f.MaxNOK)
+	}
+	if f.Text != "" {
+		pattern := "%" + likeEscaper.Replace(f.Text) + "%"
+		w.add("(vendor ILIKE ? OR location ILIKE ? OR category ILIKE ? OR notes ILIKE ?)",
+			pattern, pattern, pattern, pattern)
+	}
+	if f.Tag != "" {
+		w.add("EXISTS (SELECT 1 FROM purchase_tags WHERE purchase_id = "+table+".id AND tag = ?)", f.Tag)
+	}
+	if f.Shared {
+		w.add("EXISTS (SELECT 1 FROM purchase_shares WHERE purchase_id = " + table + ".id)")
+	}
+	if f.Access != nil {
+		cond, args := f.Access.condition(table)
+		w.add(cond, args...)
+	}
+	return w
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// whereClause builds a WHERE clause out of conditions using question mark
+// placeholders, which are numbered the way postgres expects them.
+type whereClause struct {
+	conds []string
+	args  []interface{}
+}
+
+func (w *whereClause) add(cond string, args ...interface{}) {
+	for _, arg := range args {
+		w.args = append(w.args, arg)
+		cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(w.args)), 1)
+	}
+	w.conds = append(w.conds, cond)
+}
+
+func (w whereClause) String() string {
+	if len(w.conds) < 1 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// MonthFilter returns a filter matching every purchase in the given month.
+func MonthFilter(month models.Date) Filter {
+	month.Day = 1
+	last := models.DateFromTime(month.AddMonth().Time().AddDate(0, 0, -1))
+	return Filter{From: month, To: last}
+}
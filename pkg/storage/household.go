@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/lib/pq"
+)
+
+// Households retreives every household along with its members.
+func (s *Storage) Households() ([]*models.Household, error) {
+	rows, err := s.db.Query(`SELECT id, name, budget, ` +
+		`ARRAY(SELECT username FROM household_members WHERE household_id = households.id ORDER BY username) ` +
+		`FROM households ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.Household{}
+	for rows.Next() {
+		var h models.Household
+		var budget sql.NullInt64
+		var members pq.StringArray
+		if err := rows.Scan(&h.ID, &h.Name, &budget, &members); err != nil {
+			return nil, err
+		}
+		if budget.Valid {
+			b := int(budget.Int64)
+			h.Budget = &b
+		}
+		h.Members = members
+		res = append(res, &h)
+	}
+	return res, rows.Err()
+}
+
+// AddHousehold saves a household without any members, setting its ID.
+func (s *Storage) AddHousehold(h *models.Household) error {
+	return s.db.QueryRow(`INSERT INTO households(name, budget) VALUES ($1, $2) RETURNING id`,
+		h.Name, h.Budget).Scan(&h.ID)
+}
+
+// SetHouseholdBudget sets the monthly budget of a household. A nil budget
+// removes it.
+func (s *Storage) SetHouseholdBudget(id int, budget *int) error {
+	res, err := s.db.Exec(`UPDATE households SET budget = $1 WHERE id = $2`, budget, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteHousehold deletes a household. Accounts it owned go back to being
+// unowned.
+func (s *Storage) DeleteHousehold(id int) error {
+	res, err := s.db.Exec(`DELETE FROM households WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddHouseholdMember adds a user to a household.
+func (s *Storage) AddHouseholdMember(id int, username string) error {
+	_, err := s.db.Exec(`INSERT INTO household_members(household_id, username) VALUES ($1, $2) `+
+		`ON CONFLICT DO NOTHING`, id, username)
+	if err, ok := err.(*pq.Error); ok && err.Code.Name() == "foreign_key_violation" {
+		return ErrNotFound
+	}
+	return err
+}
+
+// RemoveHouseholdMember removes a user from a household.
+func (s *Storage) RemoveHouseholdMember(id int, username string) error {
+	res, err := s.db.Exec(`DELETE FROM household_members WHERE household_id = $1 AND username = $2`, id, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Accounts retreives the accounts purchases have been made on along with
+// their owners, limited to the accounts visible through the access.
+func (s *Storage) Accounts(access *Access) ([]*models.Account, error) {
+	var w whereClause
+	if access != nil {
+		cond, args := access.condition("accounts")
+		w.add(cond, args...)
+	}
+	rows, err := s.db.Query(`SELECT accounts.account, COALESCE(account_owners.username, ''), `+
+		`account_owners.household_id FROM (SELECT DISTINCT account FROM purchases) accounts `+
+		`LEFT JOIN account_owners ON account_owners.account = accounts.account`+w.String()+
+		` ORDER BY accounts.account`, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.Account{}
+	for rows.Next() {
+		var a models.Account
+		var household sql.NullInt64
+		if err := rows.Scan(&a.Name, &a.Owner, &household); err != nil {
+			return nil, err
+		}
+		if household.Valid {
+			id := int(household.Int64)
+			a.Household = &id
+		}
+		res = append(res, &a)
+	}
+	return res, rows.Err()
+}
+
+// VisibleAccounts returns which of the given accounts are visible through the
+// access, whether or not purchases have been made on them.
+func (s *Storage) VisibleAccounts(names []string, access *Access) (map[string]bool, error) {
+	var w whereClause
+	if access != nil {
+		cond, args := access.condition("accounts")
+		w.add(cond, args...)
+	}
+	qs := fmt.Sprintf(`SELECT account FROM unnest($%d::text[]) accounts(account)`, len(w.args)+1) + w.String()
+	rows, err := s.db.Query(qs, append(w.args, pq.Array(names))...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		res[name] = true
+	}
+	return res, rows.Err()
+}
+
+// SetAccountOwner gives an account to either a user or a household. Leaving
+// both empty makes the account unowned.
+func (s *Storage) SetAccountOwner(a *models.Account) error {
+	if a.Owner == "" && a.Household == nil {
+		_, err := s.db.Exec(`DELETE FROM account_owners WHERE account = $1`, a.Name)
+		return err
+	}
+	var owner *string
+	if a.Owner != "" {
+		owner = &a.Owner
+	}
+	_, err := s.db.Exec(`INSERT INTO account_owners(account, username, household_id) VALUES ($1, $2, $3) `+
+		`ON CONFLICT (account) DO UPDATE SET username = EXCLUDED.username, household_id = EXCLUDED.household_id`,
+		a.Name, owner, a.Household)
+	if err, ok := err.(*pq.Error); ok && err.Code.Name() == "foreign_key_violation" {
+		return ErrNotFound
+	}
+	return err
+}
+
+// PurchaseVisible returns ErrNotFound unless the purchase exists and is
+// visible through the access.
+func (s *Storage) PurchaseVisible(id string, access *Access) error {
+	w := Filter{Access: access}.where(purchasesTable)
+	w.add("id = ?", id)
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM purchases`+w.String()+`)`, w.args...).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -6,50 +6,141 @@ import (
 	"time"
 
 	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/lib/pq"
 )
 
-// AddPurchases saves a slice of *models.Purchase to storage. It will do
-// nothing and return nil if a row exists in storage with the same purchase
-// ID.
-func (s *Storage) AddPurchases(px []*models.Purchase) error {
-	// TODO get question mark notation working in query execution
-	const qs = `INSERT INTO purchases(id, date, nok, account, category, location, vendor) ` +
-		`VALUES %s ON CONFLICT (id) DO NOTHING`
+// AddResult tells which purchases given to AddPurchases were new, which
+// were already in storage but had changed, and which were already stored as
+// they are. Unsplit lists the changed purchases whose splits were removed
+// because their amount changed.
+type AddResult struct {
+	Inserted []string `json:"inserted"`
+	Updated  []string `json:"updated"`
+	Skipped  []string `json:"skipped"`
+	Unsplit  []string `json:"unsplit"`
+}
+
+// upsertBatchSize is how many purchases are upserted in one statement, which
+// keeps within the 65535 parameters postgres allows.
+const upsertBatchSize = 1000
+
+// AddPurchases saves a slice of *models.Purchase to storage. Purchases which
+// are already in storage are updated if the bank has changed their date,
+// amount, category, location or vendor since, and left alone otherwise.
+// Notes, tags and shares are never touched, while splits are removed from
+// purchases whose amount changed since their parts no longer sum up to it.
+func (s *Storage) AddPurchases(px []*models.Purchase) (*AddResult, error) {
+	const qs = `INSERT INTO purchases(id, date, nok, account, category, location, vendor, raw_vendor) ` +
+		`VALUES %s ON CONFLICT (id) DO UPDATE SET date = EXCLUDED.date, nok = EXCLUDED.nok, ` +
+		`category = EXCLUDED.category, location = EXCLUDED.location, vendor = EXCLUDED.vendor, ` +
+		`raw_vendor = EXCLUDED.raw_vendor ` +
+		`WHERE (purchases.date, purchases.nok, purchases.category, purchases.location, purchases.raw_vendor) ` +
+		`IS DISTINCT FROM (EXCLUDED.date, EXCLUDED.nok, EXCLUDED.category, EXCLUDED.location, EXCLUDED.raw_vendor) ` +
+		// rows which were inserted rather than updated have no xmax
+		`RETURNING id, xmax = 0`
+
+	res := &AddResult{Inserted: []string{}, Updated: []string{}, Skipped: []string{}, Unsplit: []string{}}
 
-	if len(px) < 1 {
-		return fmt.Errorf("no purchases provided")
+	// a purchase can't be upserted twice in one statement, so only the last
+	// of any purchases sharing an ID is kept
+	seen := make(map[string]bool)
+	var unique []*models.Purchase
+	for i := len(px) - 1; i >= 0; i-- {
+		if seen[px[i].ID] {
+			res.Skipped = append(res.Skipped, px[i].ID)
+			continue
+		}
+		seen[px[i].ID] = true
+		unique = append([]*models.Purchase{px[i]}, unique...)
+	}
+	if len(unique) < 1 {
+		return res, nil
 	}
 
-	sanitize := func(s string) string {
-		return strings.Replace(s, `'`, `''`, -1)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	changed := make(map[string]bool)
+	for len(unique) > 0 {
+		batch := unique
+		if len(batch) > upsertBatchSize {
+			batch = batch[:upsertBatchSize]
+		}
+		unique = unique[len(batch):]
+
+		var vals []string
+		var args []interface{}
+		for _, p := range batch {
+			n := len(args)
+			vals = append(vals, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+				n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+			args = append(args, p.ID, p.Date.Stamp(), p.NOK, p.Account, p.Category, p.Location, p.Vendor,
+				p.RawVendor)
+		}
+		rows, err := tx.Query(fmt.Sprintf(qs, strings.Join(vals, ", ")), args...)
+		if err != nil {
+			return nil, fmt.Errorf("got error %w while executing %s", err, qs)
+		}
+		for rows.Next() {
+			var id string
+			var inserted bool
+			if err := rows.Scan(&id, &inserted); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			changed[id] = true
+			if inserted {
+				res.Inserted = append(res.Inserted, id)
+			} else {
+				res.Updated = append(res.Updated, id)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		for _, p := range batch {
+			if !changed[p.ID] {
+				res.Skipped = append(res.Skipped, p.ID)
+			}
+		}
 	}
 
-	vals := ""
-	for _, p := range px {
-		vals += fmt.Sprintf("('%s', '%s', %d, '%s', '%s', '%s', '%s'),\n",
-			sanitize(p.ID),
-			p.Date.Stamp(),
-			p.NOK,
-			sanitize(p.Account),
-			sanitize(p.Category),
-			sanitize(p.Location),
-			sanitize(p.Vendor),
-		)
+	if len(res.Updated) > 0 {
+		rows, err := tx.Query(`DELETE FROM purchase_splits WHERE purchase_id IN (SELECT s.purchase_id `+
+			`FROM purchase_splits s JOIN purchases p ON p.id = s.purchase_id WHERE s.purchase_id = ANY($1) `+
+			`GROUP BY s.purchase_id, p.nok HAVING SUM(s.nok) <> p.nok) RETURNING purchase_id`,
+			pq.StringArray(res.Updated))
+		if err != nil {
+			return nil, fmt.Errorf("removing outdated splits: %w", err)
+		}
+		unsplit := make(map[string]bool)
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !unsplit[id] {
+				unsplit[id] = true
+				res.Unsplit = append(res.Unsplit, id)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
 	}
-	stmt := fmt.Sprintf(qs, strings.TrimRight(vals, ",\n"))
-	if _, err := s.db.Exec(stmt); err != nil {
-		return fmt.Errorf("got error %w while executing %s", err, qs)
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
-	return nil
+	return res, nil
 }
 
 // GetPurchases retreives all purchases for the given month from storage
 func (s *Storage) GetPurchases(month models.Date) ([]*models.Purchase, error) {
-	const (
-		qs = `SELECT id, date, nok, account, category, location, vendor ` +
-			`FROM purchases WHERE date >= '%s' AND date < '%s'`
-		dateLayout = `2006-01-02T15:04:05Z`
-	)
+	const qs = `SELECT ` + purchaseColumns + ` FROM purchases WHERE date >= '%s' AND date < '%s'`
 
 	month.Day = 1
 	query := fmt.Sprintf(qs, month.Stamp(), month.AddMonth().Stamp())
@@ -61,51 +152,100 @@ func (s *Storage) GetPurchases(month models.Date) ([]*models.Purchase, error) {
 
 	var res []*models.Purchase
 	for rows.Next() {
-		var p models.Purchase
-		var dateStr string
-		if err := rows.Scan(&p.ID, &dateStr, &p.NOK, &p.Account, &p.Category, &p.Location, &p.Vendor); err != nil {
+		p, err := scanPurchase(rows)
+		if err != nil {
 			return nil, err
 		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
 
-		d, err := time.Parse(dateLayout, dateStr)
+// EachPurchase calls fn for every purchase matching the filter, ordered by
+// date. Rows are read one at a time so that long date ranges don't have to
+// fit in memory. Iteration stops at the first error returned by fn.
+func (s *Storage) EachPurchase(f Filter, fn func(*models.Purchase) error) error {
+	where := f.where(purchasesTable)
+	rows, err := s.db.Query(`SELECT `+purchaseColumns+` FROM purchases`+where.String()+
+		` ORDER BY date, id`, where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPurchase(rows)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
 		}
-		p.Date = models.Date{Year: d.Year(), Month: d.Month(), MonthNum: int(d.Month()), Day: d.Day()}
-		res = append(res, &p)
 	}
-	return res, nil
+	return rows.Err()
+}
+
+// Purchases retreives all purchases matching the filter, ordered by date.
+func (s *Storage) Purchases(f Filter) ([]*models.Purchase, error) {
+	res := []*models.Purchase{}
+	err := s.EachPurchase(f, func(p *models.Purchase) error {
+		res = append(res, p)
+		return nil
+	})
+	return res, err
 }
 
 // GetPurchase retreives one purchase from storage.
 func (s *Storage) GetPurchase(id string) (*models.Purchase, error) {
-	const (
-		qs = `SELECT id, date, nok, account, category, location, vendor ` +
-			`FROM purchases WHERE id = '%s'`
-		dateLayout = `2006-01-02T15:04:05Z`
-	)
-	row := s.db.QueryRow(fmt.Sprintf(qs, id))
+	const qs = `SELECT ` + purchaseColumns + ` FROM purchases WHERE id = $1`
+	return scanPurchase(s.db.QueryRow(qs, id))
+}
+
+const purchaseColumns = `id, date, nok, account, category, location, vendor, raw_vendor, notes, ` +
+	`ARRAY(SELECT tag FROM purchase_tags WHERE purchase_id = purchases.id ORDER BY tag), ` +
+	`ARRAY(SELECT category FROM purchase_splits WHERE purchase_id = purchases.id ORDER BY part), ` +
+	`ARRAY(SELECT nok FROM purchase_splits WHERE purchase_id = purchases.id ORDER BY part), ` +
+	`ARRAY(SELECT person FROM purchase_shares WHERE purchase_id = purchases.id ORDER BY person), ` +
+	`ARRAY(SELECT weight FROM purchase_shares WHERE purchase_id = purchases.id ORDER BY person), ` +
+	`COALESCE((SELECT person FROM purchase_payers WHERE purchase_id = purchases.id), ` +
+	`(SELECT person FROM account_payers WHERE account = purchases.account), '')`
 
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPurchase reads a row selected with purchaseColumns.
+func scanPurchase(row scanner) (*models.Purchase, error) {
 	var p models.Purchase
-	var dateStr string
-	if err := row.Scan(&p.ID, &dateStr, &p.NOK, &p.Account, &p.Category, &p.Location, &p.Vendor); err != nil {
+	var date time.Time
+	var tags, splitCategories, sharePeople pq.StringArray
+	var splitNOK, shareWeights pq.Int64Array
+	if err := row.Scan(&p.ID, &date, &p.NOK, &p.Account, &p.Category, &p.Location, &p.Vendor, &p.RawVendor,
+		&p.Notes, &tags, &splitCategories, &splitNOK, &sharePeople, &shareWeights, &p.Payer); err != nil {
 		return nil, err
 	}
-
-	d, err := time.Parse(dateLayout, dateStr)
-	if err != nil {
-		return nil, err
+	p.Date = models.DateFromTime(date)
+	p.Tags = tags
+	p.Splits = []*models.Split{}
+	for i := range splitCategories {
+		p.Splits = append(p.Splits, &models.Split{Category: splitCategories[i], NOK: int(splitNOK[i])})
+	}
+	p.Shares = []*models.Share{}
+	for i := range sharePeople {
+		p.Shares = append(p.Shares, &models.Share{Person: sharePeople[i], Weight: int(shareWeights[i])})
 	}
-	p.Date = models.Date{Year: d.Year(), Month: d.Month(), MonthNum: int(d.Month()), Day: d.Day()}
 	return &p, nil
 }
 
 // DeletePurchase deletes a purchase from storage.
 func (s *Storage) DeletePurchase(id string) error {
-	res, err := s.db.Exec(fmt.Sprintf(`DELETE FROM purchases WHERE id = '%s'`, id))
-	changedRows, _ := res.RowsAffected()
-	if changedRows < 1 {
+	res, err := s.db.Exec(`DELETE FROM purchases WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if changedRows, _ := res.RowsAffected(); changedRows < 1 {
 		return ErrNotFound
 	}
-	return err
+	return nil
 }
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// Query selects a page of purchases matching a filter.
+type Query struct {
+	Filter
+
+	// Sort is the field to sort by: date, nok, account, category, location
+	// or vendor. Prefix it with a - to sort in descending order. Defaults to
+	// -date.
+	Sort string
+
+	// Limit is the maximum number of purchases returned. Defaults to 100.
+	Limit int
+
+	// Cursor is the NextCursor of the previous page, if any.
+	Cursor string
+}
+
+// cursor points at the last purchase of a page. Pages are read using the
+// sort value and ID of that purchase rather than an offset so that they
+// don't shift as purchases are added.
+type cursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func (c cursor) String() string {
+	bs, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func parseCursor(s string) (cursor, error) {
+	var c cursor
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if err := json.Unmarshal(bs, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return c, nil
+}
+
+// sortValue returns the value of the given sort column for p.
+func sortValue(p *models.Purchase, column string) string {
+	switch column {
+	case "nok":
+		return strconv.Itoa(p.NOK)
+	case "account":
+		return p.Account
+	case "category":
+		return p.Category
+	case "location":
+		return p.Location
+	case "vendor":
+		return p.Vendor
+	}
+	return p.Date.Stamp()
+}
+
+// QueryPurchases returns the page of purchases described by q, along with
+// the total number of purchases matching its filter.
+func (s *Storage) QueryPurchases(q Query) (*models.PurchasePage, error) {
+	column, desc := strings.TrimPrefix(q.Sort, "-"), strings.HasPrefix(q.Sort, "-")
+	if q.Sort == "" {
+		column, desc = "date", true
+	}
+	switch column {
+	case "date", "nok", "account", "category", "location", "vendor":
+	default:
+		return nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, column)
+	}
+	if q.Limit < 1 {
+		q.Limit = defaultQueryLimit
+	}
+	if q.Limit > maxQueryLimit {
+		return nil, fmt.Errorf("%w: limit may not exceed %d", ErrInvalidQuery, maxQueryLimit)
+	}
+
+	where := q.Filter.where(purchasesTable)
+	page := models.PurchasePage{Purchases: []*models.Purchase{}}
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM purchases`+where.String(), where.args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		c, err := parseCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where.add(fmt.Sprintf("(%s, id) %s (?, ?)", column, comparison), c.Value, c.ID)
+	}
+
+	// read one extra row to find out whether there is a next page
+	query := fmt.Sprintf(`SELECT %s FROM purchases%s ORDER BY %s %s, id %s LIMIT %d`,
+		purchaseColumns, where, column, direction, direction, q.Limit+1)
+	rows, err := s.db.Query(query, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPurchase(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Purchases = append(page.Purchases, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Purchases) > q.Limit {
+		page.Purchases = page.Purchases[:q.Limit]
+		last := page.Purchases[q.Limit-1]
+		page.NextCursor = cursor{Value: sortValue(last, column), ID: last.ID}.String()
+	}
+	return &page, nil
+}
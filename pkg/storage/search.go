@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// maxSearchTerms is how many words of a search are used.
+const maxSearchTerms = 10
+
+// Search finds the purchases matching the filter whose vendor, either as
+// cleaned up or as reported by the bank, location, category, notes or tags
+// contain words starting with every word of the text, best matches first. It
+// uses postgres' full-text search on the search column of the purchases,
+// which is the only backend there is.
+func (s *Storage) Search(text string, f Filter, limit int) ([]*models.SearchResult, error) {
+	terms := searchTerms(text)
+	if len(terms) < 1 {
+		return nil, fmt.Errorf("%w: nothing to search for", ErrInvalidQuery)
+	}
+	if limit < 1 {
+		limit = defaultQueryLimit
+	} else if limit > maxQueryLimit {
+		return nil, fmt.Errorf("%w: limit must be at most %d", ErrInvalidQuery, maxQueryLimit)
+	}
+
+	// terms only hold letters and digits, so they can't break the query
+	var prefixes []string
+	for _, t := range terms {
+		prefixes = append(prefixes, t+":*")
+	}
+	where := f.where(purchasesTable)
+	where.add("search @@ to_tsquery('simple', ?)", strings.Join(prefixes, " & "))
+	rank := fmt.Sprintf("ts_rank(search, to_tsquery('simple', $%d))", len(where.args))
+	query := fmt.Sprintf(`SELECT %s, %s AS rank FROM purchases%s ORDER BY rank DESC, date DESC, id LIMIT $%d`,
+		purchaseColumns, rank, where, len(where.args)+1)
+
+	rows, err := s.db.Query(query, append(where.args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.SearchResult{}
+	for rows.Next() {
+		var r models.SearchResult
+		if r.Purchase, err = scanPurchase(rankedRow{rows, &r.Rank}); err != nil {
+			return nil, err
+		}
+		r.Highlights = highlights(r.Purchase, terms)
+		res = append(res, &r)
+	}
+	return res, rows.Err()
+}
+
+// rankedRow reads the rank selected after the purchase columns.
+type rankedRow struct {
+	rows *sql.Rows
+	rank *float64
+}
+
+func (r rankedRow) Scan(dest ...interface{}) error {
+	return r.rows.Scan(append(dest, r.rank)...)
+}
+
+// searchTerms splits a search into lower case words.
+func searchTerms(text string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), notWordRune) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		res = append(res, word)
+		if len(res) == maxSearchTerms {
+			break
+		}
+	}
+	return res
+}
+
+func notWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// highlights returns the fields of a purchase which have words starting with
+// one of the terms, highlighted.
+func highlights(p *models.Purchase, terms []string) map[string]string {
+	res := make(map[string]string)
+	fields := map[string]string{
+		"vendor":     p.Vendor,
+		"raw_vendor": p.RawVendor,
+		"location":   p.Location,
+		"category":   p.Category,
+		"notes":      p.Notes,
+	}
+	for name, text := range fields {
+		if hl, ok := highlight(text, terms); ok {
+			res[name] = hl
+		}
+	}
+	var tags []string
+	matched := false
+	for _, tag := range p.Tags {
+		hl, ok := highlight(tag, terms)
+		tags = append(tags, hl)
+		matched = matched || ok
+	}
+	if matched {
+		res["tags"] = strings.Join(tags, ", ")
+	}
+	return res
+}
+
+// highlight HTML escapes the text, wrapping the words starting with one of
+// the terms in <mark>. It returns whether any did.
+func highlight(text string, terms []string) (string, bool) {
+	var b strings.Builder
+	matched := false
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && !notWordRune(runes[j]) {
+			j++
+		}
+		if j == i {
+			b.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+		word := string(runes[i:j])
+		if hasTermPrefix(strings.ToLower(word), terms) {
+			b.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+			matched = true
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		i = j
+	}
+	return b.String(), matched
+}
+
+func hasTermPrefix(word string, terms []string) bool {
+	for _, t := range terms {
+		if strings.HasPrefix(word, t) {
+			return true
+		}
+	}
+	return false
+}
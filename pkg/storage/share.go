@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// SetShares replaces the shares of a purchase. Setting no shares means the
+// purchase is no longer shared.
+func (s *Storage) SetShares(id string, shares []*models.Share) error {
+	if err := s.purchaseExists(id); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM purchase_shares WHERE purchase_id = $1`, id); err != nil {
+		return err
+	}
+	for _, sh := range shares {
+		if _, err := tx.Exec(`INSERT INTO purchase_shares(purchase_id, person, weight) VALUES ($1, $2, $3)`,
+			id, sh.Person, sh.Weight); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetPayer assigns the person who paid for a purchase, overriding the payer
+// of its account. An empty person removes the assignment.
+func (s *Storage) SetPayer(id, person string) error {
+	if err := s.purchaseExists(id); err != nil {
+		return err
+	}
+	if person == "" {
+		_, err := s.db.Exec(`DELETE FROM purchase_payers WHERE purchase_id = $1`, id)
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO purchase_payers(purchase_id, person) VALUES ($1, $2) `+
+		`ON CONFLICT (purchase_id) DO UPDATE SET person = EXCLUDED.person`, id, person)
+	return err
+}
+
+// AccountPayers retreives the payers of every account which has one, limited
+// to the accounts visible through the access.
+func (s *Storage) AccountPayers(access *Access) ([]*models.AccountPayer, error) {
+	var w whereClause
+	if access != nil {
+		cond, args := access.condition("account_payers")
+		w.add(cond, args...)
+	}
+	rows, err := s.db.Query(`SELECT account, person FROM account_payers`+w.String()+` ORDER BY account`, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.AccountPayer{}
+	for rows.Next() {
+		var ap models.AccountPayer
+		if err := rows.Scan(&ap.Account, &ap.Person); err != nil {
+			return nil, err
+		}
+		res = append(res, &ap)
+	}
+	return res, rows.Err()
+}
+
+// SetAccountPayer sets the person paying for purchases made from an account,
+// returning ErrNotFound unless the account is visible through the access.
+func (s *Storage) SetAccountPayer(ap *models.AccountPayer, access *Access) error {
+	w := Filter{Account: ap.Account, Access: access}.where(purchasesTable)
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM purchases`+w.String()+`)`, w.args...).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	_, err = s.db.Exec(`INSERT INTO account_payers(account, person) VALUES ($1, $2) `+
+		`ON CONFLICT (account) DO UPDATE SET person = EXCLUDED.person`, ap.Account, ap.Person)
+	return err
+}
+
+// Settlements retreives the settlements visible through the access, latest
+// first.
+func (s *Storage) Settlements(access *Access) ([]*models.Settlement, error) {
+	var w whereClause
+	if access != nil {
+		cond, args := access.ownerCondition("settlements")
+		w.add(cond, args...)
+	}
+	rows, err := s.db.Query(`SELECT id, date, from_person, to_person, nok, COALESCE(username, ''), household_id `+
+		`FROM settlements`+w.String()+` ORDER BY date DESC, id DESC`, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.Settlement{}
+	for rows.Next() {
+		var st models.Settlement
+		var date time.Time
+		var household sql.NullInt64
+		if err := rows.Scan(&st.ID, &date, &st.From, &st.To, &st.NOK, &st.Owner, &household); err != nil {
+			return nil, err
+		}
+		st.Date = models.DateFromTime(date)
+		if household.Valid {
+			id := int(household.Int64)
+			st.Household = &id
+		}
+		res = append(res, &st)
+	}
+	return res, rows.Err()
+}
+
+// AddSettlement saves a settlement, setting its ID and owner. Settlements
+// belong to the given household, which the user has to be a member of. If
+// none is given they belong to the user's household, or to the user if they
+// aren't in exactly one.
+func (s *Storage) AddSettlement(st *models.Settlement, access *Access) error {
+	st.Owner = ""
+	if access != nil {
+		var households []int
+		rows, err := s.db.Query(`SELECT household_id FROM household_members WHERE username = $1`, access.Username)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			households = append(households, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		switch {
+		case st.Household != nil && !containsInt(households, *st.Household):
+			return ErrNotFound
+		case st.Household == nil && len(households) == 1:
+			st.Household = &households[0]
+		case st.Household == nil:
+			st.Owner = access.Username
+		}
+	}
+	var owner *string
+	if st.Owner != "" {
+		owner = &st.Owner
+	}
+	return s.db.QueryRow(`INSERT INTO settlements(date, from_person, to_person, nok, username, household_id) `+
+		`VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		st.Date.Stamp(), st.From, st.To, st.NOK, owner, st.Household).Scan(&st.ID)
+}
+
+func containsInt(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteSettlement deletes a settlement, returning ErrNotFound unless it is
+// visible through the access.
+func (s *Storage) DeleteSettlement(id int, access *Access) error {
+	var w whereClause
+	w.add("id = ?", id)
+	if access != nil {
+		cond, args := access.ownerCondition("settlements")
+		w.add(cond, args...)
+	}
+	res, err := s.db.Exec(`DELETE FROM settlements`+w.String(), w.args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
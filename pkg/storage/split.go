@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// SetSplits replaces the parts a purchase is split into. The parts must sum
+// up to the amount of the purchase. Setting no parts removes the split.
+func (s *Storage) SetSplits(id string, splits []*models.Split) error {
+	p, err := s.GetPurchase(id)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if len(splits) == 1 {
+		return fmt.Errorf("%w: a purchase must be split into at least two parts", ErrInvalidSplit)
+	}
+	sum := 0
+	for _, sp := range splits {
+		if sp.Category == "" || sp.NOK <= 0 {
+			return fmt.Errorf("%w: every part needs a category and a positive amount", ErrInvalidSplit)
+		}
+		sum += sp.NOK
+	}
+	if len(splits) > 0 && sum != p.NOK {
+		return fmt.Errorf("%w: parts sum up to %d NOK but the purchase was %d NOK", ErrInvalidSplit, sum, p.NOK)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM purchase_splits WHERE purchase_id = $1`, id); err != nil {
+		return err
+	}
+	for i, sp := range splits {
+		if _, err := tx.Exec(`INSERT INTO purchase_splits(purchase_id, part, category, nok) VALUES ($1, $2, $3, $4)`,
+			id, i, sp.Category, sp.NOK); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
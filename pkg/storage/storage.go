@@ -1,18 +1,21 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"time"
 
-	"github.com/kelseyhightower/envconfig"
+	"github.com/j18e/sbanken-client/pkg/config"
 	_ "github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound     = errors.New("not found")
+	ErrInvalidQuery = errors.New("invalid query")
+	ErrInvalidSplit = errors.New("invalid split")
 )
 
 const TABLE_SCHEMA = `CREATE TABLE IF NOT EXISTS purchases ( ` +
@@ -25,20 +28,219 @@ const TABLE_SCHEMA = `CREATE TABLE IF NOT EXISTS purchases ( ` +
 	`account  TEXT NOT NULL ` +
 	`)`
 
+// migrations are applied in order after TABLE_SCHEMA every time the storage
+// is opened, so each of them must be safe to run repeatedly.
+var migrations = []string{
+	// keep the merchant name from the bank next to the normalized vendor
+	`ALTER TABLE purchases ADD COLUMN IF NOT EXISTS raw_vendor TEXT NOT NULL DEFAULT ''`,
+	`UPDATE purchases SET raw_vendor = vendor WHERE raw_vendor = ''`,
+	`CREATE TABLE IF NOT EXISTS vendor_aliases ( ` +
+		`raw    TEXT PRIMARY KEY, ` +
+		`vendor TEXT NOT NULL ` +
+		`)`,
+	// tags and notes added by users
+	`ALTER TABLE purchases ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS purchase_tags ( ` +
+		`purchase_id TEXT NOT NULL REFERENCES purchases(id) ON DELETE CASCADE, ` +
+		`tag         TEXT NOT NULL, ` +
+		`PRIMARY KEY (purchase_id, tag) ` +
+		`)`,
+	`CREATE INDEX IF NOT EXISTS purchase_tags_tag ON purchase_tags (tag)`,
+	// purchases split into parts with their own categories
+	`CREATE TABLE IF NOT EXISTS purchase_splits ( ` +
+		`purchase_id TEXT NOT NULL REFERENCES purchases(id) ON DELETE CASCADE, ` +
+		`part        INT  NOT NULL, ` +
+		`category    TEXT NOT NULL, ` +
+		`nok         INT  NOT NULL, ` +
+		`PRIMARY KEY (purchase_id, part) ` +
+		`)`,
+	`DROP VIEW IF EXISTS ` + spendingView,
+	`CREATE VIEW ` + spendingView + ` AS ` +
+		`SELECT id, date, nok, account, category, location, vendor, notes FROM purchases ` +
+		`WHERE NOT EXISTS (SELECT 1 FROM purchase_splits WHERE purchase_id = purchases.id) ` +
+		`UNION ALL ` +
+		`SELECT p.id, p.date, s.nok, p.account, s.category, p.location, p.vendor, p.notes ` +
+		`FROM purchases p JOIN purchase_splits s ON s.purchase_id = p.id`,
+	// expenses shared within the household
+	`CREATE TABLE IF NOT EXISTS purchase_shares ( ` +
+		`purchase_id TEXT NOT NULL REFERENCES purchases(id) ON DELETE CASCADE, ` +
+		`person      TEXT NOT NULL, ` +
+		`weight      INT  NOT NULL CHECK (weight > 0), ` +
+		`PRIMARY KEY (purchase_id, person) ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS purchase_payers ( ` +
+		`purchase_id TEXT PRIMARY KEY REFERENCES purchases(id) ON DELETE CASCADE, ` +
+		`person      TEXT NOT NULL ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS account_payers ( ` +
+		`account TEXT PRIMARY KEY, ` +
+		`person  TEXT NOT NULL ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS settlements ( ` +
+		`id          SERIAL PRIMARY KEY, ` +
+		`date        DATE NOT NULL, ` +
+		`from_person TEXT NOT NULL, ` +
+		`to_person   TEXT NOT NULL, ` +
+		`nok         INT  NOT NULL CHECK (nok > 0) ` +
+		`)`,
+	// subscriptions which have already been notified about
+	`CREATE TABLE IF NOT EXISTS known_subscriptions ( ` +
+		`vendor     TEXT PRIMARY KEY, ` +
+		`first_seen DATE NOT NULL DEFAULT CURRENT_DATE ` +
+		`)`,
+	// users, their login sessions and api tokens
+	`CREATE TABLE IF NOT EXISTS users ( ` +
+		`username      TEXT PRIMARY KEY, ` +
+		`password_hash TEXT NOT NULL DEFAULT '' ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS sessions ( ` +
+		`token_hash TEXT PRIMARY KEY, ` +
+		`username   TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE, ` +
+		`csrf_token TEXT NOT NULL, ` +
+		`expires    TIMESTAMPTZ NOT NULL ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS api_tokens ( ` +
+		`id         SERIAL PRIMARY KEY, ` +
+		`token_hash TEXT NOT NULL UNIQUE, ` +
+		`username   TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE, ` +
+		`name       TEXT NOT NULL, ` +
+		`created    TIMESTAMPTZ NOT NULL DEFAULT NOW(), ` +
+		`last_used  TIMESTAMPTZ ` +
+		`)`,
+	// users who logged in before roles existed had access to everything
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'admin'`,
+	`ALTER TABLE users ALTER COLUMN role SET DEFAULT 'viewer'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS budget INTEGER`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS pushover_user TEXT NOT NULL DEFAULT ''`,
+	// households and who owns which account
+	`CREATE TABLE IF NOT EXISTS households ( ` +
+		`id     SERIAL PRIMARY KEY, ` +
+		`name   TEXT NOT NULL UNIQUE, ` +
+		`budget INTEGER ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS household_members ( ` +
+		`household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE, ` +
+		`username     TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE, ` +
+		`PRIMARY KEY (household_id, username) ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS account_owners ( ` +
+		`account      TEXT PRIMARY KEY, ` +
+		`username     TEXT REFERENCES users(username) ON DELETE CASCADE, ` +
+		`household_id INTEGER REFERENCES households(id) ON DELETE CASCADE, ` +
+		`CHECK ((username IS NULL) <> (household_id IS NULL)) ` +
+		`)`,
+	// subscriptions are known per user, the instance wide ones have no user
+	`ALTER TABLE known_subscriptions ADD COLUMN IF NOT EXISTS username TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE known_subscriptions DROP CONSTRAINT IF EXISTS known_subscriptions_pkey`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS known_subscriptions_user_vendor ON known_subscriptions (username, vendor)`,
+	// syncs with the bank and how each account fared
+	`CREATE TABLE IF NOT EXISTS sync_runs ( ` +
+		`id          SERIAL PRIMARY KEY, ` +
+		`trigger     TEXT NOT NULL, ` +
+		`started     TIMESTAMPTZ NOT NULL, ` +
+		`finished    TIMESTAMPTZ, ` +
+		`error       TEXT NOT NULL DEFAULT '', ` +
+		`auth_failed BOOLEAN NOT NULL DEFAULT FALSE ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS sync_accounts ( ` +
+		`run_id     INTEGER NOT NULL REFERENCES sync_runs(id) ON DELETE CASCADE, ` +
+		`account    TEXT NOT NULL, ` +
+		`fetched    INTEGER NOT NULL, ` +
+		`added      INTEGER NOT NULL, ` +
+		`duplicates INTEGER NOT NULL, ` +
+		`error      TEXT NOT NULL DEFAULT '', ` +
+		`PRIMARY KEY (run_id, account) ` +
+		`)`,
+	`ALTER TABLE sync_accounts ADD COLUMN IF NOT EXISTS updated INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS sync_purchases ( ` +
+		`run_id      INTEGER NOT NULL, ` +
+		`account     TEXT NOT NULL, ` +
+		`purchase_id TEXT NOT NULL, ` +
+		`PRIMARY KEY (run_id, account, purchase_id), ` +
+		`FOREIGN KEY (run_id, account) REFERENCES sync_accounts(run_id, account) ON DELETE CASCADE ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS webhooks ( ` +
+		`id      SERIAL PRIMARY KEY, ` +
+		`url     TEXT NOT NULL, ` +
+		`secret  TEXT NOT NULL, ` +
+		`events  TEXT[] NOT NULL, ` +
+		`active  BOOLEAN NOT NULL DEFAULT TRUE, ` +
+		`created TIMESTAMPTZ NOT NULL ` +
+		`)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries ( ` +
+		`id          SERIAL PRIMARY KEY, ` +
+		`webhook_id  INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE, ` +
+		`event_id    TEXT NOT NULL, ` +
+		`event       TEXT NOT NULL, ` +
+		`payload     TEXT NOT NULL, ` +
+		`attempt     INTEGER NOT NULL, ` +
+		`status      INTEGER NOT NULL, ` +
+		`error       TEXT NOT NULL DEFAULT '', ` +
+		`time        TIMESTAMPTZ NOT NULL, ` +
+		`duration_ms INTEGER NOT NULL ` +
+		`)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id)`,
+	`CREATE TABLE IF NOT EXISTS budget_alerts ( ` +
+		`username TEXT NOT NULL, ` +
+		`month    DATE NOT NULL, ` +
+		`PRIMARY KEY (username, month) ` +
+		`)`,
+	// settlements belong to a household or a user like accounts do, the
+	// ones recorded before have neither and only admins see them
+	`ALTER TABLE settlements ADD COLUMN IF NOT EXISTS username TEXT REFERENCES users(username) ON DELETE CASCADE`,
+	`ALTER TABLE settlements ADD COLUMN IF NOT EXISTS household_id INTEGER REFERENCES households(id) ON DELETE CASCADE`,
+	// the full-text search document of each purchase, made up of its vendor
+	// as cleaned up and as reported by the bank, location, category, notes
+	// and tags. Triggers keep it up to date, setting it to NULL recomputes
+	// it. The simple configuration is used since the text is a mix of
+	// Norwegian and English names which stemming would only mangle.
+	`ALTER TABLE purchases ADD COLUMN IF NOT EXISTS search TSVECTOR`,
+	`CREATE OR REPLACE FUNCTION purchases_search() RETURNS trigger AS $$ BEGIN ` +
+		`NEW.search := to_tsvector('simple', NEW.vendor || ' ' || NEW.raw_vendor || ' ' || NEW.location || ' ' || ` +
+		`NEW.category || ' ' || NEW.notes || ' ' || ` +
+		`COALESCE((SELECT string_agg(tag, ' ') FROM purchase_tags WHERE purchase_id = NEW.id), '')); ` +
+		`RETURN NEW; ` +
+		`END $$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS purchases_search ON purchases`,
+	`CREATE TRIGGER purchases_search BEFORE INSERT OR UPDATE ON purchases ` +
+		`FOR EACH ROW EXECUTE PROCEDURE purchases_search()`,
+	`CREATE OR REPLACE FUNCTION purchase_tags_search() RETURNS trigger AS $$ BEGIN ` +
+		`IF TG_OP <> 'INSERT' THEN UPDATE purchases SET search = NULL WHERE id = OLD.purchase_id; END IF; ` +
+		`IF TG_OP <> 'DELETE' THEN UPDATE purchases SET search = NULL WHERE id = NEW.purchase_id; END IF; ` +
+		`RETURN NULL; ` +
+		`END $$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS purchase_tags_search ON purchase_tags`,
+	`CREATE TRIGGER purchase_tags_search AFTER INSERT OR UPDATE OR DELETE ON purchase_tags ` +
+		`FOR EACH ROW EXECUTE PROCEDURE purchase_tags_search()`,
+	`UPDATE purchases SET search = NULL WHERE search IS NULL`,
+	`CREATE INDEX IF NOT EXISTS purchases_search_idx ON purchases USING GIN (search)`,
+	// the single sign-on identities users log in with, by the provider's
+	// issuer and its subject identifier for the user, which unlike their
+	// names can't be taken over by someone else at the provider
+	`CREATE TABLE IF NOT EXISTS oidc_identities ( ` +
+		`issuer   TEXT NOT NULL, ` +
+		`subject  TEXT NOT NULL, ` +
+		`username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE, ` +
+		`linked   TIMESTAMPTZ NOT NULL DEFAULT NOW(), ` +
+		`PRIMARY KEY (issuer, subject), ` +
+		`UNIQUE (issuer, username) ` +
+		`)`,
+}
+
+const (
+	purchasesTable = "purchases"
+
+	// spendingView has a row for each purchase, except for split purchases
+	// which have a row for each of their parts instead. Totals are computed
+	// from it rather than from the purchases.
+	spendingView = "spending"
+)
+
 // NewStorage opens and tests a new connection to the storage backend,
 // initializing the schema in the process.
-func NewStorage() *Storage {
+func NewStorage(conf config.Database) (*Storage, error) {
 	const connStrTpl = "host=%s port=5432 user=%s password=%s dbname=%s sslmode=disable"
-	var conf struct {
-		DBUser     string `required:"true" envconfig:"DB_USER"`
-		DBPassword string `required:"true" envconfig:"DB_PASSWORD"`
-		DBHost     string `default:"localhost" envconfig:"DB_HOST"`
-		DBName     string `default:"sbanken-client" envconfig:"DB_NAME"`
-	}
-	if err := envconfig.Process("", &conf); err != nil {
-		log.Fatal(err)
-	}
-	connStr := fmt.Sprintf(connStrTpl, conf.DBHost, conf.DBUser, conf.DBPassword, conf.DBName)
+	connStr := fmt.Sprintf(connStrTpl, conf.Host, conf.User, conf.Password, conf.Name)
 
 	var err error
 	var db *sql.DB
@@ -53,14 +255,24 @@ func NewStorage() *Storage {
 		}
 	}
 	if err != nil {
-		log.Fatalf("connecting to database: %v", err)
+		return nil, fmt.Errorf("connecting to database: %w", err)
 	}
 	if _, err := db.Exec(TABLE_SCHEMA); err != nil {
-		log.Fatalf("applying the schema: %v", err)
+		return nil, fmt.Errorf("applying the schema: %w", err)
+	}
+	for _, m := range migrations {
+		if _, err := db.Exec(m); err != nil {
+			return nil, fmt.Errorf("applying migration %q: %w", m, err)
+		}
 	}
-	return &Storage{db}
+	return &Storage{timedDB{db}}, nil
 }
 
 type Storage struct {
-	db *sql.DB
+	db timedDB
+}
+
+// Ping checks that the database can be reached.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
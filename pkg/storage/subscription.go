@@ -0,0 +1,29 @@
+package storage
+
+// KnownSubscriptions returns the vendors of the subscriptions which have been
+// seen before by a user, or instance wide if username is empty.
+func (s *Storage) KnownSubscriptions(username string) (map[string]bool, error) {
+	rows, err := s.db.Query(`SELECT vendor FROM known_subscriptions WHERE username = $1`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]bool)
+	for rows.Next() {
+		var vendor string
+		if err := rows.Scan(&vendor); err != nil {
+			return nil, err
+		}
+		res[vendor] = true
+	}
+	return res, rows.Err()
+}
+
+// AddKnownSubscription records that a user has seen the subscription to a
+// vendor.
+func (s *Storage) AddKnownSubscription(username, vendor string) error {
+	_, err := s.db.Exec(`INSERT INTO known_subscriptions(username, vendor) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		username, vendor)
+	return err
+}
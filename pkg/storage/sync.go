@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/lib/pq"
+)
+
+// StartSyncRun records that a sync has started, setting its ID.
+func (s *Storage) StartSyncRun(run *models.SyncRun) error {
+	return s.db.QueryRow(`INSERT INTO sync_runs(trigger, started) VALUES ($1, $2) RETURNING id`,
+		run.Trigger, run.Started).Scan(&run.ID)
+}
+
+// FinishSyncRun records how a sync went, along with the outcome of each
+// account.
+func (s *Storage) FinishSyncRun(run *models.SyncRun) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE sync_runs SET finished = $1, error = $2, auth_failed = $3 WHERE id = $4`,
+		run.Finished, run.Error, run.AuthFailed, run.ID); err != nil {
+		return err
+	}
+	for _, a := range run.Accounts {
+		if _, err := tx.Exec(`INSERT INTO sync_accounts(run_id, account, fetched, added, updated, duplicates, error) `+
+			`VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			run.ID, a.Account, a.Fetched, a.Added, a.Updated, a.Duplicates, a.Error); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO sync_purchases(run_id, account, purchase_id) `+
+			`SELECT $1, $2, UNNEST($3::TEXT[])`, run.ID, a.Account, pq.StringArray(a.New)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AbandonSyncRuns marks syncs which never finished, because the program
+// stopped while they were running, as failed.
+func (s *Storage) AbandonSyncRuns() error {
+	_, err := s.db.Exec(`UPDATE sync_runs SET finished = started, error = 'interrupted' WHERE finished IS NULL`)
+	return err
+}
+
+// SyncRuns retreives the latest syncs, newest first.
+func (s *Storage) SyncRuns(limit int) ([]*models.SyncRun, error) {
+	return s.syncRuns(`ORDER BY id DESC LIMIT $1`, limit)
+}
+
+// SyncRunsSince retreives the finished syncs after the given one along with
+// up to the given number of finished syncs before it, newest first.
+// Backfills are left out, since they load another period than the other
+// syncs and their outcome says nothing about whether those keep working.
+func (s *Storage) SyncRunsSince(id, before int) ([]*models.SyncRun, error) {
+	return s.syncRuns(`WHERE finished IS NOT NULL AND trigger <> $1 AND (id > $2 OR id IN `+
+		`(SELECT id FROM sync_runs WHERE finished IS NOT NULL AND trigger <> $1 AND id <= $2 `+
+		`ORDER BY id DESC LIMIT $3)) ORDER BY id DESC`, models.SyncBackfill, id, before)
+}
+
+// SyncRun retreives one sync.
+func (s *Storage) SyncRun(id int) (*models.SyncRun, error) {
+	runs, err := s.syncRuns(`WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) < 1 {
+		return nil, ErrNotFound
+	}
+	return runs[0], nil
+}
+
+func (s *Storage) syncRuns(clause string, args ...interface{}) ([]*models.SyncRun, error) {
+	rows, err := s.db.Query(`SELECT id, trigger, started, finished, error, auth_failed FROM sync_runs `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.SyncRun{}
+	byID := make(map[int]*models.SyncRun)
+	var ids []int64
+	for rows.Next() {
+		var run models.SyncRun
+		var finished pq.NullTime
+		if err := rows.Scan(&run.ID, &run.Trigger, &run.Started, &finished, &run.Error, &run.AuthFailed); err != nil {
+			return nil, err
+		}
+		if finished.Valid {
+			run.Finished = &finished.Time
+		}
+		run.Accounts = []*models.AccountSync{}
+		res = append(res, &run)
+		byID[run.ID] = &run
+		ids = append(ids, int64(run.ID))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) < 1 {
+		return res, nil
+	}
+
+	rows, err = s.db.Query(`SELECT run_id, account, fetched, added, updated, duplicates, error, `+
+		`ARRAY(SELECT purchase_id FROM sync_purchases p `+
+		`WHERE p.run_id = a.run_id AND p.account = a.account ORDER BY purchase_id) `+
+		`FROM sync_accounts a WHERE run_id = ANY($1) ORDER BY account`, pq.Int64Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var a models.AccountSync
+		var newIDs pq.StringArray
+		if err := rows.Scan(&id, &a.Account, &a.Fetched, &a.Added, &a.Updated, &a.Duplicates, &a.Error,
+			&newIDs); err != nil {
+			return nil, err
+		}
+		a.New = newIDs
+		byID[id].Accounts = append(byID[id].Accounts, &a)
+	}
+	return res, rows.Err()
+}
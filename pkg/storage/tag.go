@@ -0,0 +1,47 @@
+package storage
+
+// purchaseExists returns ErrNotFound if there is no purchase with the ID.
+func (s *Storage) purchaseExists(id string) error {
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM purchases WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddTag tags a purchase. Adding a tag the purchase already has does
+// nothing.
+func (s *Storage) AddTag(id, tag string) error {
+	if err := s.purchaseExists(id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO purchase_tags(purchase_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, tag)
+	return err
+}
+
+// RemoveTag removes a tag from a purchase.
+func (s *Storage) RemoveTag(id, tag string) error {
+	res, err := s.db.Exec(`DELETE FROM purchase_tags WHERE purchase_id = $1 AND tag = $2`, id, tag)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetNotes replaces the notes of a purchase.
+func (s *Storage) SetNotes(id, notes string) error {
+	res, err := s.db.Exec(`UPDATE purchases SET notes = $1 WHERE id = $2`, notes, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// groupExprs maps the fields purchases can be grouped by to the SQL
+// expressions producing their values.
+var groupExprs = map[string]string{
+	"category": "category",
+	"vendor":   "vendor",
+	"account":  "account",
+	"location": "location",
+	"day":      "to_char(date, 'YYYY-MM-DD')",
+	"week":     `to_char(date, 'IYYY-"W"IW')`,
+	"month":    "to_char(date, 'YYYY-MM')",
+	"year":     "to_char(date, 'YYYY')",
+	"tag":      "purchase_tags.tag",
+}
+
+// countPurchases counts the purchases in the spending view rather than the
+// parts of split ones.
+const countPurchases = "COUNT(DISTINCT " + spendingView + ".id)"
+
+// GroupFields lists the fields purchases can be grouped by.
+var GroupFields = []string{"category", "vendor", "account", "location", "day", "week", "month", "year", "tag"}
+
+// Totals sums up the spending matching the filter, grouped by the given
+// fields. Without any fields a single total of all the purchases is returned.
+// The parts of split purchases are summed up separately, but the purchase is
+// only counted once in each group. Purchases with several tags
+// count towards each of them when grouping by tag, and purchases without tags
+// are left out.
+func (s *Storage) Totals(f Filter, groupBy ...string) ([]*models.Total, error) {
+	var exprs []string
+	from := spendingView
+	for _, field := range groupBy {
+		if field == "tag" {
+			from = spendingView + " JOIN purchase_tags ON purchase_tags.purchase_id = spending.id"
+		}
+		expr, ok := groupExprs[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot group by %q - must be one of %v", ErrInvalidQuery, field, GroupFields)
+		}
+		exprs = append(exprs, expr)
+	}
+
+	where := f.where(spendingView)
+	query := `SELECT COALESCE(SUM(nok), 0), ` + countPurchases
+	if len(exprs) > 0 {
+		cols := strings.Join(exprs, ", ")
+		query = fmt.Sprintf(`SELECT %s, SUM(nok), `+countPurchases+` FROM %s%s GROUP BY %s ORDER BY %s`,
+			cols, from, where, cols, cols)
+	} else {
+		query += ` FROM ` + spendingView + where.String()
+	}
+	rows, err := s.db.Query(query, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.Total{}
+	for rows.Next() {
+		keys := make([]string, len(groupBy))
+		dest := make([]interface{}, 0, len(groupBy)+2)
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		t := models.Total{Group: make(map[string]string)}
+		if err := rows.Scan(append(dest, &t.NOK, &t.Count)...); err != nil {
+			return nil, err
+		}
+		for i, field := range groupBy {
+			t.Group[field] = keys[i]
+		}
+		res = append(res, &t)
+	}
+	return res, rows.Err()
+}
+
+// Total sums up the purchases matching the filter.
+func (s *Storage) Total(f Filter) (int, error) {
+	totals, err := s.Totals(f)
+	if err != nil {
+		return 0, err
+	}
+	return totals[0].NOK, nil
+}
+
+// Stats summarizes the purchases matching the filter.
+func (s *Storage) Stats(f Filter) (*models.Stats, error) {
+	where := f.where(spendingView)
+	var st models.Stats
+	var first, last *time.Time
+	if err := s.db.QueryRow(`SELECT COALESCE(SUM(nok), 0), `+countPurchases+`, MIN(date), MAX(date) FROM `+spendingView+where.String(),
+		where.args...).Scan(&st.NOK, &st.Count, &first, &last); err != nil {
+		return nil, err
+	}
+	if st.Count < 1 {
+		return &st, nil
+	}
+
+	st.Average = st.NOK / st.Count
+	st.First, st.Last = models.DateFromTime(*first), models.DateFromTime(*last)
+	months := (st.Last.Year-st.First.Year)*12 + int(st.Last.Month-st.First.Month) + 1
+	st.PerMonth = float64(st.Count) / float64(months)
+	return &st, nil
+}
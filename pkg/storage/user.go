@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/lib/pq"
+)
+
+// AddUser creates a user with the given role. Users logging in through single
+// sign-on have no password hash.
+func (s *Storage) AddUser(username, passwordHash, role string) error {
+	_, err := s.db.Exec(`INSERT INTO users(username, password_hash, role) VALUES ($1, $2, $3)`,
+		username, passwordHash, role)
+	return err
+}
+
+const userColumns = `username, role, budget, pushover_user`
+
+func scanUser(row scanner) (*models.User, error) {
+	var u models.User
+	var budget sql.NullInt64
+	if err := row.Scan(&u.Username, &u.Role, &budget, &u.PushoverUser); err != nil {
+		return nil, err
+	}
+	if budget.Valid {
+		b := int(budget.Int64)
+		u.Budget = &b
+	}
+	return &u, nil
+}
+
+// GetUser retreives a user.
+func (s *Storage) GetUser(username string) (*models.User, error) {
+	u, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = $1`, username))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return u, err
+}
+
+// Users retreives every user.
+func (s *Storage) Users() ([]*models.User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, u)
+	}
+	return res, rows.Err()
+}
+
+// UpdateUser saves the role, budget and pushover user key of a user.
+func (s *Storage) UpdateUser(u *models.User) error {
+	res, err := s.db.Exec(`UPDATE users SET role = $1, budget = $2, pushover_user = $3 WHERE username = $4`,
+		u.Role, u.Budget, u.PushoverUser, u.Username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUser deletes a user along with their sessions, tokens and
+// memberships. Accounts they owned go back to being unowned.
+func (s *Storage) DeleteUser(username string) error {
+	res, err := s.db.Exec(`DELETE FROM users WHERE username = $1`, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Budget returns the monthly budget of a user, which is their own or else the
+// largest of their households' budgets. It returns 0 when neither is set.
+func (s *Storage) Budget(username string) (int, error) {
+	var budget int
+	err := s.db.QueryRow(`SELECT COALESCE(budget, (SELECT MAX(households.budget) FROM households `+
+		`JOIN household_members ON household_members.household_id = households.id `+
+		`WHERE household_members.username = users.username), 0) FROM users WHERE username = $1`,
+		username).Scan(&budget)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return budget, err
+}
+
+// MarkBudgetExceeded records that a budget was exceeded in the given month,
+// returning whether that hadn't already been recorded. The budget is that of
+// the given user, or MONTHLY_BUDGET when the username is empty.
+func (s *Storage) MarkBudgetExceeded(username string, month models.Date) (bool, error) {
+	month.Day = 1
+	res, err := s.db.Exec(`INSERT INTO budget_alerts(username, month) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		username, month.Time())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// PasswordHash retreives the password hash of a user.
+func (s *Storage) PasswordHash(username string) (string, error) {
+	var hash string
+	err := s.db.QueryRow(`SELECT password_hash FROM users WHERE username = $1`, username).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return hash, err
+}
+
+// SetPasswordHash replaces the password hash of a user.
+func (s *Storage) SetPasswordHash(username, passwordHash string) error {
+	res, err := s.db.Exec(`UPDATE users SET password_hash = $1 WHERE username = $2`, passwordHash, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddSession saves a login session under the hash of its token.
+func (s *Storage) AddSession(tokenHash string, sess *models.Session) error {
+	_, err := s.db.Exec(`INSERT INTO sessions(token_hash, username, csrf_token, expires) VALUES ($1, $2, $3, $4)`,
+		tokenHash, sess.Username, sess.CSRFToken, sess.Expires)
+	return err
+}
+
+// GetSession retreives the unexpired session with the given token hash.
+func (s *Storage) GetSession(tokenHash string) (*models.Session, error) {
+	var sess models.Session
+	err := s.db.QueryRow(`SELECT username, csrf_token, expires FROM sessions WHERE token_hash = $1 AND expires > NOW()`,
+		tokenHash).Scan(&sess.Username, &sess.CSRFToken, &sess.Expires)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return &sess, err
+}
+
+// DeleteSession deletes a session, along with any expired sessions.
+func (s *Storage) DeleteSession(tokenHash string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token_hash = $1 OR expires <= NOW()`, tokenHash)
+	return err
+}
+
+// AddAPIToken saves an API token under the hash of the token, setting its ID
+// and creation time.
+func (s *Storage) AddAPIToken(username, tokenHash string, tok *models.APIToken) error {
+	return s.db.QueryRow(`INSERT INTO api_tokens(token_hash, username, name) VALUES ($1, $2, $3) RETURNING id, created`,
+		tokenHash, username, tok.Name).Scan(&tok.ID, &tok.Created)
+}
+
+// APITokenUser returns the user owning the API token with the given hash,
+// marking the token as used.
+func (s *Storage) APITokenUser(tokenHash string) (string, error) {
+	var username string
+	err := s.db.QueryRow(`UPDATE api_tokens SET last_used = $1 WHERE token_hash = $2 RETURNING username`,
+		time.Now(), tokenHash).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return username, err
+}
+
+// APITokens retreives the API tokens of a user.
+func (s *Storage) APITokens(username string) ([]*models.APIToken, error) {
+	rows, err := s.db.Query(`SELECT id, name, created, last_used FROM api_tokens WHERE username = $1 ORDER BY id`,
+		username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.APIToken{}
+	for rows.Next() {
+		var tok models.APIToken
+		if err := rows.Scan(&tok.ID, &tok.Name, &tok.Created, &tok.LastUsed); err != nil {
+			return nil, err
+		}
+		res = append(res, &tok)
+	}
+	return res, rows.Err()
+}
+
+// DeleteAPIToken deletes one of a user's API tokens.
+func (s *Storage) DeleteAPIToken(username string, id int) error {
+	res, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = $1 AND username = $2`, id, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// OIDCUser retreives the user a single sign-on identity belongs to.
+func (s *Storage) OIDCUser(issuer, subject string) (string, error) {
+	var username string
+	err := s.db.QueryRow(`SELECT username FROM oidc_identities WHERE issuer = $1 AND subject = $2`,
+		issuer, subject).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return username, err
+}
+
+// AddOIDCIdentity records a single sign-on identity of a user, returning
+// false if either the identity or the user already has one from the issuer.
+func (s *Storage) AddOIDCIdentity(issuer, subject, username string) (bool, error) {
+	res, err := s.db.Exec(`INSERT INTO oidc_identities(issuer, subject, username) VALUES ($1, $2, $3) `+
+		`ON CONFLICT DO NOTHING`, issuer, subject, username)
+	if err, ok := err.(*pq.Error); ok && err.Code.Name() == "foreign_key_violation" {
+		return false, ErrNotFound
+	} else if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// LinkOIDCIdentity records a single sign-on identity of a user, replacing the
+// one they had from the issuer. It returns false if the identity belongs to
+// another user.
+func (s *Storage) LinkOIDCIdentity(issuer, subject, username string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var owner string
+	err = tx.QueryRow(`SELECT username FROM oidc_identities WHERE issuer = $1 AND subject = $2 FOR UPDATE`,
+		issuer, subject).Scan(&owner)
+	if err == nil {
+		return owner == username, nil
+	} else if err != sql.ErrNoRows {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM oidc_identities WHERE issuer = $1 AND username = $2`,
+		issuer, username); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`INSERT INTO oidc_identities(issuer, subject, username) VALUES ($1, $2, $3)`,
+		issuer, subject, username)
+	if err, ok := err.(*pq.Error); ok && err.Code.Name() == "foreign_key_violation" {
+		return false, ErrNotFound
+	} else if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// VendorAliases retreives all vendor aliases from storage.
+func (s *Storage) VendorAliases() ([]*models.VendorAlias, error) {
+	rows, err := s.db.Query(`SELECT raw, vendor FROM vendor_aliases ORDER BY vendor, raw`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.VendorAlias{}
+	for rows.Next() {
+		var a models.VendorAlias
+		if err := rows.Scan(&a.Raw, &a.Vendor); err != nil {
+			return nil, err
+		}
+		res = append(res, &a)
+	}
+	return res, rows.Err()
+}
+
+// SetVendorAlias creates or replaces the alias for a raw merchant name.
+func (s *Storage) SetVendorAlias(a *models.VendorAlias) error {
+	_, err := s.db.Exec(`INSERT INTO vendor_aliases(raw, vendor) VALUES ($1, $2) `+
+		`ON CONFLICT (raw) DO UPDATE SET vendor = EXCLUDED.vendor`, a.Raw, a.Vendor)
+	return err
+}
+
+// DeleteVendorAlias deletes the alias for a raw merchant name.
+func (s *Storage) DeleteVendorAlias(raw string) error {
+	res, err := s.db.Exec(`DELETE FROM vendor_aliases WHERE raw = $1`, raw)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RenameVendors recomputes the vendor of every stored purchase from its raw
+// merchant name and location using the given function, returning the number
+// of purchases which changed.
+func (s *Storage) RenameVendors(vendor func(raw, location string) string) (int, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT raw_vendor, location, vendor FROM purchases`)
+	if err != nil {
+		return 0, err
+	}
+	type rename struct{ raw, location, vendor string }
+	var renames []rename
+	for rows.Next() {
+		var r rename
+		var current string
+		if err := rows.Scan(&r.raw, &r.location, &current); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if r.vendor = vendor(r.raw, r.location); r.vendor != current {
+			renames = append(renames, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	changed := 0
+	for _, r := range renames {
+		res, err := tx.Exec(`UPDATE purchases SET vendor = $1 WHERE raw_vendor = $2 AND location = $3 AND vendor <> $1`,
+			r.vendor, r.raw, r.location)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		changed += int(n)
+	}
+	return changed, tx.Commit()
+}
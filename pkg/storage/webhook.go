@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/lib/pq"
+)
+
+const webhookColumns = `id, url, secret, events, active, created`
+
+func scanWebhook(row scanner) (*models.Webhook, error) {
+	var w models.Webhook
+	var events pq.StringArray
+	if err := row.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Active, &w.Created); err != nil {
+		return nil, err
+	}
+	w.Events = events
+	return &w, nil
+}
+
+// Webhooks retreives every webhook, secrets included.
+func (s *Storage) Webhooks() ([]*models.Webhook, error) {
+	rows, err := s.db.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, w)
+	}
+	return res, rows.Err()
+}
+
+// GetWebhook retreives one webhook, secret included.
+func (s *Storage) GetWebhook(id int) (*models.Webhook, error) {
+	w, err := scanWebhook(s.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return w, err
+}
+
+// AddWebhook saves a new webhook, setting its ID.
+func (s *Storage) AddWebhook(w *models.Webhook) error {
+	return s.db.QueryRow(`INSERT INTO webhooks(url, secret, events, active, created) `+
+		`VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		w.URL, w.Secret, pq.StringArray(w.Events), w.Active, w.Created).Scan(&w.ID)
+}
+
+// UpdateWebhook saves the URL, secret, events and whether a webhook is
+// active.
+func (s *Storage) UpdateWebhook(w *models.Webhook) error {
+	res, err := s.db.Exec(`UPDATE webhooks SET url = $1, secret = $2, events = $3, active = $4 WHERE id = $5`,
+		w.URL, w.Secret, pq.StringArray(w.Events), w.Active, w.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook deletes a webhook along with its deliveries.
+func (s *Storage) DeleteWebhook(id int) error {
+	res, err := s.db.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddWebhookDelivery logs an attempt at delivering an event, setting its ID.
+func (s *Storage) AddWebhookDelivery(d *models.WebhookDelivery) error {
+	return s.db.QueryRow(`INSERT INTO webhook_deliveries(webhook_id, event_id, event, payload, attempt, status, `+
+		`error, time, duration_ms) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		d.Webhook, d.EventID, d.Event, d.Payload, d.Attempt, d.Status, d.Error, d.Time, d.Duration).Scan(&d.ID)
+}
+
+// PruneWebhookDeliveries deletes the deliveries made before the given time,
+// returning how many there were.
+func (s *Storage) PruneWebhookDeliveries(before time.Time) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM webhook_deliveries WHERE time < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// WebhookDeliveries retreives the latest deliveries to a webhook, newest
+// first.
+func (s *Storage) WebhookDeliveries(webhookID, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := s.db.Query(`SELECT id, webhook_id, event_id, event, payload, attempt, status, error, time, `+
+		`duration_ms FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.Webhook, &d.EventID, &d.Event, &d.Payload, &d.Attempt, &d.Status, &d.Error,
+			&d.Time, &d.Duration); err != nil {
+			return nil, err
+		}
+		res = append(res, &d)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,120 @@
+// Package vendors turns the merchant names reported by Sbanken into
+// canonical vendor names, so that purchases from the same shop are grouped
+// together no matter how the card terminal spelled its name.
+package vendors
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// chains are vendors which are commonly reported with a branch name after
+// the chain's name, as in "REMA 1000 MAJORSTUA".
+var chains = []string{
+	"7-ELEVEN",
+	"APOTEK 1",
+	"BUNNPRIS",
+	"BURGER KING",
+	"CIRCLE K",
+	"CLAS OHLSON",
+	"COOP EXTRA",
+	"COOP MEGA",
+	"COOP OBS",
+	"COOP PRIX",
+	"ELKJOP",
+	"ESSO",
+	"EUROSPAR",
+	"IKEA",
+	"JOKER",
+	"KIWI",
+	"MCDONALDS",
+	"MENY",
+	"NARVESEN",
+	"REMA 1000",
+	"SHELL",
+	"SPAR",
+	"VINMONOPOLET",
+	"VITUSAPOTEK",
+	"XXL",
+}
+
+var (
+	// letters directly followed by a number, as in "REMA1000"
+	lettersDigits = regexp.MustCompile(`^(\pL{3,})(\d+)\b`)
+	// store numbers, as in "KIWI #512"
+	storeNumber = regexp.MustCompile(`\s*#\s*\d+\b`)
+	// legal entity suffixes, as in "REMA 1000 AS"
+	legalSuffix = regexp.MustCompile(`\s+(AS|ASA|A/S|AB|NUF|DA|ANS|LTD|INC|GMBH|SA)\.?$`)
+	// characters other than letters, digits and a few common ones
+	junk = regexp.MustCompile(`[^\pL\d &'.+/-]+`)
+)
+
+// Normalize applies the built-in cleanup rules to a raw merchant name. city
+// is the merchant city of the purchase, which is often part of the name.
+func Normalize(raw, city string) string {
+	name := strings.ToUpper(raw)
+	name = storeNumber.ReplaceAllString(name, " ")
+	name = junk.ReplaceAllString(name, " ")
+	name = strings.Join(strings.Fields(name), " ")
+	name = lettersDigits.ReplaceAllString(name, "$1 $2")
+	name = legalSuffix.ReplaceAllString(name, "")
+
+	city = strings.ToUpper(strings.TrimSpace(city))
+	if city != "" && name != city {
+		name = strings.TrimSuffix(name, " "+city)
+	}
+
+	for _, chain := range chains {
+		if strings.HasPrefix(name, chain+" ") {
+			return chain
+		}
+	}
+	if name == "" {
+		return strings.TrimSpace(raw)
+	}
+	return name
+}
+
+// Aliases map merchant names to canonical vendor names. They are matched
+// case insensitively against both raw and normalized names.
+type Aliases map[string]string
+
+// NewAliases indexes a list of aliases.
+func NewAliases(list []*models.VendorAlias) Aliases {
+	a := make(Aliases)
+	for _, alias := range list {
+		a[key(alias.Raw)] = alias.Vendor
+	}
+	return a
+}
+
+// Vendor returns the canonical vendor name of a raw merchant name. Aliases
+// take precedence over the built-in rules.
+func (a Aliases) Vendor(raw, city string) string {
+	if v, ok := a[key(raw)]; ok {
+		return v
+	}
+	name := Normalize(raw, city)
+	if v, ok := a[key(name)]; ok {
+		return v
+	}
+	return name
+}
+
+func key(name string) string {
+	return strings.Join(strings.Fields(strings.ToUpper(name)), " ")
+}
+
+// Rename applies the stored aliases and the built-in rules to every purchase
+// in storage, returning the number of purchases whose vendor changed. The
+// raw merchant names are left as they are.
+func Rename(stor *storage.Storage) (int, error) {
+	list, err := stor.VendorAliases()
+	if err != nil {
+		return 0, err
+	}
+	return stor.RenameVendors(NewAliases(list).Vendor)
+}
@@ -0,0 +1,339 @@
+// Package webhooks posts events as JSON to the URLs configured through the
+// API, signing each payload along with the time it was sent with the secret
+// of the webhook.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/metrics"
+	"github.com/j18e/sbanken-client/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// The headers sent along with each payload.
+const (
+	HeaderEvent     = "X-Sbanken-Event"
+	HeaderDelivery  = "X-Sbanken-Delivery"
+	HeaderTimestamp = "X-Sbanken-Timestamp"
+	HeaderSignature = "X-Sbanken-Signature"
+)
+
+// pruneInterval is how often deliveries older than the retention are deleted.
+const pruneInterval = time.Hour
+
+// queueSize is how many events may wait to be delivered to a webhook before
+// events are dropped.
+const queueSize = 1000
+
+// Sign returns the signature of a payload sent at the given Unix time, which
+// is the hex encoded HMAC-SHA256 of the time, a dot and the payload keyed
+// with the secret of the webhook. Since the time is signed too, receivers can
+// reject payloads which are replayed later on.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random secret for signing payloads.
+func NewSecret() string {
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bs)
+}
+
+// Store is where the dispatcher finds the webhooks and logs its deliveries.
+type Store interface {
+	Webhooks() ([]*models.Webhook, error)
+	AddWebhookDelivery(d *models.WebhookDelivery) error
+	PruneWebhookDeliveries(before time.Time) (int, error)
+}
+
+// Dispatcher delivers the events published on a bus to every webhook which
+// wants them, retrying failed deliveries with exponential backoff. Each
+// webhook gets its events one at a time in the order they happened, from a
+// queue of its own. The log of deliveries, which holds the payloads, is kept
+// for as long as the retention.
+type Dispatcher struct {
+	storage   Store
+	bus       *events.Bus
+	client    http.Client
+	attempts  int
+	backoff   time.Duration
+	retention time.Duration
+
+	// the webhooks, loaded again once they've changed
+	mu    sync.Mutex
+	hooks []*models.Webhook
+	stale bool
+
+	// the queues of the webhooks by ID, which only Run touches
+	queues map[int]*queue
+
+	// deliveries in progress
+	wg sync.WaitGroup
+}
+
+// queue holds the events waiting to be delivered to a webhook.
+type queue struct {
+	jobs   chan job
+	cancel context.CancelFunc
+}
+
+// job is an event to deliver along with the webhook as it was when the event
+// was queued.
+type job struct {
+	hook *models.Webhook
+	e    events.Event
+}
+
+func NewDispatcher(conf config.Webhooks, stor Store, bus *events.Bus) *Dispatcher {
+	return &Dispatcher{
+		storage:   stor,
+		bus:       bus,
+		client:    http.Client{Timeout: conf.Timeout},
+		attempts:  conf.Attempts,
+		backoff:   conf.Backoff,
+		retention: conf.Retention,
+		stale:     true,
+	}
+}
+
+// HooksChanged makes the webhooks be loaded again before the next event is
+// delivered, for when they've been added, updated or deleted.
+func (d *Dispatcher) HooksChanged() {
+	d.mu.Lock()
+	d.stale = true
+	d.mu.Unlock()
+}
+
+// webhooks returns the webhooks, loading them if they've changed.
+func (d *Dispatcher) webhooks() ([]*models.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.stale {
+		return d.hooks, nil
+	}
+	hooks, err := d.storage.Webhooks()
+	if err != nil {
+		return nil, err
+	}
+	d.hooks, d.stale = hooks, false
+	return hooks, nil
+}
+
+func (d *Dispatcher) Run(ctx context.Context) error {
+	evs, unsubscribe := d.bus.Subscribe()
+	defer unsubscribe()
+	d.queues = make(map[int]*queue)
+	defer func() {
+		for id := range d.queues {
+			d.stop(id)
+		}
+		d.wg.Wait()
+	}()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+	d.prune()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-prune.C:
+			d.prune()
+		case e := <-evs:
+			if !events.ValidType(e.Type) {
+				continue
+			}
+			hooks, err := d.webhooks()
+			if err != nil {
+				log.Errorf("getting webhooks for %s event %s: %v", e.Type, e.ID, err)
+				continue
+			}
+			d.enqueue(ctx, hooks, e)
+		}
+	}
+}
+
+// enqueue adds an event to the queue of every webhook which wants it,
+// stopping the queues of webhooks which have been deleted since. Events for
+// a webhook which has fallen too far behind are dropped.
+func (d *Dispatcher) enqueue(ctx context.Context, hooks []*models.Webhook, e events.Event) {
+	current := make(map[int]bool)
+	for _, hook := range hooks {
+		current[hook.ID] = true
+		if !hook.Wants(e.Type) {
+			continue
+		}
+		q, ok := d.queues[hook.ID]
+		if !ok {
+			q = d.startQueue(ctx)
+			d.queues[hook.ID] = q
+		}
+		select {
+		case q.jobs <- job{hook, e}:
+		default:
+			log.Errorf("dropping %s event %s for webhook %d which has fallen behind", e.Type, e.ID, hook.ID)
+			metrics.WebhookDeliveries.WithLabelValues("dropped").Inc()
+		}
+	}
+	for id := range d.queues {
+		if !current[id] {
+			d.stop(id)
+		}
+	}
+}
+
+// startQueue starts delivering the events put on a new queue one by one.
+func (d *Dispatcher) startQueue(ctx context.Context) *queue {
+	ctx, cancel := context.WithCancel(ctx)
+	q := &queue{jobs: make(chan job, queueSize), cancel: cancel}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for j := range q.jobs {
+			// once stopped, what's left is dropped
+			if ctx.Err() == nil {
+				d.deliver(ctx, j.hook, j.e)
+			}
+		}
+	}()
+	return q
+}
+
+// stop stops delivering to a webhook, dropping the events still queued.
+func (d *Dispatcher) stop(id int) {
+	q := d.queues[id]
+	q.cancel()
+	close(q.jobs)
+	delete(d.queues, id)
+}
+
+// prune deletes the deliveries which are older than the retention.
+func (d *Dispatcher) prune() {
+	n, err := d.storage.PruneWebhookDeliveries(time.Now().Add(-d.retention))
+	if err != nil {
+		log.Errorf("deleting old webhook deliveries: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Infof("deleted %d webhook deliveries older than %v", n, d.retention)
+	}
+}
+
+// Ping sends a ping event to a webhook in the background, returning the ID of
+// the event so that its deliveries can be found.
+func (d *Dispatcher) Ping(hook *models.Webhook) string {
+	e := events.New(events.Ping)
+	d.start(context.Background(), hook, e)
+	return e.ID
+}
+
+func (d *Dispatcher) start(ctx context.Context, hook *models.Webhook, e events.Event) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.deliver(ctx, hook, e)
+	}()
+}
+
+// deliver posts an event to a webhook until it's accepted or the attempts
+// run out, logging every attempt.
+func (d *Dispatcher) deliver(ctx context.Context, hook *models.Webhook, e events.Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("encoding %s event %s: %v", e.Type, e.ID, err)
+		return
+	}
+
+	backoff := d.backoff
+	for attempt := 1; ; attempt++ {
+		delivery := &models.WebhookDelivery{
+			Webhook: hook.ID,
+			EventID: e.ID,
+			Event:   e.Type,
+			Payload: string(payload),
+			Attempt: attempt,
+			Time:    time.Now(),
+		}
+		retry, err := d.post(ctx, hook, e, payload, delivery)
+		delivery.Duration = int(time.Since(delivery.Time).Milliseconds())
+		if err != nil {
+			delivery.Error = err.Error()
+			metrics.WebhookDeliveries.WithLabelValues("failure").Inc()
+		} else {
+			metrics.WebhookDeliveries.WithLabelValues("success").Inc()
+		}
+		if err := d.storage.AddWebhookDelivery(delivery); err != nil {
+			log.Errorf("logging delivery of %s event %s to webhook %d: %v", e.Type, e.ID, hook.ID, err)
+		}
+
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= d.attempts {
+			log.Errorf("giving up delivering %s event %s to webhook %d after %d attempts: %v",
+				e.Type, e.ID, hook.ID, attempt, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one attempt at delivering an event, setting the status code of
+// the delivery. It returns whether a failed attempt is worth retrying, which
+// it isn't when the receiver refuses the request itself.
+func (d *Dispatcher) post(ctx context.Context, hook *models.Webhook, e events.Event, payload []byte,
+	delivery *models.WebhookDelivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sbanken-client")
+	req.Header.Set(HeaderEvent, e.Type)
+	req.Header.Set(HeaderDelivery, e.ID)
+	timestamp := time.Now().Unix()
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	delivery.Status = res.StatusCode
+	if res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusRequestTimeout ||
+		res.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("status %d", res.StatusCode)
+}
@@ -0,0 +1,244 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/models"
+)
+
+// memStore keeps webhooks and logs deliveries in memory.
+type memStore struct {
+	mu         sync.Mutex
+	hooks      []*models.Webhook
+	loads      int
+	deliveries []*models.WebhookDelivery
+	pruned     time.Time
+}
+
+func (m *memStore) Webhooks() ([]*models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loads++
+	return m.hooks, nil
+}
+
+func (m *memStore) AddWebhookDelivery(d *models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d.ID = len(m.deliveries) + 1
+	m.deliveries = append(m.deliveries, d)
+	return nil
+}
+
+func (m *memStore) PruneWebhookDeliveries(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruned = before
+	return 0, nil
+}
+
+func TestSign(t *testing.T) {
+	const secret = "It's a Secret to Everybody"
+	payload := []byte(`{"type":"ping"}`)
+	want := "sha256=359b5b39847c3f0ae47a6397f1998ffbcc8e5469b38b2269e157be4bbcf67da8"
+	if got := Sign(secret, 1600000000, payload); got != want {
+		t.Errorf("got signature %s, want %s", got, want)
+	}
+	if Sign(secret, 1600000001, payload) == want {
+		t.Error("the signature doesn't depend on the timestamp")
+	}
+	if Sign(secret+"x", 1600000000, payload) == want {
+		t.Error("the signature doesn't depend on the secret")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		want     []int
+	}{
+		{"accepted", []int{http.StatusOK}, []int{200}},
+		{"accepted without content", []int{http.StatusNoContent}, []int{204}},
+		{"refused", []int{http.StatusBadRequest}, []int{400}},
+		{"gone", []int{http.StatusGone}, []int{410}},
+		{"accepted on retry", []int{http.StatusBadGateway, http.StatusOK}, []int{502, 200}},
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusOK}, []int{429, 200}},
+		{"timed out", []int{http.StatusRequestTimeout, http.StatusOK}, []int{408, 200}},
+		{"attempts run out", []int{http.StatusInternalServerError}, []int{500, 500, 500}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := &models.Webhook{ID: 7, Secret: NewSecret()}
+			e := events.New(events.Ping)
+			var requests int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ts, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+				if err != nil {
+					t.Errorf("invalid timestamp header: %v", err)
+				} else if age := time.Since(time.Unix(ts, 0)); age < -time.Second || age > 5*time.Second {
+					t.Errorf("timestamp is %v old", age)
+				}
+				if got, want := r.Header.Get(HeaderSignature), Sign(hook.Secret, ts, body); got != want {
+					t.Errorf("got signature %s, want %s", got, want)
+				}
+				if r.Header.Get(HeaderEvent) != e.Type || r.Header.Get(HeaderDelivery) != e.ID {
+					t.Errorf("got event %s %s, want %s %s", r.Header.Get(HeaderEvent),
+						r.Header.Get(HeaderDelivery), e.Type, e.ID)
+				}
+				status := tc.statuses[len(tc.statuses)-1]
+				if requests < len(tc.statuses) {
+					status = tc.statuses[requests]
+				}
+				requests++
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+			hook.URL = srv.URL
+
+			store := &memStore{}
+			d := &Dispatcher{storage: store, client: http.Client{Timeout: time.Second}, attempts: 3,
+				backoff: time.Millisecond}
+			d.deliver(context.Background(), hook, e)
+
+			if len(store.deliveries) != len(tc.want) {
+				t.Fatalf("logged %d deliveries, want %d", len(store.deliveries), len(tc.want))
+			}
+			for i, dl := range store.deliveries {
+				if dl.Status != tc.want[i] || dl.Attempt != i+1 || dl.Webhook != hook.ID || dl.EventID != e.ID {
+					t.Errorf("delivery %d: got attempt %d of event %s to webhook %d with status %d, "+
+						"want attempt %d of event %s to webhook %d with status %d", i, dl.Attempt, dl.EventID,
+						dl.Webhook, dl.Status, i+1, e.ID, hook.ID, tc.want[i])
+				}
+				if failed := dl.Status >= 300; failed != (dl.Error != "") {
+					t.Errorf("delivery %d with status %d has error %q", i, dl.Status, dl.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	store := &memStore{}
+	d := &Dispatcher{storage: store, client: http.Client{Timeout: time.Second}, attempts: 2,
+		backoff: time.Millisecond}
+	d.deliver(context.Background(), &models.Webhook{ID: 1, URL: srv.URL}, events.New(events.Ping))
+
+	if len(store.deliveries) != 2 {
+		t.Fatalf("logged %d deliveries, want 2", len(store.deliveries))
+	}
+	for _, dl := range store.deliveries {
+		if dl.Status != 0 || dl.Error == "" {
+			t.Errorf("got status %d and error %q, want no status and an error", dl.Status, dl.Error)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store := &memStore{}
+	d := &Dispatcher{storage: store, retention: 24 * time.Hour}
+	d.prune()
+	if age := time.Since(store.pruned); age < 24*time.Hour || age > 24*time.Hour+time.Minute {
+		t.Errorf("pruned deliveries older than %v, want 24h", age)
+	}
+}
+
+func TestQueues(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first event is slow to deliver, which mustn't let the rest
+		// overtake it
+		mu.Lock()
+		first := len(received[r.URL.Path]) == 0
+		mu.Unlock()
+		if first {
+			time.Sleep(50 * time.Millisecond)
+		}
+		mu.Lock()
+		received[r.URL.Path] = append(received[r.URL.Path], r.Header.Get(HeaderDelivery))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	store := &memStore{hooks: []*models.Webhook{
+		{ID: 1, URL: srv.URL + "/a", Active: true},
+		{ID: 2, URL: srv.URL + "/b", Active: true, Events: []string{events.SyncFinished}},
+		{ID: 3, URL: srv.URL + "/c", Active: false},
+	}}
+	d := NewDispatcher(config.Webhooks{Timeout: time.Second, Attempts: 1, Backoff: time.Millisecond}, store, nil)
+	d.queues = make(map[int]*queue)
+	ctx := context.Background()
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		hooks, err := d.webhooks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := events.New(events.PurchaseCreated)
+		want = append(want, e.ID)
+		d.enqueue(ctx, hooks, e)
+	}
+	if store.loads != 1 {
+		t.Errorf("loaded the webhooks %d times, want once", store.loads)
+	}
+	if len(d.queues) != 1 {
+		t.Errorf("started %d queues, want 1", len(d.queues))
+	}
+	delivered := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), received["/a"]...)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(delivered()) < len(want); {
+		if time.Now().After(deadline) {
+			t.Fatalf("delivered %d of %d events", len(delivered()), len(want))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := delivered(); !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+
+	// deleting the webhook stops its queue
+	store.mu.Lock()
+	store.hooks = store.hooks[1:]
+	store.mu.Unlock()
+	d.HooksChanged()
+	hooks, err := d.webhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.loads != 2 {
+		t.Errorf("loaded the webhooks %d times after they changed, want twice", store.loads)
+	}
+	d.enqueue(ctx, hooks, events.New(events.PurchaseCreated))
+	if len(d.queues) != 0 {
+		t.Errorf("%d queues left after the webhook was deleted, want none", len(d.queues))
+	}
+	d.wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received["/a"]) != len(want) || len(received["/b"]) > 0 || len(received["/c"]) > 0 {
+		t.Errorf("got deliveries %v, want only the %d events to /a", received, len(want))
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// filterFlags adds the flags narrowing down purchases to fs, returning a
+// function which builds the filter once they're parsed.
+func filterFlags(fs *flag.FlagSet) func() (storage.Filter, error) {
+	from := fs.String("from", "", "first date, as yyyy-mm-dd")
+	to := fs.String("to", "", "last date, as yyyy-mm-dd")
+	var filter storage.Filter
+	fs.StringVar(&filter.Category, "category", "", "only purchases in this category")
+	fs.StringVar(&filter.Vendor, "vendor", "", "only purchases from this vendor")
+	fs.StringVar(&filter.Account, "account", "", "only purchases from this account")
+	fs.StringVar(&filter.Tag, "tag", "", "only purchases with this tag")
+	return func() (storage.Filter, error) {
+		var err error
+		if *from != "" {
+			if filter.From, err = models.ParseDate(*from); err != nil {
+				return filter, err
+			}
+		}
+		if *to != "" {
+			if filter.To, err = models.ParseDate(*to); err != nil {
+				return filter, err
+			}
+		}
+		return filter, nil
+	}
+}
+
+// runPurchases manages stored purchases, as in:
+//
+//	sbanken-client purchases list -from 2020-01-01 -vendor Rema
+//	sbanken-client purchases show <id>
+//	sbanken-client purchases delete <id>...
+func runPurchases(cfg *config.Config, args []string) error {
+	const usage = "usage: purchases list|show|delete [flags] [id...]"
+	if len(args) < 1 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "list":
+		return listPurchases(cfg, args[1:])
+	case "show":
+		return showPurchases(cfg, args[1:])
+	case "delete":
+		return deletePurchases(cfg, args[1:])
+	}
+	return fmt.Errorf("unknown purchases command %q - %s", args[0], usage)
+}
+
+func listPurchases(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("purchases list", flag.ExitOnError)
+	filter := filterFlags(fs)
+	text := fs.String("q", "", "only purchases whose vendor, location, category or notes contain this")
+	sort := fs.String("sort", "-date", "field to sort by, prefixed with - for descending order")
+	limit := fs.Int("limit", 100, "most purchases to list, 0 for all of them")
+	asJSON := fs.Bool("json", false, "print the purchases as json lines")
+	fs.Parse(args)
+
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	f.Text = *text
+	q := storage.Query{Filter: f, Sort: *sort}
+
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !*asJSON {
+		fmt.Fprintln(tw, "DATE\tNOK\tACCOUNT\tCATEGORY\tVENDOR\tID")
+	}
+	// read pages of at most pageSize purchases
+	const pageSize = 1000
+	var listed int
+	for *limit == 0 || listed < *limit {
+		q.Limit = pageSize
+		if *limit > 0 && *limit-listed < pageSize {
+			q.Limit = *limit - listed
+		}
+		page, err := stor.QueryPurchases(q)
+		if err != nil {
+			return err
+		}
+		for _, p := range page.Purchases {
+			if *asJSON {
+				if err := enc.Encode(p); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", p.Date.Stamp(), p.NOK, p.Account, p.Category, p.Vendor, p.ID)
+		}
+		listed += len(page.Purchases)
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	return tw.Flush()
+}
+
+func showPurchases(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("purchases show", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fmt.Errorf("purchases show: give the ID of at least one purchase")
+	}
+
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	for _, id := range fs.Args() {
+		p, err := stor.GetPurchase(id)
+		if err == storage.ErrNotFound {
+			return fmt.Errorf("purchase %s not found", id)
+		} else if err != nil {
+			return err
+		}
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deletePurchases(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("purchases delete", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fmt.Errorf("purchases delete: give the ID of at least one purchase")
+	}
+
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, id := range fs.Args() {
+		if err := stor.DeletePurchase(id); err == storage.ErrNotFound {
+			missing = append(missing, id)
+			continue
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("deleted %s\n", id)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("purchases not found: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/notifications"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// runReport prints the spending report of this month, or sends it to
+// everyone who gets it, as the server does at NOTIFY_HOUR.
+func runReport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	user := fs.String("user", "", "print the report of this user rather than of every purchase")
+	send := fs.Bool("send", false, "send the reports through pushover rather than printing")
+	fs.Parse(args)
+
+	if *send && *user != "" {
+		return fmt.Errorf("report: -send goes to everyone who gets reports, so it can't be combined with -user")
+	}
+
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		return err
+	}
+	notifier, err := notifications.NewNotifier(cfg.Notifications, stor)
+	if err != nil {
+		return err
+	}
+	if *send {
+		return notifier.SendReports()
+	}
+	msg, err := notifier.Report(*user)
+	if err != nil {
+		return err
+	}
+	fmt.Println(msg)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/j18e/sbanken-client/pkg/config"
+)
+
+// runSecrets manages the encrypted secrets file, which SECRETS_PASSPHRASE
+// unlocks, as in:
+//
+//	sbanken-client secrets list
+//	sbanken-client secrets set DB_PASSWORD < password.txt
+//	sbanken-client secrets unset DB_PASSWORD
+//
+// Values are read from stdin so that they don't end up in the shell history.
+func runSecrets(cfg *config.Config, args []string) error {
+	usage := "usage: secrets list|set|unset [name] - names are one of " + strings.Join(config.SecretNames(), ", ")
+	fs := flag.NewFlagSet("secrets", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), usage)
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return errors.New(usage)
+	}
+
+	store, err := cfg.OpenSecrets()
+	if err != nil {
+		return err
+	}
+	switch cmd := fs.Arg(0); {
+	case cmd == "list" && fs.NArg() == 1:
+		for _, name := range store.Names() {
+			fmt.Println(name)
+		}
+		return nil
+	case cmd == "set" && fs.NArg() == 2:
+		name := fs.Arg(1)
+		if !isSecret(name) {
+			return fmt.Errorf("%s isn't a secret - %s", name, usage)
+		}
+		value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if value = strings.TrimRight(value, "\r\n"); value == "" {
+			if err != nil {
+				return fmt.Errorf("reading the value of %s: %w", name, err)
+			}
+			return fmt.Errorf("the value of %s can't be empty", name)
+		}
+		store.Set(name, value)
+		if err := store.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("set %s\n", name)
+		return nil
+	case cmd == "unset" && fs.NArg() == 2:
+		if !store.Delete(fs.Arg(1)) {
+			return fmt.Errorf("%s isn't in %s", fs.Arg(1), cfg.SecretsFile)
+		}
+		if err := store.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("unset %s\n", fs.Arg(1))
+		return nil
+	}
+	return errors.New(usage)
+}
+
+func isSecret(name string) bool {
+	for _, n := range config.SecretNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/j18e/sbanken-client/pkg/auth"
+	"github.com/j18e/sbanken-client/pkg/client"
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/events"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/notifications"
+	"github.com/j18e/sbanken-client/pkg/server"
+	"github.com/j18e/sbanken-client/pkg/storage"
+	"github.com/j18e/sbanken-client/pkg/vendors"
+	"github.com/j18e/sbanken-client/pkg/webhooks"
+	"github.com/oklog/run"
+	log "github.com/sirupsen/logrus"
+)
+
+// runServe starts everything: the web server, loading purchases every 6
+// hours, notifications, webhooks and budget alerts.
+func runServe(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Parse(args)
+
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		return err
+	}
+	bus := events.NewBus()
+
+	// syncs which were running when the server last stopped never finished
+	if err := stor.AbandonSyncRuns(); err != nil {
+		return fmt.Errorf("marking interrupted syncs: %w", err)
+	}
+	cli := client.NewClient(cfg.Sbanken, stor, bus)
+
+	// apply any changes to the vendor normalization rules to stored purchases
+	if n, err := vendors.Rename(stor); err != nil {
+		return fmt.Errorf("renaming vendors: %w", err)
+	} else if n > 0 {
+		log.Infof("renamed the vendor of %d purchases", n)
+	}
+
+	// make sure everything works a first time
+	if run := cli.Sync(models.SyncStartup); run.Error != "" {
+		return fmt.Errorf("syncing: %s", run.Error)
+	}
+
+	notifier, err := notifications.NewNotifier(cfg.Notifications, stor)
+	if err != nil {
+		return err
+	}
+
+	authn, err := auth.NewAuth(cfg.Auth, stor)
+	if err != nil {
+		return err
+	}
+
+	hooks := webhooks.NewDispatcher(cfg.Webhooks, stor, bus)
+	budgets := events.NewBudgetWatcher(stor, bus, cfg.Server.Budget)
+
+	srv, err := server.NewServer(cfg.Server, stor, authn, cli, bus, hooks)
+	if err != nil {
+		return err
+	}
+	if err := srv.Routes(); err != nil {
+		return err
+	}
+
+	var g run.Group
+	{
+		// add the data loader
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return cli.Loop(ctx, cfg.Sync.Interval)
+		}, func(error) {
+			cancel()
+		})
+	}
+	{
+		// add the http server
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return srv.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+	{
+		// add the notifier
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return notifier.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	{
+		// add the webhooks
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return hooks.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+	{
+		// add the budget watcher
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return budgets.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	// react to ctrl+c
+	g.Add(run.SignalHandler(context.Background(), os.Interrupt, os.Kill))
+
+	return fmt.Errorf("the server was terminated with %v", g.Run())
+}
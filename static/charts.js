@@ -0,0 +1,168 @@
+// Small SVG charts drawn without any external libraries.
+
+const chartColors = [
+  '#3273dc', '#23d160', '#ffdd57', '#ff3860', '#209cee',
+  '#9b59b6', '#e67e22', '#1abc9c', '#7f8c8d', '#f39c12',
+];
+
+function chartColor(i) {
+  return chartColors[i % chartColors.length];
+}
+
+function svgElement(name, attrs) {
+  const el = document.createElementNS('http://www.w3.org/2000/svg', name);
+  for (const key in attrs) {
+    el.setAttribute(key, attrs[key]);
+  }
+  return el;
+}
+
+function svgText(x, y, text, attrs) {
+  const el = svgElement('text', Object.assign({x: x, y: y, 'font-size': 12, fill: 'currentColor'}, attrs));
+  el.textContent = text;
+  return el;
+}
+
+function chartLegend(container, names) {
+  const legend = document.createElement('div');
+  legend.classList.add('chart-legend');
+  for (var i = 0; i < names.length; i++) {
+    const item = document.createElement('span');
+    item.style.marginRight = '1rem';
+    item.innerHTML = `<span style="display:inline-block;width:0.8rem;height:0.8rem;background:${chartColor(i)}"></span> `;
+    item.appendChild(document.createTextNode(names[i]));
+    legend.appendChild(item);
+  }
+  container.appendChild(legend);
+}
+
+// barChart draws vertical bars for each label, stacking the values of each
+// series on top of each other. series is a list of {name, values}.
+function barChart(container, labels, series) {
+  const width = 800, height = 300, pad = 40;
+  const svg = svgElement('svg', {viewBox: `0 0 ${width} ${height}`, width: '100%'});
+
+  var max = 0;
+  for (var i = 0; i < labels.length; i++) {
+    var sum = 0;
+    for (const s of series) {
+      sum += s.values[i];
+    }
+    max = Math.max(max, sum);
+  }
+  if (max == 0) {
+    max = 1;
+  }
+
+  const slot = (width - pad) / labels.length;
+  const scale = (height - 2 * pad) / max;
+  svg.appendChild(svgText(0, pad, max, {}));
+  for (var i = 0; i < labels.length; i++) {
+    var y = height - pad;
+    for (var j = 0; j < series.length; j++) {
+      const h = series[j].values[i] * scale;
+      if (h <= 0) {
+        continue;
+      }
+      y -= h;
+      const bar = svgElement('rect', {
+        x: pad + i * slot + slot * 0.1, y: y, width: slot * 0.8, height: h, fill: chartColor(j),
+      });
+      const title = svgElement('title', {});
+      title.textContent = `${series[j].name}: ${series[j].values[i]} NOK`;
+      bar.appendChild(title);
+      svg.appendChild(bar);
+    }
+    svg.appendChild(svgText(pad + i * slot + slot / 2, height - pad / 2, labels[i], {'text-anchor': 'middle'}));
+  }
+
+  container.appendChild(svg);
+  if (series.length > 1) {
+    chartLegend(container, series.map(s => s.name));
+  }
+}
+
+// donutChart draws the share of each value as a slice of a ring.
+function donutChart(container, labels, values) {
+  const size = 300, r = 120, inner = 70;
+  const svg = svgElement('svg', {viewBox: `0 0 ${size} ${size}`, width: '100%', style: 'max-width:20rem'});
+  const total = values.reduce((a, b) => a + b, 0);
+  var angle = -Math.PI / 2;
+  for (var i = 0; i < values.length; i++) {
+    if (values[i] <= 0) {
+      continue;
+    }
+    // a full circle can't be drawn as a single arc
+    const sweep = Math.min(values[i] / total, 0.9999) * 2 * Math.PI;
+    const end = angle + sweep;
+    const c = size / 2, large = sweep > Math.PI ? 1 : 0;
+    const point = (rad, a) => `${c + rad * Math.cos(a)} ${c + rad * Math.sin(a)}`;
+    const path = svgElement('path', {
+      d: `M ${point(r, angle)} A ${r} ${r} 0 ${large} 1 ${point(r, end)} ` +
+        `L ${point(inner, end)} A ${inner} ${inner} 0 ${large} 0 ${point(inner, angle)} Z`,
+      fill: chartColor(i),
+    });
+    const title = svgElement('title', {});
+    title.textContent = `${labels[i]}: ${values[i]} NOK (${Math.round(values[i] / total * 100)}%)`;
+    path.appendChild(title);
+    svg.appendChild(path);
+    angle = end;
+  }
+  svg.appendChild(svgText(size / 2, size / 2 + 6, `${total} NOK`, {'text-anchor': 'middle', 'font-size': 18}));
+  container.appendChild(svg);
+  chartLegend(container, labels);
+}
+
+// lineChart draws a line per series over the labels. Values which are null
+// are left out of the line.
+function lineChart(container, labels, series) {
+  const width = 800, height = 300, pad = 40;
+  const svg = svgElement('svg', {viewBox: `0 0 ${width} ${height}`, width: '100%'});
+
+  var max = 1;
+  for (const s of series) {
+    for (const v of s.values) {
+      max = Math.max(max, v || 0);
+    }
+  }
+  const step = (width - 2 * pad) / Math.max(labels.length - 1, 1);
+  const scale = (height - 2 * pad) / max;
+  svg.appendChild(svgText(0, pad, max, {}));
+  for (var i = 0; i < labels.length; i++) {
+    if (i % 5 == 0 || i == labels.length - 1) {
+      svg.appendChild(svgText(pad + i * step, height - pad / 2, labels[i], {'text-anchor': 'middle'}));
+    }
+  }
+  for (var j = 0; j < series.length; j++) {
+    var points = [];
+    for (var i = 0; i < series[j].values.length; i++) {
+      if (series[j].values[i] === null) {
+        continue;
+      }
+      points.push(`${pad + i * step},${height - pad - series[j].values[i] * scale}`);
+    }
+    svg.appendChild(svgElement('polyline', {
+      points: points.join(' '), fill: 'none', stroke: chartColor(j), 'stroke-width': 2,
+      'stroke-dasharray': series[j].dashed ? '6 4' : '',
+    }));
+  }
+  container.appendChild(svg);
+  chartLegend(container, series.map(s => s.name));
+}
+
+// horizontalBarChart draws a bar per label, longest first as given.
+function horizontalBarChart(container, labels, values) {
+  const width = 800, row = 24, labelWidth = 250;
+  const height = row * labels.length;
+  const svg = svgElement('svg', {viewBox: `0 0 ${width} ${height}`, width: '100%'});
+  const max = Math.max(1, ...values);
+  const scale = (width - labelWidth - 100) / max;
+  for (var i = 0; i < labels.length; i++) {
+    svg.appendChild(svgText(labelWidth - 8, i * row + row * 0.7, labels[i], {'text-anchor': 'end'}));
+    svg.appendChild(svgElement('rect', {
+      x: labelWidth, y: i * row + row * 0.15, width: values[i] * scale, height: row * 0.7, fill: chartColor(0),
+    }));
+    svg.appendChild(svgText(labelWidth + values[i] * scale + 8, i * row + row * 0.7, `${values[i]} NOK`, {}));
+  }
+  container.appendChild(svg);
+}
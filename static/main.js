@@ -4,6 +4,9 @@ function addToSelectors(id, cellType) {
   items = [];
 
   for (var i = 0; i < rows.length; i++) {
+    if (rows[i].getElementsByClassName(cellType).length < 1) {
+      continue;
+    }
     item = rows[i].getElementsByClassName(cellType)[0].textContent;
     if (items.includes(item)) {
       continue
@@ -24,6 +27,9 @@ function selectOpt(sel, cellType) {
   rows = document.querySelector('#spending-table').querySelector('tbody').querySelectorAll('tr');
 
   for (var i = 0; i < rows.length; i++) {
+    if (rows[i].getElementsByClassName(cellType).length < 1) {
+      continue;
+    }
     if (val == 'all') {
       rows[i].style.display = '';
     } else if (rows[i].getElementsByClassName(cellType)[0].textContent != val) {
@@ -41,10 +47,134 @@ function updateTotal() {
   sel = document.querySelector('#spending-total');
   rows = document.querySelector('#spending-table').querySelector('tbody').querySelectorAll('tr');
   for (var i = 0; i < rows.length; i++) {
-    if (rows[i].style.display == 'none') {
+    if (rows[i].style.display == 'none' || rows[i].getElementsByClassName("nok-cell").length < 1) {
       continue;
     }
     total += parseInt(rows[i].getElementsByClassName("nok-cell")[0].textContent);
   }
   sel.textContent = `Total: ${total} NOK`;
 }
+
+// apiFetch works like fetch, but sends the CSRF token the server requires for
+// requests which change anything.
+function apiFetch(url, opts) {
+  opts = opts || {};
+  opts.credentials = 'same-origin';
+  opts.headers = opts.headers || {};
+  var match = document.cookie.match(/(?:^|;\s*)sbanken_csrf=([^;]*)/);
+  if (match) {
+    opts.headers['X-CSRF-Token'] = decodeURIComponent(match[1]);
+  }
+  return fetch(url, opts).then(function(res) {
+    if (res.status == 401) {
+      window.location = '/login?next=' + encodeURIComponent(window.location.pathname);
+    }
+    return res;
+  });
+}
+
+function logout() {
+  apiFetch('/logout', {method: 'POST'}).then(function() {
+    window.location = '/login';
+  });
+}
+
+// syncNow loads purchases from the bank, then shows the sync history.
+function syncNow(button) {
+  button.classList.add('is-loading');
+  apiFetch('/api/sync?wait=true', {method: 'POST'}).then(function(res) {
+    button.classList.remove('is-loading');
+    if (res.ok) {
+      window.location = '/sync';
+    }
+  });
+}
+
+// purchasesChanged refreshes the category select, keeping the category
+// chosen if it's still there, and the total once rows have come or gone.
+function purchasesChanged() {
+  sel = document.getElementById('category-select').querySelector('select');
+  selected = sel.value;
+  while (sel.options.length > 1) {
+    sel.remove(1);
+  }
+  addToSelectors('category-select', 'category-cell');
+  sel.value = selected;
+  if (sel.selectedIndex < 0) {
+    sel.selectedIndex = 0;
+  }
+  selectOpt(sel, 'category-cell');
+}
+
+// liveUpdates keeps the table and total of a month's spending page up to date
+// with the changes streamed from the server. Rows are rendered by the server,
+// and rows being edited are left alone.
+function liveUpdates(year, month, tag) {
+  if (!window.EventSource) {
+    return;
+  }
+  const source = new EventSource('/api/events');
+  const shown = function(p) {
+    return p.date.year == year && p.date.month == month && (!tag || (p.tags || []).includes(tag));
+  };
+  const busy = function(row) {
+    return row.classList.contains('currently-editing') || row.classList.contains('currently-deleting');
+  };
+
+  const onPurchase = function(msg) {
+    const p = JSON.parse(msg.data).purchase;
+    const row = document.getElementById('purchase-' + p.id);
+    if (msg.type == 'purchase.deleted' || !shown(p)) {
+      if (row && !busy(row)) {
+        row.parentNode.removeChild(row);
+        purchasesChanged();
+      }
+      return;
+    }
+    fetch('/purchase/' + encodeURIComponent(p.id) + '/row', {credentials: 'same-origin'})
+      .then(function(res) {
+        if (!res.ok) {
+          throw Error(res.statusText);
+        }
+        return res.text();
+      })
+      .then(function(html) {
+        const body = document.getElementById('spending-table-body');
+        const rows = document.createElement('tbody');
+        rows.innerHTML = html;
+        const newRow = rows.querySelector('tr');
+        const current = document.getElementById('purchase-' + p.id);
+        if (current) {
+          if (!busy(current)) {
+            body.replaceChild(newRow, current);
+          }
+        } else {
+          // rows are ordered by date
+          const stamp = newRow.querySelector('.date-cell').textContent;
+          var next = null;
+          for (let r of body.querySelectorAll('tr')) {
+            const cell = r.querySelector('.date-cell');
+            if (cell && !cell.querySelector('input') && cell.textContent > stamp) {
+              next = r;
+              break;
+            }
+          }
+          body.insertBefore(newRow, next);
+        }
+        purchasesChanged();
+      })
+      .catch(err => console.log(err));
+  };
+  for (let type of ['purchase.created', 'purchase.updated', 'purchase.deleted']) {
+    source.addEventListener(type, onPurchase);
+  }
+
+  // the server's total is only the page's when nothing is filtered out
+  source.addEventListener('totals', function(msg) {
+    const totals = JSON.parse(msg.data);
+    const sel = document.getElementById('category-select').querySelector('select');
+    if (totals.month == `${year}-${String(month).padStart(2, '0')}` && !tag && sel.value == 'all') {
+      document.querySelector('#spending-total').textContent = `Total: ${totals.nok} NOK`;
+    }
+  });
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/j18e/sbanken-client/pkg/client"
+	"github.com/j18e/sbanken-client/pkg/config"
+	"github.com/j18e/sbanken-client/pkg/models"
+	"github.com/j18e/sbanken-client/pkg/storage"
+)
+
+// runSync loads the latest purchases once, for running from cron rather than
+// alongside the server.
+func runSync(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	fs.Parse(args)
+
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		return err
+	}
+	run := client.NewClient(cfg.Sbanken, stor, nil).Sync(models.SyncManual)
+	return printSyncRun(os.Stdout, run)
+}
+
+// runBackfill loads the purchases of a period, as in:
+//
+//	sbanken-client backfill -from 2019-01-01 -to 2019-12-31
+func runBackfill(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	from := fs.String("from", "", "first date to load, as yyyy-mm-dd (required)")
+	to := fs.String("to", "", "last date to load, as yyyy-mm-dd (defaults to today)")
+	fs.Parse(args)
+
+	if *from == "" {
+		return errors.New("backfill: -from is required")
+	}
+	start, err := models.ParseDate(*from)
+	if err != nil {
+		return err
+	}
+	end := models.DateToday()
+	if *to != "" {
+		if end, err = models.ParseDate(*to); err != nil {
+			return err
+		}
+	}
+	if end.Time().Before(start.Time()) {
+		return fmt.Errorf("backfill: %s is before %s", end.Stamp(), start.Stamp())
+	}
+
+	stor, err := storage.NewStorage(cfg.Database)
+	if err != nil {
+		return err
+	}
+	run := client.NewClient(cfg.Sbanken, stor, nil).Backfill(start, end)
+	return printSyncRun(os.Stdout, run)
+}
+
+// printSyncRun writes how a sync went, returning its error if it failed.
+func printSyncRun(w io.Writer, run *models.SyncRun) error {
+	for _, a := range run.Accounts {
+		fmt.Fprintf(w, "%s: %d fetched, %d new, %d updated, %d already stored\n",
+			a.Account, a.Fetched, a.Added, a.Updated, a.Duplicates)
+		if a.Error != "" {
+			fmt.Fprintf(w, "%s: %s\n", a.Account, a.Error)
+		}
+	}
+	if run.Error != "" {
+		return fmt.Errorf("sync %d failed: %s", run.ID, run.Error)
+	}
+	return nil
+}